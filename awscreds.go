@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	defaultAWSSessionName = "ingress53"
+
+	envAWSWebIdentityTokenFile = "AWS_WEB_IDENTITY_TOKEN_FILE"
+	envAWSRoleARN              = "AWS_ROLE_ARN"
+	envAWSRoleSessionName      = "AWS_ROLE_SESSION_NAME"
+)
+
+var (
+	errInvalidRoleARN = errors.New("aws role arns must be in the arn:partition:iam::account:role/name format")
+
+	// awsCredentialsExpiryWindow is how long before they expire temporary
+	// credentials are refreshed.
+	awsCredentialsExpiryWindow = time.Minute
+)
+
+// awsOptions are the aws settings of the route53 provider.
+type awsOptions struct {
+	RoleARN     string `json:"roleARN"`     // assumed for the zones without a role of their own
+	ExternalID  string `json:"externalID"`  // passed when assuming RoleARN, if required by its trust policy
+	SessionName string `json:"sessionName"` // defaults to ingress53
+	// Region and Route53Endpoint override the ones of the environment, eg.
+	// to use a local route53 compatible api such as moto or localstack
+	Region          string `json:"region"`
+	Route53Endpoint string `json:"route53Endpoint"`
+}
+
+// awsRole is a role assumed to manage zones in another account.
+type awsRole struct {
+	ARN         string
+	ExternalID  string
+	SessionName string
+}
+
+func validateRoleARN(arn string) error {
+	if arn != "" && (!strings.HasPrefix(arn, "arn:") || !strings.Contains(arn, ":role/")) {
+		return errInvalidRoleARN
+	}
+	return nil
+}
+
+// roleForZone returns the role to assume for the zone: its own, if it has
+// one, or the default one. The ARN is empty if no role needs to be assumed.
+func roleForZone(id string, options registratorOptions) awsRole {
+	role, ok := options.ZoneRoles[id]
+	if !ok {
+		role = awsRole{ARN: options.AWS.RoleARN, ExternalID: options.AWS.ExternalID}
+	}
+	role.SessionName = options.AWS.SessionName
+	if role.SessionName == "" {
+		role.SessionName = defaultAWSSessionName
+	}
+	return role
+}
+
+// newAWSSession returns a session with the credentials of the environment.
+// If AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN are set, eg. by IAM roles
+// for service accounts, the session uses the web identity instead, as the
+// sdk version in use does not support it.
+func newAWSSession(options registratorOptions) (*session.Session, error) {
+	so := *options.AWSSessionOptions
+	if options.AWS.Region != "" {
+		so.Config.Region = aws.String(options.AWS.Region)
+	}
+	sess, err := session.NewSessionWithOptions(so)
+	if err != nil {
+		return nil, err
+	}
+	tokenFile, roleARN := os.Getenv(envAWSWebIdentityTokenFile), os.Getenv(envAWSRoleARN)
+	if tokenFile == "" || roleARN == "" {
+		return sess, nil
+	}
+	sessionName := os.Getenv(envAWSRoleSessionName)
+	if sessionName == "" {
+		sessionName = roleForZone("", options).SessionName
+	}
+	log.Printf("[INFO] using the web identity in %s to assume role %s", tokenFile, roleARN)
+	p := &webIdentityProvider{
+		client:      sts.New(sess, &aws.Config{Credentials: credentials.AnonymousCredentials}),
+		roleARN:     roleARN,
+		sessionName: sessionName,
+		tokenFile:   tokenFile,
+	}
+	return sess.Copy(&aws.Config{Credentials: credentials.NewCredentials(p)}), nil
+}
+
+// assumeRoleCredentials returns credentials of the role, which are refreshed
+// before they expire.
+func assumeRoleCredentials(sess *session.Session, role awsRole) *credentials.Credentials {
+	return stscreds.NewCredentials(sess, role.ARN, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = role.SessionName
+		p.ExpiryWindow = awsCredentialsExpiryWindow
+		if role.ExternalID != "" {
+			p.ExternalID = aws.String(role.ExternalID)
+		}
+	})
+}
+
+type webIdentityAssumer interface {
+	AssumeRoleWithWebIdentity(*sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error)
+}
+
+// webIdentityProvider retrieves the credentials of a role by exchanging a web
+// identity token, eg. a kubernetes service account token, with sts.
+type webIdentityProvider struct {
+	credentials.Expiry
+	client      webIdentityAssumer
+	roleARN     string
+	sessionName string
+	tokenFile   string
+}
+
+// Retrieve reads the token every time, as it's rotated while running.
+func (p *webIdentityProvider) Retrieve() (credentials.Value, error) {
+	token, err := ioutil.ReadFile(p.tokenFile)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	out, err := p.client.AssumeRoleWithWebIdentity(&sts.AssumeRoleWithWebIdentityInput{
+		RoleArn:          aws.String(p.roleARN),
+		RoleSessionName:  aws.String(p.sessionName),
+		WebIdentityToken: aws.String(strings.TrimSpace(string(token))),
+	})
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	p.SetExpiration(aws.TimeValue(out.Credentials.Expiration), awsCredentialsExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     aws.StringValue(out.Credentials.AccessKeyId),
+		SecretAccessKey: aws.StringValue(out.Credentials.SecretAccessKey),
+		SessionToken:    aws.StringValue(out.Credentials.SessionToken),
+		ProviderName:    "WebIdentityProvider",
+	}, nil
+}
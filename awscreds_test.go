@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestValidateRoleARN(t *testing.T) {
+	testCases := []struct {
+		arn string
+		err error
+	}{
+		{"", nil},
+		{"arn:aws:iam::123456789012:role/dns", nil},
+		{"arn:aws-cn:iam::123456789012:role/path/dns", nil},
+		{"dns", errInvalidRoleARN},
+		{"arn:aws:iam::123456789012:user/dns", errInvalidRoleARN},
+	}
+	for i, tc := range testCases {
+		if err := validateRoleARN(tc.arn); err != tc.err {
+			t.Errorf("validateRoleARN returned unexpected error for test case #%02d: %+v", i, err)
+		}
+	}
+}
+
+func TestRoleForZone(t *testing.T) {
+	options := registratorOptions{
+		AWS:       awsOptions{RoleARN: "arn:aws:iam::1:role/dns", ExternalID: "one"},
+		ZoneRoles: map[string]awsRole{"B": {ARN: "arn:aws:iam::2:role/dns"}},
+	}
+	testCases := []struct {
+		zone     string
+		options  registratorOptions
+		expected awsRole
+	}{
+		{"A", registratorOptions{}, awsRole{SessionName: defaultAWSSessionName}},
+		{"A", options, awsRole{ARN: "arn:aws:iam::1:role/dns", ExternalID: "one", SessionName: defaultAWSSessionName}},
+		{"B", options, awsRole{ARN: "arn:aws:iam::2:role/dns", SessionName: defaultAWSSessionName}},
+		{"B", registratorOptions{AWS: awsOptions{SessionName: "eu-west-1"}, ZoneRoles: options.ZoneRoles}, awsRole{ARN: "arn:aws:iam::2:role/dns", SessionName: "eu-west-1"}},
+	}
+	for i, tc := range testCases {
+		if r := roleForZone(tc.zone, tc.options); r != tc.expected {
+			t.Errorf("roleForZone returned unexpected role for test case #%02d: %+v", i, r)
+		}
+	}
+}
+
+func TestRoute53Provider_apiForZone(t *testing.T) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String("eu-west-1")})
+	if err != nil {
+		t.Fatalf("could not create aws session: %+v", err)
+	}
+	p := &route53Provider{api: &mockRoute53API{}, sess: sess, roleAPIs: map[awsRole]route53iface.Route53API{}}
+	options := registratorOptions{ZoneRoles: map[string]awsRole{"B": {ARN: "arn:aws:iam::2:role/dns"}, "C": {ARN: "arn:aws:iam::2:role/dns"}}}
+	if api := p.apiForZone("A", options); api != p.api {
+		t.Errorf("route53Provider.apiForZone assumed a role for a zone without one")
+	}
+	b := p.apiForZone("B", options)
+	if b == p.api {
+		t.Errorf("route53Provider.apiForZone did not assume the role of the zone")
+	}
+	if c := p.apiForZone("C", options); c != b || len(p.roleAPIs) != 1 {
+		t.Errorf("route53Provider.apiForZone did not reuse the client of the role")
+	}
+}
+
+type mockWebIdentityAssumer struct {
+	inputs []*sts.AssumeRoleWithWebIdentityInput
+	expiry time.Time
+}
+
+func (m *mockWebIdentityAssumer) AssumeRoleWithWebIdentity(in *sts.AssumeRoleWithWebIdentityInput) (*sts.AssumeRoleWithWebIdentityOutput, error) {
+	m.inputs = append(m.inputs, in)
+	return &sts.AssumeRoleWithWebIdentityOutput{Credentials: &sts.Credentials{
+		AccessKeyId:     aws.String("AKID"),
+		SecretAccessKey: aws.String("SECRET"),
+		SessionToken:    aws.String(aws.StringValue(in.WebIdentityToken)),
+		Expiration:      aws.Time(m.expiry),
+	}}, nil
+}
+
+func TestWebIdentityProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("first\n"), 0600); err != nil {
+		t.Fatalf("could not write token: %+v", err)
+	}
+
+	m := &mockWebIdentityAssumer{expiry: time.Now().Add(time.Hour)}
+	p := &webIdentityProvider{client: m, roleARN: "arn:aws:iam::1:role/dns", sessionName: defaultAWSSessionName, tokenFile: tokenFile}
+	c := credentials.NewCredentials(p)
+	v, err := c.Get()
+	if err != nil {
+		t.Fatalf("webIdentityProvider.Retrieve returned unexpected error: %+v", err)
+	}
+	if v.AccessKeyID != "AKID" || v.SessionToken != "first" || aws.StringValue(m.inputs[0].RoleArn) != p.roleARN || aws.StringValue(m.inputs[0].RoleSessionName) != defaultAWSSessionName {
+		t.Errorf("webIdentityProvider.Retrieve returned unexpected credentials: %+v, %+v", v, m.inputs[0])
+	}
+	if c.IsExpired() {
+		t.Errorf("webIdentityProvider credentials expired straight away")
+	}
+
+	// the token is read again when the credentials are refreshed
+	if err := ioutil.WriteFile(tokenFile, []byte("second"), 0600); err != nil {
+		t.Fatalf("could not write token: %+v", err)
+	}
+	p.SetExpiration(time.Now(), 0)
+	if v, err := c.Get(); err != nil || v.SessionToken != "second" || len(m.inputs) != 2 {
+		t.Errorf("webIdentityProvider did not refresh the credentials: %+v, %+v", v, err)
+	}
+}
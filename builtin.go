@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/miekg/dns"
+)
+
+const providerBuiltin = "builtin"
+
+var (
+	defaultBuiltinListen  = ":53"
+	defaultBuiltinDataDir = "/var/lib/ingress53"
+	builtinTransferChunk  = 100
+	builtinNotifyTimeout  = 5 * time.Second
+	builtinSOATimers      = "3600 600 604800 60"
+	builtinZoneFileSuffix = "zone"
+	builtinZoneFileMode   = os.FileMode(0644)
+)
+
+func init() {
+	registerProvider(providerBuiltin, newBuiltinProvider)
+}
+
+// builtinOptions are the settings of the builtin provider.
+type builtinOptions struct {
+	Listen      string   `json:"listen"`      // address to serve the zones on, defaults to :53
+	DataDir     string   `json:"dataDir"`     // directory the zone files are written to, defaults to /var/lib/ingress53
+	Nameservers []string `json:"nameservers"` // names of the NS records, defaults to ns.<zone>
+	Secondaries []string `json:"secondaries"` // host[:port] of the secondaries to notify and allow zone transfers to
+}
+
+// builtinProvider serves the zones itself, from the records ingress53
+// manages. The zones are written to disk after every change and loaded back
+// on start, and secondaries are notified of changes and can transfer them.
+type builtinProvider struct {
+	mu          sync.RWMutex
+	zones       map[string]*builtinZone
+	dataDir     string
+	nameservers []string
+	secondaries []string
+	addr        string
+}
+
+func newBuiltinProvider(options registratorOptions) (dnsProvider, error) {
+	o := options.Builtin
+	p := &builtinProvider{
+		zones:       map[string]*builtinZone{},
+		dataDir:     o.DataDir,
+		nameservers: o.Nameservers,
+	}
+	if p.dataDir == "" {
+		p.dataDir = defaultBuiltinDataDir
+	}
+	if err := os.MkdirAll(p.dataDir, 0755); err != nil {
+		return nil, err
+	}
+	for _, s := range o.Secondaries {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "53")
+		}
+		p.secondaries = append(p.secondaries, s)
+	}
+	listen := o.Listen
+	if listen == "" {
+		listen = defaultBuiltinListen
+	}
+	if err := p.listen(listen); err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] serving dns zones on %s", p.addr)
+	return p, nil
+}
+
+// listen starts serving over udp and tcp on the same port.
+func (p *builtinProvider) listen(addr string) error {
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+	host, port, _ := net.SplitHostPort(pc.LocalAddr().String())
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+	p.addr = net.JoinHostPort(host, port)
+	for _, s := range []*dns.Server{{PacketConn: pc, Handler: p}, {Listener: l, Handler: p}} {
+		go func(s *dns.Server) {
+			if err := s.ActivateAndServe(); err != nil {
+				log.Printf("[ERROR] dns server stopped: %+v", err)
+			}
+		}(s)
+	}
+	return nil
+}
+
+// NewZone returns the zone with the name, loading it from disk if it was
+// written before. Zones are kept across reloads, so the same zone is
+// returned for the same name.
+func (p *builtinProvider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	name := strings.ToLower(dns.Fqdn(id))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if z, ok := p.zones[name]; ok {
+		z.setTTL(options.RecordTTL)
+		return z, nil
+	}
+	z := &builtinZone{
+		provider: p,
+		Name:     name,
+		TTL:      options.RecordTTL,
+		Serial:   uint32(time.Now().Unix()),
+		records:  map[string][]dns.RR{},
+		path:     filepath.Join(p.dataDir, strings.TrimSuffix(name, ".")+"."+builtinZoneFileSuffix),
+	}
+	if err := z.load(); err != nil {
+		return nil, err
+	}
+	p.zones[name] = z
+	return z, nil
+}
+
+func (p *builtinProvider) Capabilities() providerCapabilities {
+	return providerCapabilities{TXT: true}
+}
+
+// zoneFor returns the zone the name belongs to, if any.
+func (p *builtinProvider) zoneFor(name string) *builtinZone {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var ret *builtinZone
+	for zn, z := range p.zones {
+		if dns.IsSubDomain(zn, strings.ToLower(name)) && (ret == nil || len(zn) > len(ret.Name)) {
+			ret = z
+		}
+	}
+	return ret
+}
+
+// ServeDNS answers queries for the records of the zones, and zone transfers
+// over tcp from the secondaries.
+func (p *builtinProvider) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	if req.Opcode != dns.OpcodeQuery || len(req.Question) != 1 {
+		resp.SetRcode(req, dns.RcodeNotImplemented)
+		w.WriteMsg(resp)
+		return
+	}
+	q := req.Question[0]
+	z := p.zoneFor(q.Name)
+	if z == nil {
+		resp.SetRcode(req, dns.RcodeRefused)
+		w.WriteMsg(resp)
+		return
+	}
+	if q.Qtype == dns.TypeAXFR {
+		if _, ok := w.RemoteAddr().(*net.TCPAddr); !ok {
+			log.Printf("[DEBUG] refusing transfer of zone %s over udp to %s", z.Name, w.RemoteAddr())
+			resp.SetRcode(req, dns.RcodeRefused)
+			w.WriteMsg(resp)
+			return
+		}
+		if !p.transferAllowed(w.RemoteAddr()) {
+			log.Printf("[INFO] refusing transfer of zone %s to %s", z.Name, w.RemoteAddr())
+			resp.SetRcode(req, dns.RcodeRefused)
+			w.WriteMsg(resp)
+			return
+		}
+		z.transfer(w, req)
+		return
+	}
+	resp.Authoritative = true
+	z.answer(resp, q)
+	w.WriteMsg(resp)
+}
+
+// transferAllowed returns true if the address is one of the secondaries.
+func (p *builtinProvider) transferAllowed(addr net.Addr) bool {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return false
+	}
+	for _, s := range p.secondaries {
+		sh, _, _ := net.SplitHostPort(s)
+		if sh == host {
+			return true
+		}
+		ips, err := net.LookupIP(sh)
+		if err != nil {
+			continue
+		}
+		for _, ip := range ips {
+			if ip.Equal(net.ParseIP(host)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// builtinZone is a zone served by the builtin provider. It holds the records
+// ingress53 manages, along with the SOA and NS records that are generated
+// from the provider options.
+type builtinZone struct {
+	provider *builtinProvider
+	mu       sync.RWMutex
+	Name     string
+	TTL      int64
+	Serial   uint32
+	records  map[string][]dns.RR // by lowercase name
+	path     string
+}
+
+func (z *builtinZone) setTTL(ttl int64) {
+	z.mu.Lock()
+	z.TTL = ttl
+	z.mu.Unlock()
+}
+
+func (z *builtinZone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(route53.ChangeActionUpsert, records)
+}
+
+func (z *builtinZone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(route53.ChangeActionDelete, records)
+}
+
+// changeRecords applies the changes, writes the zone to disk and notifies the
+// secondaries.
+func (z *builtinZone) changeRecords(action string, records []dnsRecord) error {
+	z.mu.Lock()
+	sets := make([][]dns.RR, len(records))
+	for i, r := range records {
+		rrs, err := resourceRecords(r, z.TTL)
+		if err != nil {
+			z.mu.Unlock()
+			return err
+		}
+		sets[i] = rrs
+	}
+	for i, r := range records {
+		rrs := sets[i]
+		name := strings.ToLower(dns.Fqdn(r.Hostname))
+		kept := []dns.RR{}
+		for _, rr := range z.records[name] {
+			if rr.Header().Rrtype != rrs[0].Header().Rrtype {
+				kept = append(kept, rr)
+			}
+		}
+		if action != route53.ChangeActionDelete {
+			kept = append(kept, rrs...)
+		}
+		if len(kept) == 0 {
+			delete(z.records, name)
+		} else {
+			z.records[name] = kept
+		}
+	}
+	z.Serial++
+	err := z.save()
+	z.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	go z.notify()
+	return nil
+}
+
+// soa returns the SOA record of the zone. The caller must hold the lock.
+func (z *builtinZone) soa() dns.RR {
+	ns := z.nameservers()
+	rr, _ := dns.NewRR(fmt.Sprintf("%s %d IN SOA %s hostmaster.%s %d %s", z.Name, z.TTL, ns[0], z.Name, z.Serial, builtinSOATimers))
+	return rr
+}
+
+// ns returns the NS records of the zone. The caller must hold the lock.
+func (z *builtinZone) ns() []dns.RR {
+	ret := []dns.RR{}
+	for _, n := range z.nameservers() {
+		ret = append(ret, &dns.NS{Hdr: dns.RR_Header{Name: z.Name, Rrtype: dns.TypeNS, Class: dns.ClassINET, Ttl: uint32(z.TTL)}, Ns: dns.Fqdn(n)})
+	}
+	return ret
+}
+
+func (z *builtinZone) nameservers() []string {
+	if len(z.provider.nameservers) == 0 {
+		return []string{"ns." + z.Name}
+	}
+	ret := make([]string, len(z.provider.nameservers))
+	for i, n := range z.provider.nameservers {
+		ret[i] = dns.Fqdn(n)
+	}
+	return ret
+}
+
+// allRecords returns all the records of the zone, starting with the SOA
+// record, sorted by name and type. The caller must hold the lock.
+func (z *builtinZone) allRecords() []dns.RR {
+	names := make([]string, 0, len(z.records))
+	for n := range z.records {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	ret := append([]dns.RR{z.soa()}, z.ns()...)
+	for _, n := range names {
+		rrs := append([]dns.RR{}, z.records[n]...)
+		sort.SliceStable(rrs, func(i, j int) bool { return rrs[i].Header().Rrtype < rrs[j].Header().Rrtype })
+		ret = append(ret, rrs...)
+	}
+	return ret
+}
+
+// answer sets the answer to the question: the records of the type, or the
+// CNAME record of the name if there is one, and the SOA record if there are
+// none. Names without records of their own are answered from the wildcard
+// of their closest existing ancestor, if there is one, and names that only
+// have records below them exist, with no records.
+func (z *builtinZone) answer(resp *dns.Msg, q dns.Question) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	name := strings.ToLower(q.Name)
+	rrs := z.records[name]
+	if name == z.Name {
+		rrs = append([]dns.RR{z.soa()}, append(z.ns(), rrs...)...)
+	}
+	if len(rrs) == 0 && !z.exists(name) {
+		rrs = z.wildcard(q.Name)
+	}
+	for _, rr := range rrs {
+		t := rr.Header().Rrtype
+		if t == q.Qtype || q.Qtype == dns.TypeANY || (t == dns.TypeCNAME && q.Qtype != dns.TypeCNAME) {
+			resp.Answer = append(resp.Answer, rr)
+		}
+	}
+	if len(resp.Answer) > 0 {
+		return
+	}
+	if len(rrs) == 0 && !z.exists(name) {
+		resp.Rcode = dns.RcodeNameError
+	}
+	resp.Ns = []dns.RR{z.soa()}
+}
+
+// exists returns true if the name has records, or records below it. The
+// caller must hold the lock.
+func (z *builtinZone) exists(name string) bool {
+	if name == z.Name || len(z.records[name]) > 0 {
+		return true
+	}
+	for n := range z.records {
+		if strings.HasSuffix(n, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcard returns the records of the wildcard of the closest existing
+// ancestor of the name, renamed after it, or nil. The caller must hold the
+// lock.
+func (z *builtinZone) wildcard(name string) []dns.RR {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i := 1; i < len(labels); i++ {
+		encloser := dns.Fqdn(strings.Join(labels[i:], "."))
+		if !dns.IsSubDomain(z.Name, encloser) {
+			return nil
+		}
+		if rrs := z.records["*."+encloser]; len(rrs) > 0 {
+			ret := make([]dns.RR, len(rrs))
+			for j, rr := range rrs {
+				ret[j] = dns.Copy(rr)
+				ret[j].Header().Name = name
+			}
+			return ret
+		}
+		if z.exists(encloser) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// transfer sends all the records of the zone, in chunks. The connection is
+// left to the secondary to close, once all the chunks are written.
+func (z *builtinZone) transfer(w dns.ResponseWriter, req *dns.Msg) {
+	z.mu.RLock()
+	rrs := append(z.allRecords(), z.soa())
+	z.mu.RUnlock()
+	ch := make(chan *dns.Envelope)
+	done := make(chan error, 1)
+	go func() {
+		done <- (&dns.Transfer{}).Out(w, req, ch)
+	}()
+	for i := 0; i < len(rrs); i += builtinTransferChunk {
+		end := i + builtinTransferChunk
+		if end > len(rrs) {
+			end = len(rrs)
+		}
+		select {
+		case ch <- &dns.Envelope{RR: rrs[i:end]}:
+		case err := <-done:
+			log.Printf("[ERROR] transfer of zone %s to %s failed: %+v", z.Name, w.RemoteAddr(), err)
+			w.Close()
+			return
+		}
+	}
+	close(ch)
+	if err := <-done; err != nil {
+		log.Printf("[ERROR] transfer of zone %s to %s failed: %+v", z.Name, w.RemoteAddr(), err)
+		w.Close()
+		return
+	}
+	w.Hijack()
+}
+
+// notify tells the secondaries that the zone has changed.
+func (z *builtinZone) notify() {
+	c := &dns.Client{Timeout: builtinNotifyTimeout}
+	for _, s := range z.provider.secondaries {
+		m := &dns.Msg{}
+		m.SetNotify(z.Name)
+		if _, _, err := c.Exchange(m, s); err != nil {
+			log.Printf("[ERROR] could not notify %s of changes to zone %s: %+v", s, z.Name, err)
+		}
+	}
+}
+
+// save writes the zone file, replacing the previous one. The caller must
+// hold the lock.
+func (z *builtinZone) save() error {
+	lines := []string{"$ORIGIN " + z.Name}
+	for _, rr := range z.allRecords() {
+		lines = append(lines, rr.String())
+	}
+	tmp := z.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), builtinZoneFileMode); err != nil {
+		return err
+	}
+	return os.Rename(tmp, z.path)
+}
+
+// load reads the records of the zone file, if there is one. The SOA and NS
+// records of the zone are generated, so only their serial is kept.
+func (z *builtinZone) load() error {
+	f, err := os.Open(z.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	count := 0
+	for t := range dns.ParseZone(f, z.Name, z.path) {
+		if t.Error != nil {
+			return t.Error
+		}
+		h := t.RR.Header()
+		name := strings.ToLower(h.Name)
+		if soa, ok := t.RR.(*dns.SOA); ok && name == z.Name {
+			z.Serial = soa.Serial
+			continue
+		}
+		if h.Rrtype == dns.TypeNS && name == z.Name {
+			continue
+		}
+		z.records[name] = append(z.records[name], t.RR)
+		count++
+	}
+	log.Printf("[INFO] loaded %d record(s) of zone %s from %s", count, z.Name, z.path)
+	return nil
+}
+
+func (z *builtinZone) LookupRecords(name string) ([]zoneRecord, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	return zoneRecordsFromRRs(z.records[strings.ToLower(dns.Fqdn(name))]), nil
+}
+
+func (z *builtinZone) Domain() string {
+	return z.Name
+}
+
+// ListNameservers returns the address the zone is served on.
+func (z *builtinZone) ListNameservers() []string {
+	return []string{z.provider.addr}
+}
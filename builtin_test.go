@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// startMockSecondary starts a nameserver that sends the zone names of the
+// notifications it receives to the channel.
+func startMockSecondary(notified chan<- string) (*dns.Server, string, error) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	mux := dns.NewServeMux()
+	mux.HandleFunc(".", func(w dns.ResponseWriter, req *dns.Msg) {
+		msg := new(dns.Msg)
+		msg.SetReply(req)
+		w.WriteMsg(msg)
+		if req.Opcode == dns.OpcodeNotify {
+			notified <- req.Question[0].Name
+		}
+	})
+	server := &dns.Server{PacketConn: pc, Handler: mux}
+
+	waitLock := sync.Mutex{}
+	waitLock.Lock()
+	server.NotifyStartedFunc = waitLock.Unlock
+
+	go func() {
+		server.ActivateAndServe()
+		pc.Close()
+	}()
+
+	waitLock.Lock()
+	return server, pc.LocalAddr().String(), nil
+}
+
+func TestBuiltinProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	notified := make(chan string, 4)
+	secondary, secondaryAddr, err := startMockSecondary(notified)
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+	defer secondary.Shutdown()
+
+	options := registratorOptions{RecordTTL: 60, Builtin: builtinOptions{Listen: "127.0.0.1:0", DataDir: dir, Secondaries: []string{secondaryAddr}}}
+	p, err := newBuiltinProvider(options)
+	if err != nil {
+		t.Fatalf("newBuiltinProvider returned unexpected error: %+v", err)
+	}
+	z, err := p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("builtinProvider.NewZone returned unexpected error: %+v", err)
+	}
+	if again, _ := p.NewZone(context.Background(), "example.com.", options); again != z {
+		t.Errorf("builtinProvider.NewZone did not return the existing zone")
+	}
+
+	ips := dnsRecord{Hostname: "b.example.com", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}}
+	records := []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget), ips, ownerRecord(ips, "one")}
+	if err := z.UpsertRecords(context.Background(), records); err != nil {
+		t.Fatalf("builtinZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	select {
+	case name := <-notified:
+		if name != "example.com." {
+			t.Errorf("secondary was notified of unexpected zone: %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("secondary was not notified of the changes")
+	}
+
+	ns := z.ListNameservers()[0]
+	testCases := []struct {
+		name   string
+		rrType string
+		values []string
+		err    error
+	}{
+		{"a.example.com.", "CNAME", []string{testPrivateTarget}, nil},
+		{"b.example.com.", "A", []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{"b.example.com.", "AAAA", nil, errDNSEmptyAnswer},
+		{"_ingress53.b.example.com.", "TXT", ownerRecord(ips, "one").Values, nil},
+		{"example.com.", "NS", []string{"ns.example.com"}, nil},
+		{"c.example.com.", "A", nil, errDNSEmptyAnswer},
+	}
+	for i, tc := range testCases {
+		values, err := queryNameserver(tc.name, tc.rrType, ns)
+		if !reflect.DeepEqual(values, tc.values) || err != tc.err {
+			t.Errorf("builtin provider returned unexpected answer for test case #%02d: %+v, %+v", i, values, err)
+		}
+	}
+
+	m := &dns.Msg{}
+	m.SetQuestion("example.org.", dns.TypeA)
+	if resp, err := dns.Exchange(m, ns); err != nil || resp.Rcode != dns.RcodeRefused {
+		t.Errorf("builtin provider did not refuse a query for another zone: %+v, %+v", resp, err)
+	}
+
+	// secondaries can transfer the zone
+	m = &dns.Msg{}
+	m.SetAxfr("example.com.")
+	envelopes, err := (&dns.Transfer{}).In(m, ns)
+	if err != nil {
+		t.Fatalf("zone transfer returned unexpected error: %+v", err)
+	}
+	rrs := []dns.RR{}
+	for e := range envelopes {
+		if e.Error != nil {
+			t.Fatalf("zone transfer returned unexpected error: %+v", e.Error)
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if len(rrs) != 7 {
+		t.Errorf("zone transfer returned unexpected records: %+v", rrs)
+	}
+
+	// but only over tcp
+	m = &dns.Msg{}
+	m.SetAxfr("example.com.")
+	if resp, err := dns.Exchange(m, ns); err != nil || resp.Rcode != dns.RcodeRefused {
+		t.Errorf("builtin provider did not refuse a zone transfer over udp: %+v, %+v", resp, err)
+	}
+
+	// wildcards answer for the names below them that do not exist, and names
+	// that only have records below them exist without records
+	wildcard := newCnameRecord("*.w.example.com", testPublicTarget)
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{wildcard, ownerRecord(wildcard, "one")}); err != nil {
+		t.Fatalf("builtinZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	rcodes := []struct {
+		name    string
+		rcode   int
+		answers []string
+	}{
+		{"x.w.example.com.", dns.RcodeSuccess, []string{"x.w.example.com.\t60\tIN\tCNAME\t" + testPublicTarget + "."}},
+		{"y.x.w.example.com.", dns.RcodeSuccess, []string{"y.x.w.example.com.\t60\tIN\tCNAME\t" + testPublicTarget + "."}},
+		{"w.example.com.", dns.RcodeSuccess, nil},
+		{"x.a.example.com.", dns.RcodeNameError, nil},
+		{"z.example.com.", dns.RcodeNameError, nil},
+	}
+	for i, tc := range rcodes {
+		m = &dns.Msg{}
+		m.SetQuestion(tc.name, dns.TypeA)
+		resp, err := dns.Exchange(m, ns)
+		if err != nil {
+			t.Errorf("builtin provider returned unexpected error for test case #%02d: %+v", i, err)
+			continue
+		}
+		answers := []string(nil)
+		for _, rr := range resp.Answer {
+			answers = append(answers, rr.String())
+		}
+		if resp.Rcode != tc.rcode || !reflect.DeepEqual(answers, tc.answers) {
+			t.Errorf("builtin provider returned unexpected answer for test case #%02d: %s, %+v", i, dns.RcodeToString[resp.Rcode], answers)
+		}
+	}
+	if err := z.DeleteRecords(context.Background(), []dnsRecord{wildcard, ownerRecord(wildcard, "one")}); err != nil {
+		t.Fatalf("builtinZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+
+	if err := z.DeleteRecords(context.Background(), records[:1]); err != nil {
+		t.Fatalf("builtinZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	serial := z.(*builtinZone).Serial
+
+	// the zone is loaded back from disk
+	options.Builtin.Secondaries = nil
+	p, err = newBuiltinProvider(options)
+	if err != nil {
+		t.Fatalf("newBuiltinProvider returned unexpected error: %+v", err)
+	}
+	z, err = p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("builtinProvider.NewZone returned unexpected error: %+v", err)
+	}
+	if s := z.(*builtinZone).Serial; s != serial {
+		t.Errorf("builtinProvider.NewZone did not load the serial of the zone: %d", s)
+	}
+	if records, _ := z.(recordLister).LookupRecords("a.example.com"); len(records) != 0 {
+		t.Errorf("builtinZone.LookupRecords returned a deleted record: %+v", records)
+	}
+	expected := []zoneRecord{{Name: "b.example.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}}}
+	if records, _ := z.(recordLister).LookupRecords("b.example.com"); !reflect.DeepEqual(records, expected) {
+		t.Errorf("builtinZone.LookupRecords returned unexpected records: %+v", records)
+	}
+}
+
+func TestBuiltinProvider_dataDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the data directory is created if it does not exist
+	options := registratorOptions{Builtin: builtinOptions{Listen: "127.0.0.1:0", DataDir: filepath.Join(dir, "data", "zones")}}
+	if _, err := newBuiltinProvider(options); err != nil {
+		t.Fatalf("newBuiltinProvider returned unexpected error: %+v", err)
+	}
+	if fi, err := os.Stat(options.Builtin.DataDir); err != nil || !fi.IsDir() {
+		t.Errorf("newBuiltinProvider did not create the data directory: %+v", err)
+	}
+
+	// and fails if it cannot be created
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatalf("could not create temporary file: %+v", err)
+	}
+	options.Builtin.DataDir = filepath.Join(file, "zones")
+	if _, err := newBuiltinProvider(options); err == nil {
+		t.Errorf("newBuiltinProvider did not return an error for a data directory that cannot be created")
+	}
+}
+
+func TestBuiltinProvider_transferAllowed(t *testing.T) {
+	p := &builtinProvider{secondaries: []string{"10.0.0.1:53", "localhost:53"}}
+	testCases := []struct {
+		addr     net.Addr
+		expected bool
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}, true},
+		{&net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}, true},
+		{&net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1234}, false},
+	}
+	for i, tc := range testCases {
+		if a := p.transferAllowed(tc.addr); a != tc.expected {
+			t.Errorf("builtinProvider.transferAllowed returned unexpected result for test case #%02d: %v", i, a)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+type trackedChange struct {
+	ID          string
+	Action      string
+	Records     []dnsRecord
+	SubmittedAt time.Time
+	timedOut    bool
+	nextCheck   time.Time
+}
+
+// changeTracker polls route53 for the status of the submitted changes, so
+// that new changes can be submitted while previous ones are propagating.
+// Changes that are not in sync after defaultRoute53ZoneWaitWatchTimeout are
+// reported and then checked less often, until they are.
+type changeTracker struct {
+	api     route53iface.Route53API
+	mu      sync.Mutex
+	pending map[string]*trackedChange
+	synced  chan struct{}
+	onSync  func(action string, records []dnsRecord)
+	stopped bool // no longer polling, the pending changes are not counted
+}
+
+func newChangeTracker(api route53iface.Route53API) *changeTracker {
+	return &changeTracker{
+		api:     api,
+		pending: map[string]*trackedChange{},
+		synced:  make(chan struct{}),
+	}
+}
+
+// Track adds the change to the pending ones. The pending changes metric is
+// shared by the trackers of all the zones, so it's only ever incremented and
+// decremented.
+func (t *changeTracker) Track(c trackedChange) {
+	c.nextCheck = time.Now().Add(defaultRoute53ZoneWaitWatchInterval)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pending[c.ID]; !ok && !t.stopped {
+		metricChangesPending.Inc()
+	}
+	t.pending[c.ID] = &c
+}
+
+// Run polls the pending changes until the context is done.
+func (t *changeTracker) Run(ctx context.Context) {
+	tick := time.NewTicker(defaultRoute53ZoneWaitWatchInterval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			t.poll(ctx, time.Now())
+		case <-ctx.Done():
+			t.stop()
+			return
+		}
+	}
+}
+
+// stop removes the changes that are still pending from the pending changes
+// metric, as they will not be polled anymore.
+func (t *changeTracker) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	t.stopped = true
+	if n := len(t.pending); n > 0 {
+		log.Printf("[INFO] stopped tracking %d route53 change(s) that are not in sync yet", n)
+		metricChangesPending.Sub(float64(n))
+	}
+}
+
+func (t *changeTracker) poll(ctx context.Context, now time.Time) {
+	for _, id := range t.due(now) {
+		change, err := t.api.GetChangeWithContext(ctx, &route53.GetChangeInput{Id: aws.String(id)})
+		if err != nil {
+			log.Printf("[ERROR] could not get the status of route53 change %s, will retry: %+v", id, err)
+			continue
+		}
+		if c := t.update(id, *change.ChangeInfo.Status, now); c != nil && t.onSync != nil {
+			t.onSync(c.Action, c.Records)
+		}
+	}
+}
+
+// due returns the ids of the changes that need to be checked.
+func (t *changeTracker) due(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ids := []string{}
+	for id, c := range t.pending {
+		if !now.Before(c.nextCheck) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// update sets the status of the change and returns it if it is now in sync.
+func (t *changeTracker) update(id string, status string, now time.Time) *trackedChange {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, ok := t.pending[id]
+	if !ok {
+		return nil
+	}
+	if status == route53.ChangeStatusInsync {
+		delete(t.pending, id)
+		if !t.stopped {
+			metricChangesPending.Dec()
+		}
+		metricChangePropagationSeconds.Observe(now.Sub(c.SubmittedAt).Seconds())
+		log.Printf("[DEBUG] route53 change %s is in sync after %s", id, now.Sub(c.SubmittedAt))
+		close(t.synced)
+		t.synced = make(chan struct{})
+		return c
+	}
+	if !c.timedOut && now.Sub(c.SubmittedAt) > defaultRoute53ZoneWaitWatchTimeout {
+		c.timedOut = true
+		metricChangesTimedOut.Inc()
+		log.Printf("[ERROR] route53 change %s is not in sync after %s, will keep checking every %s", id, defaultRoute53ZoneWaitWatchTimeout, defaultRoute53ZoneTimedOutCheckInterval)
+	}
+	if c.timedOut {
+		c.nextCheck = now.Add(defaultRoute53ZoneTimedOutCheckInterval)
+	} else {
+		c.nextCheck = now.Add(defaultRoute53ZoneWaitWatchInterval)
+	}
+	return nil
+}
+
+// Pending returns the number of changes that are not in sync yet.
+func (t *changeTracker) Pending() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// Wait blocks until there are no pending changes or the context is done.
+func (t *changeTracker) Wait(ctx context.Context) error {
+	for {
+		t.mu.Lock()
+		n := len(t.pending)
+		synced := t.synced
+		t.mu.Unlock()
+		if n == 0 {
+			return nil
+		}
+		select {
+		case <-synced:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestChangeTracker(t *testing.T) {
+	api := &mockRoute53API{getChangeResp: testRoute53ZoneGetChangePending}
+	ct := newChangeTracker(api)
+	synced := [][]dnsRecord{}
+	ct.onSync = func(action string, records []dnsRecord) {
+		synced = append(synced, records)
+	}
+	records := []dnsRecord{newCnameRecord("test.example.com", "cname.example.com")}
+	submittedAt := time.Now()
+	ct.Track(trackedChange{ID: "123456789", Records: records, SubmittedAt: submittedAt})
+
+	pending := func() *trackedChange {
+		ct.mu.Lock()
+		defer ct.mu.Unlock()
+		return ct.pending["123456789"]
+	}
+
+	// not due yet
+	ct.poll(context.Background(), submittedAt)
+	if c := pending(); c == nil || c.timedOut {
+		t.Fatalf("changeTracker did not keep the pending change: %+v", c)
+	}
+
+	// error getting the change, it should be retried
+	api.getChangeErr = errTestRoute53ZoneMock
+	ct.poll(context.Background(), submittedAt.Add(defaultRoute53ZoneWaitWatchInterval))
+	if c := pending(); c == nil || c.timedOut {
+		t.Fatalf("changeTracker did not keep the pending change: %+v", c)
+	}
+	api.getChangeErr = nil
+
+	// timed out, it should be kept and checked less often
+	now := submittedAt.Add(defaultRoute53ZoneWaitWatchTimeout + time.Second)
+	ct.poll(context.Background(), now)
+	c := pending()
+	if c == nil || !c.timedOut {
+		t.Fatalf("changeTracker did not mark the change as timed out: %+v", c)
+	}
+	if !c.nextCheck.Equal(now.Add(defaultRoute53ZoneTimedOutCheckInterval)) {
+		t.Errorf("changeTracker scheduled the next check at an unexpected time: %s", c.nextCheck)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := ct.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("changeTracker.Wait returned unexpected error: %+v", err)
+	}
+
+	// in sync
+	api.getChangeResp = testRoute53ZoneGetChangeOK
+	ct.poll(context.Background(), now.Add(defaultRoute53ZoneTimedOutCheckInterval))
+	if ct.Pending() != 0 {
+		t.Fatalf("changeTracker did not remove the change that is in sync")
+	}
+	if !reflect.DeepEqual(synced, [][]dnsRecord{records}) {
+		t.Errorf("changeTracker did not call onSync with the records of the change: %+v", synced)
+	}
+	if err := ct.Wait(context.Background()); err != nil {
+		t.Errorf("changeTracker.Wait returned unexpected error: %+v", err)
+	}
+}
+
+func TestChangeTracker_pendingMetric(t *testing.T) {
+	pending := func() float64 {
+		m := &dto.Metric{}
+		metricChangesPending.Write(m)
+		return m.GetGauge().GetValue()
+	}
+	initial := pending()
+
+	// the trackers of all the zones count towards the same metric
+	api := &mockRoute53API{getChangeResp: testRoute53ZoneGetChangeOK}
+	a, b := newChangeTracker(api), newChangeTracker(api)
+	now := time.Now()
+	a.Track(trackedChange{ID: "a1", SubmittedAt: now})
+	a.Track(trackedChange{ID: "a1", SubmittedAt: now})
+	b.Track(trackedChange{ID: "b1", SubmittedAt: now})
+	b.Track(trackedChange{ID: "b2", SubmittedAt: now})
+	if p := pending() - initial; p != 3 {
+		t.Errorf("changeTracker counted unexpected pending changes: %v", p)
+	}
+	a.poll(context.Background(), now.Add(defaultRoute53ZoneWaitWatchInterval))
+	if p := pending() - initial; p != 2 {
+		t.Errorf("changeTracker counted unexpected pending changes after a change was in sync: %v", p)
+	}
+
+	// the changes of a stopped tracker are no longer counted
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b.Run(ctx)
+	if p := pending() - initial; p != 0 {
+		t.Errorf("changeTracker counted unexpected pending changes after stopping: %v", p)
+	}
+	b.Track(trackedChange{ID: "b3", SubmittedAt: now})
+	if p := pending() - initial; p != 0 || b.Pending() != 3 {
+		t.Errorf("stopped changeTracker counted unexpected pending changes: %v, %d", p, b.Pending())
+	}
+}
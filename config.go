@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+var defaultConfigWatchInterval = 10 * time.Second
+
+// config is the structure of the configuration file, which can be either YAML
+// or JSON.
+type config struct {
+	Targets          []string       `json:"targets"`
+	TargetsConfigMap string         `json:"targetsConfigMap"`
+	TargetLabelName  string         `json:"targetLabel"`
+	Provider         string         `json:"provider"`
+	Zones            []zoneConfig   `json:"zones"`
+	RecordTTL        int64          `json:"ttl"`
+	Policy           string         `json:"policy"`
+	PruneSource      string         `json:"pruneSource"`
+	RecordsRefresh   duration       `json:"recordsRefresh"`
+	Resolvers        []string       `json:"resolvers"`
+	PrivateTargets   []string       `json:"privateTargets"`
+	PrivateResolvers []string       `json:"privateResolvers"`
+	ClusterID        string         `json:"clusterID"`
+	AdoptUnowned     bool           `json:"adoptUnownedRecords"`
+	AWS              awsOptions     `json:"aws"`
+	Filters          filterConfig   `json:"filters"`
+	RFC2136          rfc2136Options `json:"rfc2136"`
+	Builtin          builtinOptions `json:"builtin"`
+	Etcd             etcdOptions    `json:"etcd"`
+	Export           exportOptions  `json:"export"`
+	Webhook          webhookOptions `json:"webhook"`
+}
+
+type zoneConfig struct {
+	ID         string `json:"id"`
+	Role       string `json:"role"`       // aws role assumed to manage the zone, for route53
+	ExternalID string `json:"externalID"` // passed when assuming the role
+}
+
+// duration is a time.Duration written as a string in the configuration file,
+// eg. "5m".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+type filterConfig struct {
+	Namespaces []string `json:"namespaces"`
+	Hostnames  []string `json:"hostnames"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// applyTo sets the registrator options defined in the configuration.
+func (c *config) applyTo(o *registratorOptions) {
+	o.Targets = c.Targets
+	o.TargetsConfigMap = c.TargetsConfigMap
+	o.TargetLabelName = c.TargetLabelName
+	o.Provider = c.Provider
+	o.RFC2136 = c.RFC2136
+	o.Builtin = c.Builtin
+	o.Etcd = c.Etcd
+	o.Export = c.Export
+	o.Webhook = c.Webhook
+	o.ZoneIDs = make([]string, len(c.Zones))
+	var roles map[string]awsRole
+	for i, z := range c.Zones {
+		o.ZoneIDs[i] = z.ID
+		if z.Role == "" {
+			continue
+		}
+		if roles == nil {
+			roles = map[string]awsRole{}
+		}
+		roles[z.ID] = awsRole{ARN: z.Role, ExternalID: z.ExternalID}
+	}
+	o.ZoneRoles = roles
+	o.RecordTTL = c.RecordTTL
+	o.Policy = c.Policy
+	o.PruneSource = c.PruneSource
+	o.RecordsRefresh = time.Duration(c.RecordsRefresh)
+	o.Resolvers = c.Resolvers
+	o.PrivateTargets = c.PrivateTargets
+	o.PrivateResolvers = c.PrivateResolvers
+	o.ClusterID = c.ClusterID
+	o.AdoptUnowned = c.AdoptUnowned
+	o.AWS = c.AWS
+	o.Namespaces = c.Filters.Namespaces
+	o.HostnameFilters = c.Filters.Hostnames
+}
+
+// watchConfigFile calls reload every time the modification time of the file
+// changes, until stop is closed.
+func watchConfigFile(path string, interval time.Duration, stop <-chan struct{}, reload func()) {
+	var lastMod time.Time
+	if fi, err := os.Stat(path); err == nil {
+		lastMod = fi.ModTime()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			fi, err := os.Stat(path)
+			if err != nil {
+				log.Printf("[ERROR] could not stat config file %s: %+v", path, err)
+				continue
+			}
+			if fi.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = fi.ModTime()
+			log.Printf("[INFO] config file %s has changed", path)
+			reload()
+		case <-stop:
+			return
+		}
+	}
+}
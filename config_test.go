@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func writeTestConfig(t *testing.T, dir string, name string, data string) string {
+	p := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatalf("could not write test config: %+v", err)
+	}
+	return p
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	expected := &config{
+		Targets:         []string{testPrivateTarget, testPublicTarget},
+		TargetLabelName: testTargetLabelName,
+		Zones:           []zoneConfig{{ID: "A"}, {ID: "B"}},
+		RecordTTL:       300,
+		Policy:          policyUpsertOnly,
+		RecordsRefresh:  duration(time.Minute),
+		Filters: filterConfig{
+			Namespaces: []string{"default"},
+			Hostnames:  []string{"*.example.com"},
+		},
+		Etcd: etcdOptions{LeaseTTL: duration(48 * time.Hour)},
+	}
+
+	testCases := []struct {
+		name string
+		data string
+	}{
+		{"config.yaml", `
+targets:
+- private.cluster-entrypoint.com
+- public.cluster-entrypoint.com
+targetLabel: ingress53.target
+zones:
+- id: A
+- id: B
+ttl: 300
+policy: upsert-only
+recordsRefresh: 1m
+filters:
+  namespaces: [default]
+  hostnames: ["*.example.com"]
+etcd:
+  leaseTTL: 48h
+`},
+		{"config.json", `{
+  "targets": ["private.cluster-entrypoint.com", "public.cluster-entrypoint.com"],
+  "targetLabel": "ingress53.target",
+  "zones": [{"id": "A"}, {"id": "B"}],
+  "ttl": 300,
+  "policy": "upsert-only",
+  "recordsRefresh": "1m",
+  "filters": {"namespaces": ["default"], "hostnames": ["*.example.com"]},
+  "etcd": {"leaseTTL": "48h"}
+}`},
+	}
+
+	for i, tc := range testCases {
+		c, err := loadConfig(writeTestConfig(t, dir, tc.name, tc.data))
+		if err != nil {
+			t.Errorf("loadConfig returned unexpected error for test case #%02d: %+v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("loadConfig returned unexpected config for test case #%02d: %+v", i, c)
+		}
+	}
+
+	if _, err := loadConfig(filepath.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("loadConfig did not return expected error")
+	}
+	if _, err := loadConfig(writeTestConfig(t, dir, "invalid.yaml", "recordsRefresh: 5 minutes")); err == nil {
+		t.Errorf("loadConfig did not return expected error for an invalid duration")
+	}
+}
+
+func TestConfig_applyTo(t *testing.T) {
+	c := &config{
+		Targets:         []string{testPrivateTarget},
+		TargetLabelName: testTargetLabelName,
+		Zones:           []zoneConfig{{ID: "A"}, {ID: "B", Role: "arn:aws:iam::2:role/dns", ExternalID: "two"}},
+		RecordTTL:       300,
+		Policy:          policySync,
+		RecordsRefresh:  duration(time.Minute),
+		AWS:             awsOptions{RoleARN: "arn:aws:iam::1:role/dns"},
+		Filters:         filterConfig{Namespaces: []string{"default"}},
+	}
+	expected := registratorOptions{
+		Targets:         []string{testPrivateTarget},
+		TargetLabelName: testTargetLabelName,
+		ZoneIDs:         []string{"A", "B"},
+		ZoneRoles:       map[string]awsRole{"B": {ARN: "arn:aws:iam::2:role/dns", ExternalID: "two"}},
+		AWS:             awsOptions{RoleARN: "arn:aws:iam::1:role/dns"},
+		RecordTTL:       300,
+		Policy:          policySync,
+		RecordsRefresh:  time.Minute,
+		Namespaces:      []string{"default"},
+	}
+	o := registratorOptions{}
+	c.applyTo(&o)
+	if !reflect.DeepEqual(o, expected) {
+		t.Errorf("config.applyTo produced unexpected options: %+v", o)
+	}
+}
+
+func TestWatchConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := writeTestConfig(t, dir, "config.yaml", "ttl: 60")
+	reloaded := make(chan struct{}, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go watchConfigFile(p, 10*time.Millisecond, stop, func() { reloaded <- struct{}{} })
+
+	time.Sleep(100 * time.Millisecond) // let the watcher read the initial modification time
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(p, future, future); err != nil {
+		t.Fatalf("could not change config file times: %+v", err)
+	}
+	select {
+	case <-reloaded:
+	case <-time.After(5 * time.Second):
+		t.Errorf("watchConfigFile did not call reload after the file changed")
+	}
+}
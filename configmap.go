@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+var errInvalidConfigMapName = errors.New("configmap name must be in the namespace/name format")
+
+type configMapHandlerFunc func(data map[string]string)
+
+// configMapWatcher watches a single configmap and calls the handler with its
+// data every time it changes. A deleted configmap is not reported, so that
+// the last known data stays in use until it's created again.
+type configMapWatcher struct {
+	client       kubernetes.Interface
+	handler      configMapHandlerFunc
+	namespace    string
+	name         string
+	resyncPeriod time.Duration
+	stopChannel  chan struct{}
+}
+
+func newConfigMapWatcher(client kubernetes.Interface, namespace string, name string, handler configMapHandlerFunc, resyncPeriod time.Duration) *configMapWatcher {
+	return &configMapWatcher{
+		client:       client,
+		handler:      handler,
+		namespace:    namespace,
+		name:         name,
+		resyncPeriod: resyncPeriod,
+		stopChannel:  make(chan struct{}),
+	}
+}
+
+// Get returns the current data of the configmap straight from the kubernetes
+// api.
+func (cw *configMapWatcher) Get() (map[string]string, error) {
+	cm, err := cw.client.CoreV1().ConfigMaps(cw.namespace).Get(cw.name, v1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return cm.Data, nil
+}
+
+func (cw *configMapWatcher) Start() {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", cw.name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options v1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return cw.client.CoreV1().ConfigMaps(cw.namespace).List(options)
+		},
+		WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return cw.client.CoreV1().ConfigMaps(cw.namespace).Watch(options)
+		},
+	}
+	eh := cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cw.handle(obj, false)
+		},
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			cw.handle(newObj, false)
+		},
+		DeleteFunc: func(obj interface{}) {
+			cw.handle(obj, true)
+		},
+	}
+	_, controller := cache.NewInformer(lw, &corev1.ConfigMap{}, cw.resyncPeriod, eh)
+	log.Printf("[INFO] starting configmap watcher for %s/%s", cw.namespace, cw.name)
+	controller.Run(cw.stopChannel)
+	log.Printf("[INFO] configmap watcher for %s/%s stopped", cw.namespace, cw.name)
+}
+
+func (cw *configMapWatcher) handle(obj interface{}, deleted bool) {
+	if d, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = d.Obj
+	}
+	cm, ok := obj.(*corev1.ConfigMap)
+	if !ok || cm.Name != cw.name {
+		return
+	}
+	if deleted {
+		log.Printf("[ERROR] configmap %s/%s was deleted, will keep using its last known data", cw.namespace, cw.name)
+		return
+	}
+	cw.handler(cm.Data)
+}
+
+func (cw *configMapWatcher) Stop() {
+	log.Printf("[INFO] stopping configmap watcher for %s/%s ...", cw.namespace, cw.name)
+	close(cw.stopChannel)
+}
+
+func parseConfigMapName(s string) (string, string, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errInvalidConfigMapName
+	}
+	return parts[0], parts[1], nil
+}
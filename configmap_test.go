@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	testcore "k8s.io/client-go/testing"
+)
+
+var (
+	testTargetsConfigMap = &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "ingress53-targets",
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{testAlias: testPrivateTarget},
+	}
+
+	testTargetsConfigMapModified = &corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "ingress53-targets",
+			Namespace: "kube-system",
+		},
+		Data: map[string]string{testAlias: testPublicTarget},
+	}
+)
+
+func TestConfigMapWatcher(t *testing.T) {
+	expected := []map[string]string{
+		{testAlias: testPrivateTarget},
+		{testAlias: testPublicTarget},
+		{testAlias: testPrivateTarget},
+	}
+
+	client := fake.NewSimpleClientset(testTargetsConfigMap)
+	watcher := watch.NewFake()
+	client.PrependWatchReactor("configmaps", testcore.DefaultWatchReactor(watcher, nil))
+
+	pM := &sync.Mutex{}
+	processed := []map[string]string{}
+	cw := newConfigMapWatcher(client, "kube-system", "ingress53-targets", func(data map[string]string) {
+		pM.Lock()
+		processed = append(processed, data)
+		pM.Unlock()
+	}, 0)
+
+	data, err := cw.Get()
+	if err != nil {
+		t.Fatalf("configMapWatcher.Get returned unexpected error: %+v", err)
+	}
+	if !reflect.DeepEqual(data, testTargetsConfigMap.Data) {
+		t.Errorf("configMapWatcher.Get returned unexpected data: %+v", data)
+	}
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cw.Start()
+	}()
+
+	pLenIs := func(n int) func() bool {
+		return func() bool {
+			pM.Lock()
+			defer pM.Unlock()
+			return len(processed) == n
+		}
+	}
+	if err := waitForTrue(pLenIs(1), 10*time.Second); err != nil {
+		t.Fatalf("timed out waiting for configMapWatcher to process events")
+	}
+	watcher.Modify(testTargetsConfigMapModified)
+	if err := waitForTrue(pLenIs(2), 10*time.Second); err != nil {
+		t.Fatalf("timed out waiting for configMapWatcher to process events")
+	}
+	// deleting the configmap is not reported, only creating it again
+	watcher.Delete(testTargetsConfigMapModified)
+	time.Sleep(100 * time.Millisecond)
+	if !pLenIs(2)() {
+		t.Errorf("configMapWatcher reported the deletion of the configmap")
+	}
+	watcher.Add(testTargetsConfigMap)
+	if err := waitForTrue(pLenIs(3), 10*time.Second); err != nil {
+		t.Fatalf("timed out waiting for configMapWatcher to process events")
+	}
+
+	cw.Stop()
+	wg.Wait()
+
+	pM.Lock()
+	if !reflect.DeepEqual(processed, expected) {
+		t.Errorf("configMapWatcher did not produce expected results: %+v != %+v", processed, expected)
+	}
+	pM.Unlock()
+}
+
+func TestParseConfigMapName(t *testing.T) {
+	testCases := []struct {
+		value     string
+		namespace string
+		name      string
+		err       error
+	}{
+		{"kube-system/ingress53", "kube-system", "ingress53", nil},
+		{"ingress53", "", "", errInvalidConfigMapName},
+		{"/ingress53", "", "", errInvalidConfigMapName},
+		{"a/b/c", "", "", errInvalidConfigMapName},
+	}
+
+	for i, tc := range testCases {
+		namespace, name, err := parseConfigMapName(tc.value)
+		if namespace != tc.namespace || name != tc.name || err != tc.err {
+			t.Errorf("parseConfigMapName returned unexpected result for test case #%02d: %s, %s, %+v", i, namespace, name, err)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The end to end tests run the registrator, through the route53 provider,
+// against an in memory route53 api, or against a route53 compatible api such
+// as moto (moto_server -p 5000) or localstack if its url is set in
+// INGRESS53_E2E_ROUTE53_ENDPOINT.
+const envE2ERoute53Endpoint = "INGRESS53_E2E_ROUTE53_ENDPOINT"
+
+// newE2ERoute53 returns the api the tests run against, the options of the
+// registrator to use it and a function that restores the route53 provider.
+func newE2ERoute53(t *testing.T) (route53iface.Route53API, registratorOptions, func()) {
+	options := registratorOptions{
+		AWSSessionOptions: &session.Options{Config: aws.Config{Credentials: credentials.NewStaticCredentials("e2e", "e2e", "")}},
+		AWS:               awsOptions{Region: "us-east-1"},
+	}
+	endpoint := os.Getenv(envE2ERoute53Endpoint)
+	if endpoint == "" {
+		api := newFakeRoute53()
+		newAPI := newRoute53API
+		newRoute53API = func(sess *session.Session, configs ...*aws.Config) route53iface.Route53API { return api }
+		return api, options, func() { newRoute53API = newAPI }
+	}
+	options.AWS.Route53Endpoint = endpoint
+	sess, err := newAWSSession(options)
+	if err != nil {
+		t.Fatalf("could not create aws session: %+v", err)
+	}
+	return route53.New(sess, &aws.Config{Endpoint: aws.String(endpoint)}), options, func() {}
+}
+
+// createE2EZone creates a hosted zone with a unique name, returning its id
+// and name.
+func createE2EZone(t *testing.T, api route53iface.Route53API) (string, string) {
+	name := fmt.Sprintf("e2e-%d.example.com.", time.Now().UnixNano())
+	if f, ok := api.(*fakeRoute53); ok {
+		return f.addZone(name, false), name
+	}
+	out, err := api.CreateHostedZone(&route53.CreateHostedZoneInput{
+		Name:            aws.String(name),
+		CallerReference: aws.String(name),
+	})
+	if err != nil {
+		t.Fatalf("could not create hosted zone: %+v", err)
+	}
+	return aws.StringValue(out.HostedZone.Id), name
+}
+
+// listE2ECnames returns the CNAME records of the zone, by hostname.
+func listE2ECnames(api route53iface.Route53API, id string) (map[string]string, error) {
+	ret := map[string]string{}
+	err := api.ListResourceRecordSetsPagesWithContext(context.Background(), &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(id)}, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, rrs := range page.ResourceRecordSets {
+			if aws.StringValue(rrs.Type) != route53.RRTypeCname || len(rrs.ResourceRecords) == 0 {
+				continue
+			}
+			ret[strings.Trim(aws.StringValue(rrs.Name), ".")] = strings.Trim(aws.StringValue(rrs.ResourceRecords[0].Value), ".")
+		}
+		return true
+	})
+	return ret, err
+}
+
+func newE2EIngress(name string, hostname string, target string) *v1beta1.Ingress {
+	return &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{testTargetLabelName: target}},
+		Spec:       v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{Host: hostname}}},
+	}
+}
+
+func TestE2E_syncOnce(t *testing.T) {
+	api, options, restore := newE2ERoute53(t)
+	defer restore()
+	id, name := createE2EZone(t, api)
+	zone := strings.Trim(name, ".")
+
+	a := newE2EIngress("a", "a."+zone, testPrivateTarget)
+	b := newE2EIngress("b", "b."+zone, testPublicTarget)
+	other := newE2EIngress("other", "c.example.org", testPublicTarget)
+	client, _ := newTestIngressWatcherClient(*a, *b, *other)
+	options.KubernetesClient = client
+	options.Targets = []string{testPrivateTarget, testPublicTarget}
+	options.TargetLabelName = testTargetLabelName
+	options.ZoneIDs = []string{id}
+	options.PruneSource = pruneSourceProvider
+	r, err := newRegistratorWithOptions(options)
+	if err != nil {
+		t.Fatalf("newRegistratorWithOptions returned unexpected error: %+v", err)
+	}
+	s, err := r.SyncOnce()
+	if err != nil || s.Ingresses != 3 || s.Changed != 2 {
+		t.Errorf("registrator.SyncOnce returned unexpected result: %+v, %+v", s, err)
+	}
+	expected := map[string]string{"a." + zone: testPrivateTarget, "b." + zone: testPublicTarget}
+	if records, err := listE2ECnames(api, id); err != nil || !reflect.DeepEqual(records, expected) {
+		t.Errorf("registrator.SyncOnce wrote unexpected records: %+v, %+v", records, err)
+	}
+}
+
+func TestE2E_watch(t *testing.T) {
+	api, options, restore := newE2ERoute53(t)
+	defer restore()
+	id, name := createE2EZone(t, api)
+	zone := strings.Trim(name, ".")
+
+	a := newE2EIngress("a", "a."+zone, testPrivateTarget)
+	client, watcher := newTestIngressWatcherClient(*a)
+	options.KubernetesClient = client
+	options.Targets = []string{testPrivateTarget, testPublicTarget}
+	options.TargetLabelName = testTargetLabelName
+	options.ZoneIDs = []string{id}
+	options.PruneSource = pruneSourceProvider
+	options.RecordsRefresh = time.Second
+	r, err := newRegistratorWithOptions(options)
+	if err != nil {
+		t.Fatalf("newRegistratorWithOptions returned unexpected error: %+v", err)
+	}
+	stopped := make(chan error)
+	go func() { stopped <- r.Start() }()
+
+	expectRecords := func(expected map[string]string) {
+		var records map[string]string
+		if werr := waitForTrue(func() bool {
+			records, err = listE2ECnames(api, id)
+			return err == nil && reflect.DeepEqual(records, expected)
+		}, 30*time.Second); werr != nil {
+			t.Fatalf("registrator did not write the expected records: %+v, %+v, %+v", records, err, werr)
+		}
+	}
+
+	// existing ingresses are synced on start
+	expectRecords(map[string]string{"a." + zone: testPrivateTarget})
+
+	b := newE2EIngress("b", "b."+zone, testPublicTarget)
+	watcher.Add(b)
+	expectRecords(map[string]string{"a." + zone: testPrivateTarget, "b." + zone: testPublicTarget})
+
+	moved := newE2EIngress("b", "b."+zone, testPrivateTarget)
+	watcher.Modify(moved)
+	expectRecords(map[string]string{"a." + zone: testPrivateTarget, "b." + zone: testPrivateTarget})
+
+	watcher.Delete(a)
+	expectRecords(map[string]string{"b." + zone: testPrivateTarget})
+
+	r.Stop()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("registrator.Start returned unexpected error: %+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("registrator did not stop")
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/coreos/etcd/clientv3"
+	"github.com/miekg/dns"
+)
+
+const (
+	providerEtcd      = "etcd"
+	etcdKeyPrefix     = "ingress53-"
+	defaultEtcdPrefix = "/skydns"
+)
+
+var (
+	errEtcdMissingEndpoints = errors.New("the etcd provider requires at least one endpoint")
+	errEtcdUnsupportedType  = errors.New("the etcd provider only supports CNAME, A, AAAA and TXT records")
+
+	defaultEtcdTimeout    = 5 * time.Second
+	etcdLeaseRetryBackoff = 5 * time.Second
+)
+
+func init() {
+	registerProvider(providerEtcd, newEtcdProvider)
+}
+
+// etcdOptions are the settings of the etcd provider.
+type etcdOptions struct {
+	Endpoints []string `json:"endpoints"`
+	Prefix    string   `json:"prefix"` // defaults to /skydns
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	LeaseTTL  duration `json:"leaseTTL"` // how long the keys outlive ingress53, defaults to the ttl of the records
+}
+
+// etcdService is the value of a key in the SkyDNS layout, as read by the
+// CoreDNS etcd plugin: a host is served as an A or AAAA record if it's an IP
+// address and as a CNAME record otherwise, and text as a TXT record.
+type etcdService struct {
+	Host string `json:"host,omitempty"`
+	Text string `json:"text,omitempty"`
+	TTL  uint32 `json:"ttl,omitempty"`
+}
+
+// etcdProvider writes the records to etcd in the SkyDNS layout, for CoreDNS
+// to serve them. The keys are attached to a lease, which is kept alive while
+// ingress53 runs. Its TTL defaults to the TTL of the records, and can be made
+// longer for them to still be served if ingress53 cannot reach etcd for a while.
+type etcdProvider struct {
+	client *clientv3.Client
+	prefix string
+}
+
+func newEtcdProvider(options registratorOptions) (dnsProvider, error) {
+	o := options.Etcd
+	if len(o.Endpoints) == 0 {
+		return nil, errEtcdMissingEndpoints
+	}
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   o.Endpoints,
+		DialTimeout: defaultEtcdTimeout,
+		Username:    o.Username,
+		Password:    o.Password,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p := &etcdProvider{client: client, prefix: strings.TrimSuffix(o.Prefix, "/")}
+	if p.prefix == "" {
+		p.prefix = defaultEtcdPrefix
+	}
+	log.Printf("[INFO] setup etcd provider for %s", strings.Join(o.Endpoints, ","))
+	return p, nil
+}
+
+// NewZone returns the zone with the name, and attaches the keys ingress53
+// wrote for it to a new lease.
+func (p *etcdProvider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	z := &etcdZone{
+		provider: p,
+		Name:     dns.Fqdn(strings.ToLower(id)),
+		TTL:      options.RecordTTL,
+		LeaseTTL: int64(time.Duration(options.Etcd.LeaseTTL) / time.Second),
+	}
+	if z.LeaseTTL <= 0 {
+		z.LeaseTTL = z.TTL
+	}
+	ctx, cancel := context.WithTimeout(ctx, defaultEtcdTimeout)
+	defer cancel()
+	if err := z.grantLease(ctx); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (p *etcdProvider) Capabilities() providerCapabilities {
+	return providerCapabilities{TXT: true}
+}
+
+// path returns the etcd path of the name: its labels in reverse order, under
+// the prefix.
+func (p *etcdProvider) path(name string) string {
+	labels := dns.SplitDomainName(strings.ToLower(name))
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return p.prefix + "/" + strings.Join(labels, "/")
+}
+
+// name returns the name of the record the key holds.
+func (p *etcdProvider) name(key string) string {
+	segments := strings.Split(strings.TrimPrefix(key, p.prefix+"/"), "/")
+	labels := segments[:len(segments)-1]
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".")
+}
+
+// etcdZone is a zone written to etcd by an etcd provider.
+type etcdZone struct {
+	provider *etcdProvider
+	Name     string
+	TTL      int64
+	LeaseTTL int64 // in seconds
+	mu       sync.Mutex
+	lease    clientv3.LeaseID
+}
+
+// currentLease returns the lease the keys are attached to, which is replaced
+// by Run when it's lost.
+func (z *etcdZone) currentLease() clientv3.LeaseID {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	return z.lease
+}
+
+// grantLease grants a new lease and moves the keys ingress53 wrote for the
+// zone to it.
+func (z *etcdZone) grantLease(ctx context.Context) error {
+	lease, err := z.provider.client.Grant(ctx, z.LeaseTTL)
+	if err != nil {
+		return err
+	}
+	resp, err := z.provider.client.Get(ctx, z.provider.path(z.Name)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	ops := []clientv3.Op{}
+	for _, kv := range resp.Kvs {
+		if strings.HasPrefix(keyBase(string(kv.Key)), etcdKeyPrefix) {
+			ops = append(ops, clientv3.OpPut(string(kv.Key), string(kv.Value), clientv3.WithLease(lease.ID)))
+		}
+	}
+	if len(ops) > 0 {
+		if _, err := z.provider.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+			return err
+		}
+	}
+	z.mu.Lock()
+	z.lease = lease.ID
+	z.mu.Unlock()
+	log.Printf("[DEBUG] attached %d key(s) of zone %s to etcd lease %x", len(ops), z.Name, lease.ID)
+	return nil
+}
+
+// Run keeps the lease of the zone alive, granting a new one if it's lost.
+func (z *etcdZone) Run(ctx context.Context) {
+	for {
+		ch, err := z.provider.client.KeepAlive(ctx, z.currentLease())
+		if err == nil {
+			for range ch {
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("[ERROR] lost etcd lease of zone %s, will grant a new one: %+v", z.Name, err)
+		select {
+		case <-time.After(etcdLeaseRetryBackoff):
+		case <-ctx.Done():
+			return
+		}
+		gctx, cancel := context.WithTimeout(ctx, defaultEtcdTimeout)
+		if err := z.grantLease(gctx); err != nil {
+			log.Printf("[ERROR] could not grant etcd lease of zone %s: %+v", z.Name, err)
+		}
+		cancel()
+	}
+}
+
+// WaitForChanges returns straight away, as the changes are applied by the
+// time etcd acknowledges them.
+func (z *etcdZone) WaitForChanges(ctx context.Context) error {
+	return nil
+}
+
+func (z *etcdZone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionUpsert, records)
+}
+
+func (z *etcdZone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionDelete, records)
+}
+
+// changeRecords replaces the keys of the records in a single transaction. Each
+// value is written to its own key, named after the type of the record, so
+// that the keys of other types and other writers are left alone.
+func (z *etcdZone) changeRecords(ctx context.Context, action string, records []dnsRecord) error {
+	lease := z.currentLease()
+	ops := []clientv3.Op{}
+	for _, r := range records {
+		prefix := z.provider.path(r.Hostname) + "/" + etcdKeyPrefix + strings.ToLower(r.Type) + "-"
+		ops = append(ops, clientv3.OpDelete(prefix, clientv3.WithPrefix()))
+		if action == route53.ChangeActionDelete {
+			continue
+		}
+		for i, v := range r.Values {
+			s, err := z.service(r.Type, v)
+			if err != nil {
+				return err
+			}
+			value, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			ops = append(ops, clientv3.OpPut(fmt.Sprintf("%s%d", prefix, i), string(value), clientv3.WithLease(lease)))
+		}
+	}
+	_, err := z.provider.client.Txn(ctx).Then(ops...).Commit()
+	return err
+}
+
+func (z *etcdZone) service(rrType string, value string) (etcdService, error) {
+	switch rrType {
+	case route53.RRTypeCname, route53.RRTypeA, route53.RRTypeAaaa:
+		return etcdService{Host: value, TTL: uint32(z.TTL)}, nil
+	case route53.RRTypeTxt:
+		return etcdService{Text: strings.Trim(value, `"`), TTL: uint32(z.TTL)}, nil
+	default:
+		return etcdService{}, errEtcdUnsupportedType
+	}
+}
+
+// LookupRecords returns the records of the name, of any writer, with their
+// type worked out the same way CoreDNS does.
+func (z *etcdZone) LookupRecords(name string) ([]zoneRecord, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultEtcdTimeout)
+	defer cancel()
+	resp, err := z.provider.client.Get(ctx, z.provider.path(name)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	rrs := []dns.RR{}
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		if z.provider.name(key) != strings.Trim(strings.ToLower(name), ".") {
+			continue
+		}
+		s := etcdService{}
+		if err := json.Unmarshal(kv.Value, &s); err != nil {
+			log.Printf("[DEBUG] ignoring etcd key %s: %+v", key, err)
+			continue
+		}
+		rr, err := s.rr(dns.Fqdn(name), z.TTL)
+		if err != nil {
+			log.Printf("[DEBUG] ignoring etcd key %s: %+v", key, err)
+			continue
+		}
+		rrs = append(rrs, rr)
+	}
+	return zoneRecordsFromRRs(rrs), nil
+}
+
+// rr returns the resource record CoreDNS serves for the service.
+func (s etcdService) rr(name string, defaultTTL int64) (dns.RR, error) {
+	ttl := int64(s.TTL)
+	if ttl == 0 {
+		ttl = defaultTTL
+	}
+	if s.Host == "" {
+		return dns.NewRR(fmt.Sprintf(`%s %d IN TXT "%s"`, name, ttl, s.Text))
+	}
+	ip := net.ParseIP(s.Host)
+	switch {
+	case ip == nil:
+		return dns.NewRR(fmt.Sprintf("%s %d IN CNAME %s", name, ttl, dns.Fqdn(s.Host)))
+	case ip.To4() != nil:
+		return dns.NewRR(fmt.Sprintf("%s %d IN A %s", name, ttl, ip))
+	default:
+		return dns.NewRR(fmt.Sprintf("%s %d IN AAAA %s", name, ttl, ip))
+	}
+}
+
+func (z *etcdZone) Domain() string {
+	return z.Name
+}
+
+// ListNameservers returns no nameservers, as the ones serving the zone are
+// not known: the records are checked by listing them or by querying the
+// resolvers instead.
+func (z *etcdZone) ListNameservers() []string {
+	return nil
+}
+
+// keyBase returns the last segment of the key.
+func keyBase(key string) string {
+	return key[strings.LastIndex(key, "/")+1:]
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/etcd/clientv3"
+	"github.com/coreos/etcd/embed"
+)
+
+// freeURL returns a url on a local port that is free to listen on.
+func freeURL() (url.URL, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return url.URL{}, err
+	}
+	defer l.Close()
+	return url.URL{Scheme: "http", Host: l.Addr().String()}, nil
+}
+
+// startEmbeddedEtcd starts a single member etcd cluster in the directory and
+// returns its client endpoint.
+func startEmbeddedEtcd(dir string) (*embed.Etcd, string, error) {
+	cfg := embed.NewConfig()
+	cfg.Dir = dir
+	client, err := freeURL()
+	if err != nil {
+		return nil, "", err
+	}
+	peer, err := freeURL()
+	if err != nil {
+		return nil, "", err
+	}
+	cfg.LCUrls, cfg.ACUrls = []url.URL{client}, []url.URL{client}
+	cfg.LPUrls, cfg.APUrls = []url.URL{peer}, []url.URL{peer}
+	cfg.InitialCluster = fmt.Sprintf("%s=%s", cfg.Name, peer.String())
+	e, err := embed.StartEtcd(cfg)
+	if err != nil {
+		return nil, "", err
+	}
+	select {
+	case <-e.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		e.Close()
+		return nil, "", fmt.Errorf("embedded etcd did not start")
+	}
+	return e, client.String(), nil
+}
+
+func TestEtcdProvider_path(t *testing.T) {
+	p := &etcdProvider{prefix: defaultEtcdPrefix}
+	testCases := []struct {
+		name    string
+		path    string
+		keyName string
+	}{
+		{"a.example.com", "/skydns/com/example/a", "a.example.com"},
+		{"A.Example.com.", "/skydns/com/example/a", "a.example.com"},
+		{"_ingress53.b.example.com", "/skydns/com/example/b/_ingress53", "_ingress53.b.example.com"},
+	}
+	for i, tc := range testCases {
+		path := p.path(tc.name)
+		if path != tc.path {
+			t.Errorf("etcdProvider.path returned unexpected path for test case #%02d: %s", i, path)
+		}
+		if name := p.name(path + "/ingress53-a-0"); name != tc.keyName {
+			t.Errorf("etcdProvider.name returned unexpected name for test case #%02d: %s", i, name)
+		}
+	}
+}
+
+func TestNewEtcdProvider(t *testing.T) {
+	if _, err := newEtcdProvider(registratorOptions{}); err != errEtcdMissingEndpoints {
+		t.Errorf("newEtcdProvider returned unexpected error: %+v", err)
+	}
+}
+
+func TestEtcdZone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+	e, endpoint, err := startEmbeddedEtcd(dir)
+	if err != nil {
+		t.Fatalf("could not start embedded etcd: %+v", err)
+	}
+	defer e.Close()
+
+	options := registratorOptions{RecordTTL: 60, Etcd: etcdOptions{Endpoints: []string{endpoint}}}
+	p, err := newEtcdProvider(options)
+	if err != nil {
+		t.Fatalf("newEtcdProvider returned unexpected error: %+v", err)
+	}
+	client := p.(*etcdProvider).client
+	defer client.Close()
+	// keys of other writers are left alone
+	if _, err := client.Put(context.Background(), "/skydns/com/example/b/x1", `{"host":"10.0.0.9"}`); err != nil {
+		t.Fatalf("could not write etcd key: %+v", err)
+	}
+
+	dz, err := p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("etcdProvider.NewZone returned unexpected error: %+v", err)
+	}
+	z := dz.(*etcdZone)
+
+	cname := newCnameRecord("a.example.com", testPrivateTarget)
+	ips := dnsRecord{Hostname: "b.example.com", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}}
+	owner := ownerRecord(ips, "one")
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{cname, ips, owner}); err != nil {
+		t.Fatalf("etcdZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	// upserts replace the current values
+	ips.Values = []string{"10.0.0.3"}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{ips}); err != nil {
+		t.Fatalf("etcdZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{{Hostname: "c.example.com", Type: "MX", Values: []string{"10 mx.example.com"}}}); err != errEtcdUnsupportedType {
+		t.Errorf("etcdZone.UpsertRecords returned unexpected error for an unsupported type: %+v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		expected []zoneRecord
+	}{
+		{"a.example.com", []zoneRecord{{Name: "a.example.com.", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}}}},
+		{"b.example.com", []zoneRecord{{Name: "b.example.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.3", "10.0.0.9"}}}},
+		{"_ingress53.b.example.com", []zoneRecord{{Name: "_ingress53.b.example.com.", Type: "TXT", TTL: 60, Values: owner.Values}}},
+		{"c.example.com", []zoneRecord{}},
+	}
+	for i, tc := range testCases {
+		records, err := z.LookupRecords(tc.name)
+		if err != nil || !reflect.DeepEqual(records, tc.expected) {
+			t.Errorf("etcdZone.LookupRecords returned unexpected result for test case #%02d: %+v, %+v", i, records, err)
+		}
+	}
+
+	// the keys are attached to a lease with the ttl of the records
+	resp, err := client.Get(context.Background(), "/skydns/com/example/a/ingress53-cname-0")
+	if err != nil || len(resp.Kvs) != 1 || clientv3.LeaseID(resp.Kvs[0].Lease) != z.currentLease() {
+		t.Fatalf("etcdZone.UpsertRecords did not attach the key to the lease: %+v, %+v", resp, err)
+	}
+	ttl, err := client.TimeToLive(context.Background(), z.currentLease())
+	if err != nil || ttl.GrantedTTL != 60 {
+		t.Errorf("etcdZone lease has unexpected ttl: %+v, %+v", ttl, err)
+	}
+
+	// a new zone moves the keys to its own lease
+	options.Etcd.LeaseTTL = duration(time.Hour)
+	dz, err = p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("etcdProvider.NewZone returned unexpected error: %+v", err)
+	}
+	if _, err := client.Revoke(context.Background(), z.currentLease()); err != nil {
+		t.Fatalf("could not revoke etcd lease: %+v", err)
+	}
+	z = dz.(*etcdZone)
+	if records, _ := z.LookupRecords("a.example.com"); len(records) != 1 {
+		t.Errorf("etcdProvider.NewZone did not move the keys to the new lease: %+v", records)
+	}
+	ttl, err = client.TimeToLive(context.Background(), z.currentLease())
+	if err != nil || ttl.GrantedTTL != 3600 {
+		t.Errorf("etcdZone lease has unexpected ttl: %+v, %+v", ttl, err)
+	}
+
+	// a lost lease is replaced while changes are being applied
+	defer func(backoff time.Duration) { etcdLeaseRetryBackoff = backoff }(etcdLeaseRetryBackoff)
+	etcdLeaseRetryBackoff = 10 * time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go z.Run(ctx)
+	lost := z.currentLease()
+	if _, err := client.Revoke(context.Background(), lost); err != nil {
+		t.Fatalf("could not revoke etcd lease: %+v", err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for z.currentLease() == lost && time.Now().Before(deadline) {
+		if err := z.UpsertRecords(context.Background(), []dnsRecord{cname}); err != nil {
+			t.Logf("etcdZone.UpsertRecords returned error while the lease is lost: %+v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if z.currentLease() == lost {
+		t.Fatalf("etcdZone.Run did not grant a new lease")
+	}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{cname}); err != nil {
+		t.Fatalf("etcdZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	resp, err = client.Get(context.Background(), "/skydns/com/example/a/ingress53-cname-0")
+	if err != nil || len(resp.Kvs) != 1 || clientv3.LeaseID(resp.Kvs[0].Lease) != z.currentLease() {
+		t.Errorf("etcdZone.UpsertRecords did not attach the key to the new lease: %+v, %+v", resp, err)
+	}
+
+	if err := z.DeleteRecords(context.Background(), []dnsRecord{cname, ips}); err != nil {
+		t.Fatalf("etcdZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if records, _ := z.LookupRecords("a.example.com"); len(records) != 0 {
+		t.Errorf("etcdZone.LookupRecords returned a deleted record: %+v", records)
+	}
+	expected := []zoneRecord{{Name: "b.example.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.9"}}}
+	if records, _ := z.LookupRecords("b.example.com"); !reflect.DeepEqual(records, expected) {
+		t.Errorf("etcdZone.DeleteRecords removed keys of other writers: %+v", records)
+	}
+}
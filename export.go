@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/ghodss/yaml"
+	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	providerExport   = "export"
+	exportFormatZone = "zone"
+	exportFormatYAML = "yaml"
+	exportHeader     = "generated by ingress53, manual changes will be overwritten"
+)
+
+var (
+	errExportInvalidFormat = errors.New("the export format must be zone or yaml")
+	errExportInvalidOutput = errors.New("the export provider requires either a path or a configmap")
+
+	exportFileMode = os.FileMode(0644)
+)
+
+func init() {
+	registerProvider(providerExport, newExportProvider)
+}
+
+// exportOptions are the settings of the export provider.
+type exportOptions struct {
+	Format    string `json:"format"`    // zone (default) or yaml
+	Path      string `json:"path"`      // directory the files are written to
+	ConfigMap string `json:"configMap"` // namespace/name of the configmap the files are written to
+}
+
+// exportProvider renders the records of the zones to files, one per zone,
+// instead of applying them, so that the changes can be reviewed and applied
+// by a separate pipeline. The files are written to a directory or to a
+// configmap, and are sorted so that they only change when the records do.
+type exportProvider struct {
+	mu        sync.Mutex
+	zones     map[string]*exportZone
+	writeMu   sync.Mutex // the zones share the configmap
+	format    string
+	path      string
+	client    kubernetes.Interface
+	namespace string
+	name      string
+}
+
+func newExportProvider(options registratorOptions) (dnsProvider, error) {
+	o := options.Export
+	p := &exportProvider{zones: map[string]*exportZone{}, format: o.Format, path: o.Path}
+	if p.format == "" {
+		p.format = exportFormatZone
+	}
+	if p.format != exportFormatZone && p.format != exportFormatYAML {
+		return nil, errExportInvalidFormat
+	}
+	if (o.Path == "") == (o.ConfigMap == "") {
+		return nil, errExportInvalidOutput
+	}
+	if o.ConfigMap != "" {
+		namespace, name, err := parseConfigMapName(o.ConfigMap)
+		if err != nil {
+			return nil, err
+		}
+		client := options.KubernetesClient
+		if client == nil {
+			if client, err = kubernetes.NewForConfig(options.KubernetesConfig); err != nil {
+				return nil, err
+			}
+		}
+		p.client, p.namespace, p.name = client, namespace, name
+		log.Printf("[INFO] exporting dns zones to configmap %s", o.ConfigMap)
+	} else {
+		log.Printf("[INFO] exporting dns zones to %s", o.Path)
+	}
+	return p, nil
+}
+
+// NewZone returns the zone with the name, with the records of its current
+// file. Zones are kept across reloads, so the same zone is returned for the
+// same name.
+func (p *exportProvider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	name := strings.ToLower(dns.Fqdn(id))
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if z, ok := p.zones[name]; ok {
+		z.setOptions(options)
+		return z, nil
+	}
+	z := &exportZone{
+		provider: p,
+		Name:     name,
+		TTL:      options.RecordTTL,
+		Owner:    exportOwner(options.ClusterID),
+		records:  map[string]zoneRecord{},
+	}
+	data, err := p.read(z.file())
+	if err != nil {
+		return nil, err
+	}
+	if err := z.parse(data); err != nil {
+		return nil, err
+	}
+	z.rendered = data
+	p.zones[name] = z
+	return z, nil
+}
+
+func (p *exportProvider) Capabilities() providerCapabilities {
+	return providerCapabilities{TXT: true}
+}
+
+// read returns the contents of the file, or nil if it doesn't exist.
+func (p *exportProvider) read(file string) ([]byte, error) {
+	if p.client == nil {
+		data, err := ioutil.ReadFile(filepath.Join(p.path, file))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return data, err
+	}
+	cm, err := p.client.CoreV1().ConfigMaps(p.namespace).Get(p.name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data, ok := cm.Data[file]; ok {
+		return []byte(data), nil
+	}
+	return nil, nil
+}
+
+// write replaces the file, creating the configmap if needed.
+func (p *exportProvider) write(file string, data []byte) error {
+	if p.client == nil {
+		path := filepath.Join(p.path, file)
+		tmp := path + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, exportFileMode); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	configMaps := p.client.CoreV1().ConfigMaps(p.namespace)
+	cm, err := configMaps.Get(p.name, v1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		cm = &corev1.ConfigMap{
+			ObjectMeta: v1.ObjectMeta{Name: p.name, Namespace: p.namespace},
+			Data:       map[string]string{file: string(data)},
+		}
+		_, err = configMaps.Create(cm)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	if cm.Data == nil {
+		cm.Data = map[string]string{}
+	}
+	cm.Data[file] = string(data)
+	_, err = configMaps.Update(cm)
+	return err
+}
+
+// exportOwner returns the ownership comment of the records written by the
+// cluster.
+func exportOwner(clusterID string) string {
+	if clusterID == "" {
+		return ownerHeritage
+	}
+	return ownerHeritage + "," + ownerClusterPrefix + clusterID
+}
+
+// exportZone is a zone rendered by the export provider. It only holds the
+// records ingress53 manages, so the file has no SOA or NS records.
+type exportZone struct {
+	provider *exportProvider
+	mu       sync.RWMutex
+	Name     string
+	TTL      int64
+	Owner    string
+	records  map[string]zoneRecord // by lowercase fqdn and type
+	rendered []byte
+}
+
+// exportRecord is a record in a yaml export.
+type exportRecord struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	TTL    int64    `json:"ttl"`
+	Values []string `json:"values"`
+	Owner  string   `json:"owner"`
+}
+
+// exportDocument is the content of a yaml export.
+type exportDocument struct {
+	Comment string         `json:"comment"`
+	Zone    string         `json:"zone"`
+	Records []exportRecord `json:"records"`
+}
+
+func (z *exportZone) setOptions(options registratorOptions) {
+	z.mu.Lock()
+	z.TTL = options.RecordTTL
+	z.Owner = exportOwner(options.ClusterID)
+	z.mu.Unlock()
+}
+
+// file returns the name of the file of the zone.
+func (z *exportZone) file() string {
+	return strings.TrimSuffix(z.Name, ".") + "." + z.provider.format
+}
+
+func (z *exportZone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(route53.ChangeActionUpsert, records)
+}
+
+func (z *exportZone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(route53.ChangeActionDelete, records)
+}
+
+// changeRecords applies the changes and writes the file of the zone, if its
+// content has changed.
+func (z *exportZone) changeRecords(action string, records []dnsRecord) error {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	for _, r := range records {
+		if _, err := resourceRecords(r, z.TTL); err != nil {
+			return err
+		}
+	}
+	for _, r := range records {
+		name := strings.ToLower(dns.Fqdn(r.Hostname))
+		key := name + " " + r.Type
+		if action == route53.ChangeActionDelete {
+			delete(z.records, key)
+		} else {
+			z.records[key] = zoneRecord{Name: name, Type: r.Type, TTL: z.TTL, Values: r.Values}
+		}
+	}
+	data, err := z.render()
+	if err != nil {
+		return err
+	}
+	if string(data) == string(z.rendered) {
+		return nil
+	}
+	if err := z.provider.write(z.file(), data); err != nil {
+		return err
+	}
+	z.rendered = data
+	log.Printf("[INFO] exported %d record(s) of zone %s", len(z.records), z.Name)
+	return nil
+}
+
+// sorted returns the records sorted by name and type, with sorted values.
+// The caller must hold the lock.
+func (z *exportZone) sorted() []zoneRecord {
+	ret := make([]zoneRecord, 0, len(z.records))
+	for _, r := range z.records {
+		r.Values = append([]string{}, r.Values...)
+		sort.Strings(r.Values)
+		ret = append(ret, r)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Name != ret[j].Name {
+			return ret[i].Name < ret[j].Name
+		}
+		return ret[i].Type < ret[j].Type
+	})
+	return ret
+}
+
+// render returns the content of the file of the zone. The caller must hold
+// the lock.
+func (z *exportZone) render() ([]byte, error) {
+	records := z.sorted()
+	if z.provider.format == exportFormatYAML {
+		doc := exportDocument{Comment: exportHeader, Zone: z.Name, Records: []exportRecord{}}
+		for _, r := range records {
+			doc.Records = append(doc.Records, exportRecord{Name: r.Name, Type: r.Type, TTL: r.TTL, Values: r.Values, Owner: z.Owner})
+		}
+		return yaml.Marshal(doc)
+	}
+	lines := []string{"; " + exportHeader, "$ORIGIN " + z.Name}
+	for _, r := range records {
+		rrs, err := resourceRecords(dnsRecord{Hostname: r.Name, Type: r.Type, Values: r.Values}, r.TTL)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, "; "+z.Owner)
+		for _, rr := range rrs {
+			lines = append(lines, rr.String())
+		}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// parse loads the records of the file of the zone.
+func (z *exportZone) parse(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	records := []zoneRecord{}
+	if z.provider.format == exportFormatYAML {
+		doc := exportDocument{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return err
+		}
+		for _, r := range doc.Records {
+			records = append(records, zoneRecord{Name: strings.ToLower(dns.Fqdn(r.Name)), Type: r.Type, TTL: r.TTL, Values: r.Values})
+		}
+	} else {
+		rrs := []dns.RR{}
+		for t := range dns.ParseZone(strings.NewReader(string(data)), z.Name, z.file()) {
+			if t.Error != nil {
+				return t.Error
+			}
+			rrs = append(rrs, t.RR)
+		}
+		records = zoneRecordsFromRRs(rrs)
+	}
+	for _, r := range records {
+		r.Name = strings.ToLower(r.Name)
+		z.records[r.Name+" "+r.Type] = r
+	}
+	log.Printf("[INFO] loaded %d record(s) of zone %s from %s", len(records), z.Name, z.file())
+	return nil
+}
+
+func (z *exportZone) LookupRecords(name string) ([]zoneRecord, error) {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+	name = strings.ToLower(dns.Fqdn(name))
+	ret := []zoneRecord{}
+	for _, r := range z.sorted() {
+		if r.Name == name {
+			ret = append(ret, r)
+		}
+	}
+	return ret, nil
+}
+
+func (z *exportZone) Domain() string {
+	return z.Name
+}
+
+// ListNameservers returns no nameservers, as the zone is not served until
+// the export is applied: the records are checked by listing them instead.
+func (z *exportZone) ListNameservers() []string {
+	return nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestNewExportProvider(t *testing.T) {
+	testCases := []struct {
+		options exportOptions
+		format  string
+		err     error
+	}{
+		{exportOptions{Path: "/tmp"}, exportFormatZone, nil},
+		{exportOptions{Path: "/tmp", Format: "yaml"}, exportFormatYAML, nil},
+		{exportOptions{Path: "/tmp", Format: "json"}, "", errExportInvalidFormat},
+		{exportOptions{}, "", errExportInvalidOutput},
+		{exportOptions{Path: "/tmp", ConfigMap: "kube-system/dns"}, "", errExportInvalidOutput},
+		{exportOptions{ConfigMap: "dns"}, "", errInvalidConfigMapName},
+	}
+	for i, tc := range testCases {
+		p, err := newExportProvider(registratorOptions{Export: tc.options})
+		if err != tc.err {
+			t.Errorf("newExportProvider returned unexpected error for test case #%02d: %+v", i, err)
+			continue
+		}
+		if err == nil && p.(*exportProvider).format != tc.format {
+			t.Errorf("newExportProvider returned unexpected format for test case #%02d: %s", i, p.(*exportProvider).format)
+		}
+	}
+}
+
+func TestExportZone(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ingress53")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %+v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	options := registratorOptions{RecordTTL: 60, ClusterID: "one", Export: exportOptions{Path: dir}}
+	p, err := newExportProvider(options)
+	if err != nil {
+		t.Fatalf("newExportProvider returned unexpected error: %+v", err)
+	}
+	z, err := p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("exportProvider.NewZone returned unexpected error: %+v", err)
+	}
+
+	ips := dnsRecord{Hostname: "b.example.com", Type: "A", Values: []string{"10.0.0.2", "10.0.0.1"}}
+	records := []dnsRecord{ips, newCnameRecord("a.example.com", testPrivateTarget), ownerRecord(ips, "one")}
+	if err := z.UpsertRecords(context.Background(), records); err != nil {
+		t.Fatalf("exportZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	expected := `; generated by ingress53, manual changes will be overwritten
+$ORIGIN example.com.
+; heritage=ingress53,cluster=one
+_ingress53.b.example.com.	60	IN	TXT	"heritage=ingress53,cluster=one"
+; heritage=ingress53,cluster=one
+a.example.com.	60	IN	CNAME	` + testPrivateTarget + `.
+; heritage=ingress53,cluster=one
+b.example.com.	60	IN	A	10.0.0.1
+b.example.com.	60	IN	A	10.0.0.2
+`
+	data, err := ioutil.ReadFile(filepath.Join(dir, "example.com.zone"))
+	if err != nil || string(data) != expected {
+		t.Errorf("exportZone.UpsertRecords wrote unexpected zone file: %s, %+v", data, err)
+	}
+
+	if err := z.DeleteRecords(context.Background(), records[1:2]); err != nil {
+		t.Fatalf("exportZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+
+	// the records are loaded back from the zone file
+	p, err = newExportProvider(options)
+	if err != nil {
+		t.Fatalf("newExportProvider returned unexpected error: %+v", err)
+	}
+	z, err = p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("exportProvider.NewZone returned unexpected error: %+v", err)
+	}
+	if records, _ := z.(recordLister).LookupRecords("a.example.com"); len(records) != 0 {
+		t.Errorf("exportZone.LookupRecords returned a deleted record: %+v", records)
+	}
+	expectedRecords := []zoneRecord{{Name: "b.example.com.", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}}}
+	if records, _ := z.(recordLister).LookupRecords("B.example.com"); !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("exportZone.LookupRecords returned unexpected records: %+v", records)
+	}
+}
+
+func TestExportZone_configMap(t *testing.T) {
+	client := fake.NewSimpleClientset(&corev1.ConfigMap{
+		ObjectMeta: v1.ObjectMeta{Name: "dns", Namespace: "kube-system"},
+		Data:       map[string]string{"other": "data"},
+	})
+	options := registratorOptions{RecordTTL: 60, KubernetesClient: client, Export: exportOptions{ConfigMap: "kube-system/dns", Format: exportFormatYAML}}
+	dp, err := newExportProvider(options)
+	if err != nil {
+		t.Fatalf("newExportProvider returned unexpected error: %+v", err)
+	}
+	p := dp.(*exportProvider)
+	z, err := p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("exportProvider.NewZone returned unexpected error: %+v", err)
+	}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget)}); err != nil {
+		t.Fatalf("exportZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	expected := `comment: generated by ingress53, manual changes will be overwritten
+records:
+- name: a.example.com.
+  owner: heritage=ingress53
+  ttl: 60
+  type: CNAME
+  values:
+  - ` + testPrivateTarget + `
+zone: example.com.
+`
+	cm, err := client.CoreV1().ConfigMaps("kube-system").Get("dns", v1.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not get configmap: %+v", err)
+	}
+	if cm.Data["example.com.yaml"] != expected || cm.Data["other"] != "data" {
+		t.Errorf("exportZone.UpsertRecords wrote unexpected configmap data: %+v", cm.Data)
+	}
+
+	// the records are loaded back from the configmap
+	p = &exportProvider{zones: map[string]*exportZone{}, format: exportFormatYAML, client: client, namespace: "kube-system", name: "dns"}
+	z, err = p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("exportProvider.NewZone returned unexpected error: %+v", err)
+	}
+	expectedRecords := []zoneRecord{{Name: "a.example.com.", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}}}
+	if records, _ := z.(recordLister).LookupRecords("a.example.com"); !reflect.DeepEqual(records, expectedRecords) {
+		t.Errorf("exportZone.LookupRecords returned unexpected records: %+v", records)
+	}
+}
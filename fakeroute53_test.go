@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+)
+
+const (
+	// route53 rejects change batches with more resource records than this,
+	// counting those of upserts twice
+	fakeRoute53MaxBatchRecords = 1000
+	fakeRoute53ThrottlingCode  = "Throttling"
+)
+
+// fakeRoute53 is an in memory route53 api that keeps the state of its zones
+// and health checks. Change batches are applied like route53 does: all or
+// nothing, failing if a record to create already exists, a record to delete
+// does not match, or the batch is too large. Changes are PENDING until their
+// status has been checked pendingPolls times, and the next throttle calls
+// fail with a throttling error.
+type fakeRoute53 struct {
+	route53iface.Route53API
+	mu           sync.Mutex
+	zones        map[string]*fakeHostedZone
+	changes      map[string]int // polls left until the change is in sync
+	healthChecks map[string]*route53.HealthCheck
+	lastID       int
+	pendingPolls int
+	throttle     int
+	pageSize     int
+}
+
+type fakeHostedZone struct {
+	zone        *route53.HostedZone
+	nameservers []*string
+	records     map[fakeRecordKey]*route53.ResourceRecordSet
+}
+
+type fakeRecordKey struct {
+	name          string
+	rrType        string
+	setIdentifier string
+}
+
+func newFakeRoute53() *fakeRoute53 {
+	return &fakeRoute53{
+		zones:        map[string]*fakeHostedZone{},
+		changes:      map[string]int{},
+		healthChecks: map[string]*route53.HealthCheck{},
+		pageSize:     100,
+	}
+}
+
+// addZone creates a zone with its NS and SOA records, and returns its id.
+func (f *fakeRoute53) addZone(name string, private bool) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := "/hostedzone/" + f.newID("Z")
+	name = fakeRecordName(name)
+	z := &fakeHostedZone{
+		zone: &route53.HostedZone{
+			Id:              aws.String(id),
+			Name:            aws.String(name),
+			CallerReference: aws.String(id),
+			Config:          &route53.HostedZoneConfig{PrivateZone: aws.Bool(private)},
+		},
+		records: map[fakeRecordKey]*route53.ResourceRecordSet{},
+	}
+	ns := []*route53.ResourceRecord{}
+	for i := 0; i < 4; i++ {
+		n := fmt.Sprintf("ns-%d.awsdns-%02d.net.", i, i)
+		z.nameservers = append(z.nameservers, aws.String(n))
+		ns = append(ns, &route53.ResourceRecord{Value: aws.String(n)})
+	}
+	for _, rrs := range []*route53.ResourceRecordSet{
+		{Name: aws.String(name), Type: aws.String(route53.RRTypeNs), TTL: aws.Int64(172800), ResourceRecords: ns},
+		{Name: aws.String(name), Type: aws.String(route53.RRTypeSoa), TTL: aws.Int64(900), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("ns-0.awsdns-00.net. awsdns-hostmaster.amazon.com. 1 7200 900 1209600 86400")}}},
+	} {
+		z.records[fakeKey(rrs)] = rrs
+	}
+	f.zones[id] = z
+	return id
+}
+
+// recordSets returns the record sets of the zone, in the order they are
+// listed.
+func (f *fakeRoute53) recordSets(id string) []*route53.ResourceRecordSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	z, ok := f.zones[fakeZoneID(id)]
+	if !ok {
+		return nil
+	}
+	return z.sorted()
+}
+
+// recordSet returns the record set with the name, type and set identifier,
+// or nil.
+func (f *fakeRoute53) recordSet(id string, name string, rrType string, setIdentifier string) *route53.ResourceRecordSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	z, ok := f.zones[fakeZoneID(id)]
+	if !ok {
+		return nil
+	}
+	return z.records[fakeRecordKey{fakeRecordName(name), rrType, setIdentifier}]
+}
+
+func (f *fakeRoute53) newID(prefix string) string {
+	f.lastID++
+	return fmt.Sprintf("%s%013d", prefix, f.lastID)
+}
+
+func (f *fakeRoute53) throttled() error {
+	if f.throttle == 0 {
+		return nil
+	}
+	f.throttle--
+	return awserr.NewRequestFailure(awserr.New(fakeRoute53ThrottlingCode, "Rate exceeded", nil), 400, "")
+}
+
+func (f *fakeRoute53) GetHostedZoneWithContext(ctx aws.Context, in *route53.GetHostedZoneInput, opts ...request.Option) (*route53.GetHostedZoneOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	z, ok := f.zones[fakeZoneID(aws.StringValue(in.Id))]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+aws.StringValue(in.Id), nil)
+	}
+	out := &route53.GetHostedZoneOutput{HostedZone: awsutil.CopyOf(z.zone).(*route53.HostedZone)}
+	if !aws.BoolValue(z.zone.Config.PrivateZone) {
+		out.DelegationSet = &route53.DelegationSet{NameServers: z.nameservers}
+	}
+	return out, nil
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSetsWithContext(ctx aws.Context, in *route53.ChangeResourceRecordSetsInput, opts ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	z, ok := f.zones[fakeZoneID(aws.StringValue(in.HostedZoneId))]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+aws.StringValue(in.HostedZoneId), nil)
+	}
+	count := 0
+	for _, c := range in.ChangeBatch.Changes {
+		n := len(c.ResourceRecordSet.ResourceRecords)
+		if aws.StringValue(c.Action) == route53.ChangeActionUpsert {
+			n *= 2
+		}
+		count += n
+	}
+	if len(in.ChangeBatch.Changes) > fakeRoute53MaxBatchRecords || count > fakeRoute53MaxBatchRecords {
+		return nil, fakeInvalidChangeBatch("Number of records limit of %d exceeded", fakeRoute53MaxBatchRecords)
+	}
+	// the changes are applied to a copy, which replaces the records only if
+	// all of them are valid
+	records := map[fakeRecordKey]*route53.ResourceRecordSet{}
+	for k, v := range z.records {
+		records[k] = v
+	}
+	for _, c := range in.ChangeBatch.Changes {
+		rrs := awsutil.CopyOf(c.ResourceRecordSet).(*route53.ResourceRecordSet)
+		rrs.Name = aws.String(fakeRecordName(aws.StringValue(rrs.Name)))
+		name, rrType := aws.StringValue(rrs.Name), aws.StringValue(rrs.Type)
+		if name != aws.StringValue(z.zone.Name) && !strings.HasSuffix(name, "."+aws.StringValue(z.zone.Name)) {
+			return nil, fakeInvalidChangeBatch("RRSet with DNS name %s is not permitted in zone %s", name, aws.StringValue(z.zone.Name))
+		}
+		if len(rrs.ResourceRecords) == 0 && rrs.AliasTarget == nil {
+			return nil, fakeInvalidChangeBatch("RRSet of type %s with DNS name %s has no resource records", rrType, name)
+		}
+		k := fakeKey(rrs)
+		existing, exists := records[k]
+		switch aws.StringValue(c.Action) {
+		case route53.ChangeActionCreate:
+			if exists {
+				return nil, fakeInvalidChangeBatch("Tried to create resource record set [name='%s', type='%s'] but it already exists", name, rrType)
+			}
+			fallthrough
+		case route53.ChangeActionUpsert:
+			for other := range records {
+				if other.name == name && other != k && (other.rrType == route53.RRTypeCname) != (rrType == route53.RRTypeCname) {
+					return nil, fakeInvalidChangeBatch("RRSet of type %s with DNS name %s is not permitted as it conflicts with other records with the same DNS name in zone %s", rrType, name, aws.StringValue(z.zone.Name))
+				}
+			}
+			if id := aws.StringValue(rrs.HealthCheckId); id != "" {
+				if _, ok := f.healthChecks[id]; !ok {
+					return nil, fakeInvalidChangeBatch("Health check %s does not exist", id)
+				}
+			}
+			records[k] = rrs
+		case route53.ChangeActionDelete:
+			if !exists {
+				return nil, fakeInvalidChangeBatch("Tried to delete resource record set [name='%s', type='%s'] but it was not found", name, rrType)
+			}
+			if !fakeRecordSetsMatch(existing, rrs) {
+				return nil, fakeInvalidChangeBatch("Tried to delete resource record set [name='%s', type='%s'] but the values provided do not match the current values", name, rrType)
+			}
+			delete(records, k)
+		default:
+			return nil, awserr.New("InvalidInput", "Invalid action "+aws.StringValue(c.Action), nil)
+		}
+	}
+	z.records = records
+	id := "/change/" + f.newID("C")
+	f.changes[id] = f.pendingPolls
+	return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &route53.ChangeInfo{
+		Id:          aws.String(id),
+		Status:      aws.String(route53.ChangeStatusPending),
+		SubmittedAt: aws.Time(time.Now()),
+		Comment:     in.ChangeBatch.Comment,
+	}}, nil
+}
+
+func (f *fakeRoute53) GetChangeWithContext(ctx aws.Context, in *route53.GetChangeInput, opts ...request.Option) (*route53.GetChangeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	id := aws.StringValue(in.Id)
+	if !strings.HasPrefix(id, "/change/") {
+		id = "/change/" + id
+	}
+	polls, ok := f.changes[id]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchChange, "A change with the specified change ID does not exist", nil)
+	}
+	status := route53.ChangeStatusInsync
+	if polls > 0 {
+		f.changes[id] = polls - 1
+		status = route53.ChangeStatusPending
+	}
+	return &route53.GetChangeOutput{ChangeInfo: &route53.ChangeInfo{Id: aws.String(id), Status: aws.String(status)}}, nil
+}
+
+func (f *fakeRoute53) ListResourceRecordSetsPagesWithContext(ctx aws.Context, in *route53.ListResourceRecordSetsInput, fn func(*route53.ListResourceRecordSetsOutput, bool) bool, opts ...request.Option) error {
+	f.mu.Lock()
+	if err := f.throttled(); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	z, ok := f.zones[fakeZoneID(aws.StringValue(in.HostedZoneId))]
+	if !ok {
+		f.mu.Unlock()
+		return awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+aws.StringValue(in.HostedZoneId), nil)
+	}
+	records := z.sorted()
+	f.mu.Unlock()
+	for start := 0; start < len(records) || start == 0; start += f.pageSize {
+		end := start + f.pageSize
+		if end > len(records) {
+			end = len(records)
+		}
+		page := &route53.ListResourceRecordSetsOutput{ResourceRecordSets: records[start:end], IsTruncated: aws.Bool(end < len(records))}
+		if end < len(records) {
+			page.NextRecordName = records[end].Name
+			page.NextRecordType = records[end].Type
+		}
+		if !fn(page, end == len(records)) {
+			break
+		}
+	}
+	return nil
+}
+
+// ListResourceRecordSetsWithContext returns the record sets from the start
+// name, type and set identifier on, in the order they are listed.
+func (f *fakeRoute53) ListResourceRecordSetsWithContext(ctx aws.Context, in *route53.ListResourceRecordSetsInput, opts ...request.Option) (*route53.ListResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	z, ok := f.zones[fakeZoneID(aws.StringValue(in.HostedZoneId))]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: "+aws.StringValue(in.HostedZoneId), nil)
+	}
+	start := fakeRecordKey{fakeRecordName(aws.StringValue(in.StartRecordName)), aws.StringValue(in.StartRecordType), aws.StringValue(in.StartRecordIdentifier)}
+	max := f.pageSize
+	if in.MaxItems != nil {
+		fmt.Sscan(aws.StringValue(in.MaxItems), &max)
+	}
+	out := &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []*route53.ResourceRecordSet{}, IsTruncated: aws.Bool(false)}
+	for _, rrs := range z.sorted() {
+		if fakeKeyLess(fakeKey(rrs), start) {
+			continue
+		}
+		if len(out.ResourceRecordSets) == max {
+			out.IsTruncated = aws.Bool(true)
+			out.NextRecordName, out.NextRecordType, out.NextRecordIdentifier = rrs.Name, rrs.Type, rrs.SetIdentifier
+			break
+		}
+		out.ResourceRecordSets = append(out.ResourceRecordSets, rrs)
+	}
+	return out, nil
+}
+
+func (f *fakeRoute53) ListHealthChecksPagesWithContext(ctx aws.Context, in *route53.ListHealthChecksInput, fn func(*route53.ListHealthChecksOutput, bool) bool, opts ...request.Option) error {
+	f.mu.Lock()
+	if err := f.throttled(); err != nil {
+		f.mu.Unlock()
+		return err
+	}
+	checks := []*route53.HealthCheck{}
+	for _, hc := range f.healthChecks {
+		checks = append(checks, awsutil.CopyOf(hc).(*route53.HealthCheck))
+	}
+	f.mu.Unlock()
+	sort.Slice(checks, func(i, j int) bool { return aws.StringValue(checks[i].Id) < aws.StringValue(checks[j].Id) })
+	fn(&route53.ListHealthChecksOutput{HealthChecks: checks}, true)
+	return nil
+}
+
+func (f *fakeRoute53) CreateHealthCheckWithContext(ctx aws.Context, in *route53.CreateHealthCheckInput, opts ...request.Option) (*route53.CreateHealthCheckOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	for _, hc := range f.healthChecks {
+		if aws.StringValue(hc.CallerReference) == aws.StringValue(in.CallerReference) {
+			return nil, awserr.New(route53.ErrCodeHealthCheckAlreadyExists, "A health check with the same caller reference already exists", nil)
+		}
+	}
+	hc := &route53.HealthCheck{
+		Id:                aws.String(f.newID("hc-")),
+		CallerReference:   in.CallerReference,
+		HealthCheckConfig: awsutil.CopyOf(in.HealthCheckConfig).(*route53.HealthCheckConfig),
+	}
+	f.healthChecks[*hc.Id] = hc
+	return &route53.CreateHealthCheckOutput{HealthCheck: hc}, nil
+}
+
+func (f *fakeRoute53) DeleteHealthCheckWithContext(ctx aws.Context, in *route53.DeleteHealthCheckInput, opts ...request.Option) (*route53.DeleteHealthCheckOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.throttled(); err != nil {
+		return nil, err
+	}
+	id := aws.StringValue(in.HealthCheckId)
+	if _, ok := f.healthChecks[id]; !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHealthCheck, "No health check exists with the specified ID "+id, nil)
+	}
+	for _, z := range f.zones {
+		for _, rrs := range z.records {
+			if aws.StringValue(rrs.HealthCheckId) == id {
+				return nil, awserr.New(route53.ErrCodeHealthCheckInUse, "The health check "+id+" is still referenced from a resource record set", nil)
+			}
+		}
+	}
+	delete(f.healthChecks, id)
+	return &route53.DeleteHealthCheckOutput{}, nil
+}
+
+func (z *fakeHostedZone) sorted() []*route53.ResourceRecordSet {
+	keys := make([]fakeRecordKey, 0, len(z.records))
+	for k := range z.records {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fakeKeyLess(keys[i], keys[j]) })
+	ret := make([]*route53.ResourceRecordSet, len(keys))
+	for i, k := range keys {
+		ret[i] = awsutil.CopyOf(z.records[k]).(*route53.ResourceRecordSet)
+	}
+	return ret
+}
+
+// fakeRecordSetsWithout returns the record sets that are not of the types,
+// eg. to leave out the NS and SOA records of the zone.
+func fakeRecordSetsWithout(records []*route53.ResourceRecordSet, types ...string) []*route53.ResourceRecordSet {
+	ret := []*route53.ResourceRecordSet{}
+	for _, rrs := range records {
+		if !stringInSlice(aws.StringValue(rrs.Type), types) {
+			ret = append(ret, rrs)
+		}
+	}
+	return ret
+}
+
+// fakeErrorCode returns the code of an aws error, or an empty string.
+func fakeErrorCode(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return ""
+}
+
+func fakeInvalidChangeBatch(format string, args ...interface{}) error {
+	return awserr.New(route53.ErrCodeInvalidChangeBatch, fmt.Sprintf(format, args...), nil)
+}
+
+// fakeZoneID returns the id with the prefix route53 adds, as ids are accepted
+// with or without it.
+func fakeZoneID(id string) string {
+	if strings.HasPrefix(id, "/hostedzone/") {
+		return id
+	}
+	return "/hostedzone/" + id
+}
+
+// fakeRecordName returns the name as route53 stores it: lowercase, fully
+// qualified, with the wildcard escaped.
+func fakeRecordName(name string) string {
+	return strings.Replace(strings.ToLower(strings.TrimSuffix(name, "."))+".", "*", "\\052", -1)
+}
+
+func fakeKey(rrs *route53.ResourceRecordSet) fakeRecordKey {
+	return fakeRecordKey{aws.StringValue(rrs.Name), aws.StringValue(rrs.Type), aws.StringValue(rrs.SetIdentifier)}
+}
+
+// fakeKeyLess returns true if the record set with the key a is listed before
+// the one with the key b.
+func fakeKeyLess(a, b fakeRecordKey) bool {
+	if a.name != b.name {
+		return a.name < b.name
+	}
+	if a.rrType != b.rrType {
+		return a.rrType < b.rrType
+	}
+	return a.setIdentifier < b.setIdentifier
+}
+
+// fakeRecordSetsMatch returns true if a record set to delete matches the
+// existing one, which route53 requires of all the fields but the order of the
+// values.
+func fakeRecordSetsMatch(existing *route53.ResourceRecordSet, rrs *route53.ResourceRecordSet) bool {
+	values := func(s *route53.ResourceRecordSet) []string {
+		ret := []string{}
+		for _, rr := range s.ResourceRecords {
+			ret = append(ret, strings.ToLower(strings.TrimSuffix(aws.StringValue(rr.Value), ".")))
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	a, b := *existing, *rrs
+	a.ResourceRecords, b.ResourceRecords = nil, nil
+	return awsutil.DeepEqual(&a, &b) && strings.Join(values(existing), " ") == strings.Join(values(rrs), " ")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// Managed health checks are identified by their caller reference, which is
+// made of this prefix, a hash of the record, a hash of the health check
+// configuration and a timestamp, as caller references cannot be reused.
+const healthCheckReferencePrefix = "ingress53-"
+
+var defaultHealthCheckPort int64 = 80
+
+func shortHash(s string) string {
+	return fmt.Sprintf("%x", sha1.Sum([]byte(s)))[:16]
+}
+
+// healthCheckConfig returns the configuration of the managed health check of
+// the record, against its first value.
+func healthCheckConfig(record dnsRecord) *route53.HealthCheckConfig {
+	c := &route53.HealthCheckConfig{
+		Port: aws.Int64(defaultHealthCheckPort),
+		Type: aws.String(route53.HealthCheckTypeTcp),
+	}
+	if record.Routing.HealthCheckPath != "" {
+		c.Type = aws.String(route53.HealthCheckTypeHttp)
+		c.ResourcePath = aws.String(record.Routing.HealthCheckPath)
+	}
+	target := strings.Trim(record.Values[0], ".")
+	if ip := net.ParseIP(target); ip != nil {
+		c.IPAddress = aws.String(ip.String())
+	} else {
+		c.FullyQualifiedDomainName = aws.String(target)
+	}
+	return c
+}
+
+// healthCheckReferences returns the caller reference prefixes of the managed
+// health checks of the record, and of the one with the current configuration.
+func healthCheckReferences(record dnsRecord, c *route53.HealthCheckConfig) (string, string) {
+	recordRef := fmt.Sprintf("%s%s-", healthCheckReferencePrefix, shortHash(fmt.Sprintf("%s/%s/%s", normalizeRecordName(record.Hostname), record.Type, record.Routing.SetIdentifier)))
+	configRef := fmt.Sprintf("%s%s-", recordRef, shortHash(c.String()))
+	return recordRef, configRef
+}
+
+func isManagedHealthCheck(record dnsRecord) bool {
+	return record.Routing.Failover != "" && record.Routing.HealthCheckID == "" && len(record.Values) > 0
+}
+
+// managedHealthChecks lists the health checks created by ingress53.
+func (z *route53Zone) managedHealthChecks(ctx context.Context) ([]*route53.HealthCheck, error) {
+	ret := []*route53.HealthCheck{}
+	err := z.api.ListHealthChecksPagesWithContext(ctx, &route53.ListHealthChecksInput{}, func(page *route53.ListHealthChecksOutput, lastPage bool) bool {
+		for _, hc := range page.HealthChecks {
+			if strings.HasPrefix(aws.StringValue(hc.CallerReference), healthCheckReferencePrefix) {
+				ret = append(ret, hc)
+			}
+		}
+		return true
+	})
+	return ret, err
+}
+
+// resolveHealthChecks sets the ids of the managed health checks of the
+// records, creating the missing ones unless the records are being deleted.
+// It also returns the ids of the managed health checks that will no longer be
+// used once the change is applied: those of the deleted records, and those
+// with a previous configuration of the upserted ones, and the ids of the
+// health checks it created, which need to be deleted if the change fails.
+func (z *route53Zone) resolveHealthChecks(ctx context.Context, action string, records []dnsRecord) ([]dnsRecord, []string, []string, error) {
+	ret := make([]dnsRecord, len(records))
+	copy(ret, records)
+	obsolete := []string{}
+	created := []string{}
+	var existing []*route53.HealthCheck
+	for i, r := range ret {
+		if !isManagedHealthCheck(r) {
+			continue
+		}
+		if existing == nil {
+			var err error
+			if existing, err = z.managedHealthChecks(ctx); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		c := healthCheckConfig(r)
+		recordRef, configRef := healthCheckReferences(r, c)
+		id := ""
+		for _, hc := range existing {
+			ref := aws.StringValue(hc.CallerReference)
+			if strings.HasPrefix(ref, configRef) && id == "" {
+				id = aws.StringValue(hc.Id)
+			} else if strings.HasPrefix(ref, recordRef) {
+				obsolete = append(obsolete, aws.StringValue(hc.Id))
+			}
+		}
+		if action == route53.ChangeActionDelete {
+			if id != "" {
+				obsolete = append(obsolete, id)
+			} else {
+				// the record to delete has to match the current one, whose
+				// health check may have a previous configuration
+				var err error
+				if id, err = z.currentHealthCheckID(ctx, r); err != nil {
+					return nil, nil, nil, err
+				}
+			}
+		} else if id == "" {
+			resp, err := z.api.CreateHealthCheckWithContext(ctx, &route53.CreateHealthCheckInput{
+				CallerReference:   aws.String(configRef + strconv.FormatInt(time.Now().UnixNano(), 36)),
+				HealthCheckConfig: c,
+			})
+			if err != nil {
+				z.deleteHealthChecks(ctx, created)
+				return nil, nil, nil, err
+			}
+			id = aws.StringValue(resp.HealthCheck.Id)
+			existing = append(existing, resp.HealthCheck)
+			created = append(created, id)
+			log.Printf("[INFO] created health check %s for %s", id, r.Hostname)
+		}
+		ret[i].Routing.HealthCheckID = id
+	}
+	return ret, obsolete, created, nil
+}
+
+// currentHealthCheckID returns the id of the health check of the record, or
+// an empty string. It's taken from the records listed last, or looked up in
+// route53 if the records of the zone are not listed.
+func (z *route53Zone) currentHealthCheckID(ctx context.Context, record dnsRecord) (string, error) {
+	records, err := z.records.Lookup(record.Hostname)
+	if err == errRecordCacheNotLoaded {
+		rrs, err := z.lookupRecordSet(ctx, record)
+		if err != nil || rrs == nil {
+			return "", err
+		}
+		return aws.StringValue(rrs.HealthCheckId), nil
+	}
+	for _, r := range records {
+		if r.Type == record.Type && r.Routing.SetIdentifier == record.Routing.SetIdentifier {
+			return r.Routing.HealthCheckID, nil
+		}
+	}
+	return "", nil
+}
+
+// deleteHealthChecks deletes the health checks, logging any errors.
+func (z *route53Zone) deleteHealthChecks(ctx context.Context, ids []string) {
+	for _, id := range ids {
+		if _, err := z.api.DeleteHealthCheckWithContext(ctx, &route53.DeleteHealthCheckInput{HealthCheckId: aws.String(id)}); err != nil {
+			log.Printf("[ERROR] could not delete health check %s: %+v", id, err)
+			continue
+		}
+		log.Printf("[INFO] deleted health check %s", id)
+	}
+}
+
+// healthCheckPaths returns the paths of the managed health checks, by id.
+func healthCheckPaths(checks []*route53.HealthCheck) map[string]string {
+	ret := map[string]string{}
+	for _, hc := range checks {
+		if hc.HealthCheckConfig != nil {
+			ret[aws.StringValue(hc.Id)] = aws.StringValue(hc.HealthCheckConfig.ResourcePath)
+		}
+	}
+	return ret
+}
@@ -67,16 +67,39 @@ func (iw *ingressWatcher) Stop() {
 	close(iw.stopChannel)
 }
 
+// List returns the ingresses matching the label selector straight from the
+// kubernetes api, without going through the informer.
+func (iw *ingressWatcher) List() ([]*v1beta1.Ingress, error) {
+	list, err := iw.client.Extensions().Ingresses(v1.NamespaceAll).List(v1.ListOptions{LabelSelector: iw.labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*v1beta1.Ingress, len(list.Items))
+	for i := range list.Items {
+		ret[i] = &list.Items[i]
+	}
+	return ret, nil
+}
+
 func (iw *ingressWatcher) HostnameOwners(hostname string) []string {
 	owners := []string{}
+	for _, i := range iw.HostnameIngresses(hostname) {
+		owners = append(owners, i.Name)
+	}
+	return owners
+}
+
+// HostnameIngresses returns the known ingresses that claim the hostname.
+func (iw *ingressWatcher) HostnameIngresses(hostname string) []*v1beta1.Ingress {
+	ingresses := []*v1beta1.Ingress{}
 	for _, i := range iw.store.List() {
 		for _, h := range getHostnamesFromIngress(i.(*v1beta1.Ingress)) {
 			if hostname == h {
-				owners = append(owners, i.(*v1beta1.Ingress).Name)
+				ingresses = append(ingresses, i.(*v1beta1.Ingress))
 			}
 		}
 	}
-	return owners
+	return ingresses
 }
 
 func getHostnamesFromIngress(ingress *v1beta1.Ingress) []string {
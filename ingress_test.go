@@ -136,6 +136,21 @@ var (
 		},
 	}
 
+	aliasIngressHostF = &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "aliasIngressHostF",
+			Namespace: v1.NamespaceDefault,
+			Labels: map[string]string{
+				testTargetLabelName: testAlias,
+			},
+		},
+		Spec: v1beta1.IngressSpec{
+			Rules: []v1beta1.IngressRule{
+				{Host: "f.example.com"},
+			},
+		},
+	}
+
 	ingressNoLabels = &v1beta1.Ingress{
 		ObjectMeta: v1.ObjectMeta{
 			Name:      "ingressNoLabels",
@@ -302,3 +317,17 @@ func TestIngressWatcher(t *testing.T) {
 	}
 	pM.Unlock()
 }
+
+func TestIngressWatcher_List(t *testing.T) {
+	client, _ := newTestIngressWatcherClient(*privateIngressHostsAB, *publicIngressHostC)
+	iw := newIngressWatcher(client, nil, "", 0)
+
+	ingresses, err := iw.List()
+	if err != nil {
+		t.Fatalf("ingressWatcher.List returned unexpected error: %+v", err)
+	}
+	expected := []*v1beta1.Ingress{privateIngressHostsAB, publicIngressHostC}
+	if !reflect.DeepEqual(ingresses, expected) {
+		t.Errorf("ingressWatcher.List returned unexpected results: %+v != %+v", ingresses, expected)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -8,6 +9,8 @@ import (
 	"os"
 	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/logutils"
 	"github.com/prometheus/client_golang/prometheus"
@@ -37,19 +40,77 @@ func (s *strslice) Set(value string) error {
 	return nil
 }
 
+// strlist is a list of strings that takes each value of the flag whole, for
+// values that may contain commas.
+type strlist []string
+
+func (s *strlist) String() string {
+	return fmt.Sprint(*s)
+}
+
+func (s *strlist) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 var (
 	appGitHash = "master"
 
+	httpShutdownTimeout = 5 * time.Second
+
 	// Define a flag to accumulate durations. Because it has a special type,
 	// we need to use the Var function and therefore create the flag during
 	// init.
-	targets strslice
+	targets            strslice
+	resolvers          strslice
+	privateTargets     strslice
+	privateResolvers   strslice
+	builtinNameservers strslice
+	builtinSecondaries strslice
+	etcdEndpoints      strslice
+	webhookHeaders     strlist
 
+	configFile      = flag.String("config", "", "path to a YAML/JSON configuration file, flags override its values; reloaded on SIGHUP or when the file changes")
 	kubeConfig      = flag.String("kubernetes-config", "", "path to the kubeconfig file, if unspecified then in-cluster config will be used")
+	targetsMap      = flag.String("targets-configmap", "", "namespace/name of a configmap that maps target aliases (label values) to targets")
 	targetLabelName = flag.String("target-label", "ingress53.target", "Kubernetes key of the label that specifies the target type")
-	r53ZoneID       = flag.String("route53-zone-id", "", "route53 hosted DNS zone id")
+	provider        = flag.String("provider", providerRoute53, "dns provider that hosts the zones: route53, rfc2136, builtin, etcd, export or webhook")
+	zoneID          = flag.String("zone-id", "", "id of the dns zone in the provider, eg. the route53 hosted zone id")
+	r53ZoneID       = flag.String("route53-zone-id", "", "route53 hosted DNS zone id (deprecated, use -zone-id)")
+	rfc2136Server   = flag.String("rfc2136-server", "", "host[:port] of the nameserver that accepts dynamic updates, for the rfc2136 provider")
+	rfc2136KeyName  = flag.String("rfc2136-tsig-key-name", "", "name of the TSIG key used to sign the messages to the rfc2136 server")
+	rfc2136Secret   = flag.String("rfc2136-tsig-secret", "", "base64 encoded secret of the TSIG key")
+	rfc2136Algo     = flag.String("rfc2136-tsig-algorithm", defaultRFC2136TSIGAlgorithm, "algorithm of the TSIG key: hmac-md5, hmac-sha1, hmac-sha256 or hmac-sha512")
+	builtinListen   = flag.String("builtin-listen", defaultBuiltinListen, "address the builtin provider serves the zones on")
+	builtinDataDir  = flag.String("builtin-data-dir", defaultBuiltinDataDir, "directory the builtin provider writes the zone files to")
+	etcdPrefix      = flag.String("etcd-prefix", defaultEtcdPrefix, "etcd path the etcd provider writes the records under, as set in the CoreDNS etcd plugin")
+	etcdLeaseTTL    = flag.Duration("etcd-lease-ttl", 0, "how long the records written by the etcd provider are kept once ingress53 stops renewing their lease, eg. while etcd is unreachable (defaults to -record-ttl)")
+	exportFormat    = flag.String("export-format", exportFormatZone, "format of the files written by the export provider: zone or yaml")
+	exportPath      = flag.String("export-path", "", "directory the export provider writes the zone files to")
+	exportConfigMap = flag.String("export-configmap", "", "namespace/name of a configmap the export provider writes the zone files to, instead of a directory")
+	webhookURL      = flag.String("webhook-url", "", "url the webhook provider posts the change sets to")
+	webhookListURL  = flag.String("webhook-list-url", "", "url the webhook provider lists the records of the zones from")
+	webhookRetries  = flag.Int("webhook-retries", defaultWebhookRetries, "how many times to retry a webhook request that failed, 0 disables retries")
+	awsRoleARN      = flag.String("aws-role-arn", "", "arn of an aws role to assume to manage the route53 zones, eg. in another account")
+	awsExternalID   = flag.String("aws-external-id", "", "external id passed when assuming the aws role")
+	awsSessionName  = flag.String("aws-session-name", defaultAWSSessionName, "session name used when assuming aws roles")
+	awsRegion       = flag.String("aws-region", "", "aws region, overrides the one of the environment")
+	r53Endpoint     = flag.String("route53-endpoint", "", "url of the route53 api, eg. of a local stand-in such as moto or localstack")
 	debugLogs       = flag.Bool("debug", false, "enables debug logs")
 	dryRun          = flag.Bool("dry-run", false, "if set, ingress53 will not make any Route53 changes")
+	recordTTL       = flag.Int64("record-ttl", defaultRoute53RecordTTL, "TTL of the records created by ingress53")
+	policy          = flag.String("policy", policySync, "record management policy: sync or upsert-only (records are never deleted)")
+	pruneSource     = flag.String("prune-source", pruneSourceDNS, "how to find out the current records when deciding which changes to skip: dns (query the zone nameservers) or provider (list the zone records periodically)")
+	recordsRefresh  = flag.Duration("route53-records-refresh", defaultRoute53RecordsRefreshInterval, "how often to list the zone records when the prune source is provider")
+	drainTimeout    = flag.Duration("drain-timeout", defaultDrainTimeout, "how long to wait for pending changes to be applied when shutting down")
+	verifyDNS       = flag.Bool("verify-propagation", false, "if set, ingress53 will query all the nameservers of the zone after a change is in sync, to verify that the records are served")
+	verifyTimeout   = flag.Duration("verify-timeout", defaultVerifyTimeout, "how long to wait for the records to be served by all the nameservers before upserting them again")
+	dnsTimeout      = flag.Duration("dns-timeout", defaultDNSTimeout, "timeout of the DNS queries used to check the records")
+	dnsRetries      = flag.Int("dns-retries", defaultDNSRetries, "how many times to retry a DNS query that failed")
+	dnsTCPFallback  = flag.Bool("dns-tcp-fallback", true, "retry DNS queries over TCP when the answer is truncated")
+	clusterID       = flag.String("cluster-id", "", "if set, ingress53 records the cluster as the owner of the records it manages in TXT records, and only updates or deletes the records this cluster owns")
+	adoptUnowned    = flag.Bool("adopt-unowned-records", false, "if set along with -cluster-id, existing records without an owner are taken over by this cluster instead of being left alone")
+	once            = flag.Bool("once", false, "if set, ingress53 will upsert the records of all ingresses once, wait for the changes to be applied and exit; stale records are not deleted")
 
 	metricUpdatesApplied = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
@@ -62,6 +123,54 @@ var (
 		[]string{"hostname", "action"},
 	)
 
+	metricChangePropagationSeconds = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Namespace: "ingress53",
+			Subsystem: "route53",
+			Name:      "change_propagation_seconds",
+			Help:      "time it took for route53 changes to be in sync",
+			Buckets:   []float64{5, 10, 20, 30, 45, 60, 90, 120, 300, 600},
+		},
+	)
+
+	metricChangesPending = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: "ingress53",
+			Subsystem: "route53",
+			Name:      "changes_pending",
+			Help:      "number of submitted route53 changes that are not in sync yet",
+		},
+	)
+
+	metricChangesTimedOut = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: "ingress53",
+			Subsystem: "route53",
+			Name:      "changes_timed_out",
+			Help:      "number of route53 changes that were not in sync within the expected time",
+		},
+	)
+
+	metricRecordVerifications = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ingress53",
+			Subsystem: "dns",
+			Name:      "record_verifications",
+			Help:      "number of record verifications against all the zone nameservers, by result",
+		},
+		[]string{"hostname", "result"},
+	)
+
+	metricDNSInconsistentAnswers = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "ingress53",
+			Subsystem: "dns",
+			Name:      "inconsistent_answers",
+			Help:      "number of lookups where the zone nameservers returned different answers",
+		},
+		[]string{"hostname"},
+	)
+
 	metricUpdatesReceived = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "ingress53",
@@ -99,8 +208,126 @@ func UpdateKubernetesIOErrorCount(err error) {
 	metricKubernetesIOError.Inc()
 }
 
+// loadOptions builds the registrator options from the configuration file, if
+// any, overriding its values with the flags that were explicitly set.
+func loadOptions() (registratorOptions, error) {
+	ro := registratorOptions{}
+	c := &config{}
+	var err error
+	if *configFile != "" {
+		if c, err = loadConfig(*configFile); err != nil {
+			return ro, err
+		}
+	}
+	if c.TargetLabelName == "" {
+		c.TargetLabelName = *targetLabelName
+	}
+	if c.RecordTTL == 0 {
+		c.RecordTTL = *recordTTL
+	}
+	if c.Policy == "" {
+		c.Policy = *policy
+	}
+	if c.PruneSource == "" {
+		c.PruneSource = *pruneSource
+	}
+	if c.RecordsRefresh == 0 {
+		c.RecordsRefresh = duration(*recordsRefresh)
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "target":
+			c.Targets = targets
+		case "targets-configmap":
+			c.TargetsConfigMap = *targetsMap
+		case "target-label":
+			c.TargetLabelName = *targetLabelName
+		case "provider":
+			c.Provider = *provider
+		case "zone-id":
+			c.Zones = []zoneConfig{{ID: *zoneID}}
+		case "route53-zone-id":
+			c.Zones = []zoneConfig{{ID: *r53ZoneID}}
+		case "rfc2136-server":
+			c.RFC2136.Server = *rfc2136Server
+		case "rfc2136-tsig-key-name":
+			c.RFC2136.TSIGKeyName = *rfc2136KeyName
+		case "rfc2136-tsig-secret":
+			c.RFC2136.TSIGSecret = *rfc2136Secret
+		case "rfc2136-tsig-algorithm":
+			c.RFC2136.TSIGAlgorithm = *rfc2136Algo
+		case "builtin-listen":
+			c.Builtin.Listen = *builtinListen
+		case "builtin-data-dir":
+			c.Builtin.DataDir = *builtinDataDir
+		case "builtin-nameserver":
+			c.Builtin.Nameservers = builtinNameservers
+		case "builtin-secondary":
+			c.Builtin.Secondaries = builtinSecondaries
+		case "etcd-endpoint":
+			c.Etcd.Endpoints = etcdEndpoints
+		case "etcd-prefix":
+			c.Etcd.Prefix = *etcdPrefix
+		case "etcd-lease-ttl":
+			c.Etcd.LeaseTTL = duration(*etcdLeaseTTL)
+		case "export-format":
+			c.Export.Format = *exportFormat
+		case "export-path":
+			c.Export.Path = *exportPath
+		case "export-configmap":
+			c.Export.ConfigMap = *exportConfigMap
+		case "webhook-url":
+			c.Webhook.URL = *webhookURL
+		case "webhook-list-url":
+			c.Webhook.ListURL = *webhookListURL
+		case "webhook-header":
+			c.Webhook.Headers, err = parseWebhookHeaders(webhookHeaders)
+		case "webhook-retries":
+			c.Webhook.Retries = webhookRetries
+		case "record-ttl":
+			c.RecordTTL = *recordTTL
+		case "policy":
+			c.Policy = *policy
+		case "prune-source":
+			c.PruneSource = *pruneSource
+		case "route53-records-refresh":
+			c.RecordsRefresh = duration(*recordsRefresh)
+		case "dns-resolver":
+			c.Resolvers = resolvers
+		case "private-target":
+			c.PrivateTargets = privateTargets
+		case "private-dns-resolver":
+			c.PrivateResolvers = privateResolvers
+		case "cluster-id":
+			c.ClusterID = *clusterID
+		case "adopt-unowned-records":
+			c.AdoptUnowned = *adoptUnowned
+		case "aws-role-arn":
+			c.AWS.RoleARN = *awsRoleARN
+		case "aws-external-id":
+			c.AWS.ExternalID = *awsExternalID
+		case "aws-session-name":
+			c.AWS.SessionName = *awsSessionName
+		case "aws-region":
+			c.AWS.Region = *awsRegion
+		case "route53-endpoint":
+			c.AWS.Route53Endpoint = *r53Endpoint
+		}
+	})
+	if err != nil {
+		return ro, err
+	}
+	c.applyTo(&ro)
+	return ro, nil
+}
+
 func main() {
 	prometheus.MustRegister(metricUpdatesApplied)
+	prometheus.MustRegister(metricChangePropagationSeconds)
+	prometheus.MustRegister(metricChangesPending)
+	prometheus.MustRegister(metricChangesTimedOut)
+	prometheus.MustRegister(metricRecordVerifications)
+	prometheus.MustRegister(metricDNSInconsistentAnswers)
 	prometheus.MustRegister(metricUpdatesReceived)
 	prometheus.MustRegister(metricUpdatesRejected)
 	prometheus.MustRegister(metricKubernetesIOError)
@@ -109,6 +336,13 @@ func main() {
 	utilruntime.ErrorHandlers = append(utilruntime.ErrorHandlers, UpdateKubernetesIOErrorCount)
 
 	flag.Var(&targets, "target", "List of endpoints (ELB) targets to map ingress records to")
+	flag.Var(&resolvers, "dns-resolver", "List of nameservers (host[:port]) to check the records against, instead of the nameservers of the zone")
+	flag.Var(&privateTargets, "private-target", "List of targets whose records are only written to private zones, the records of other targets are written to both public and private zones")
+	flag.Var(&privateResolvers, "private-dns-resolver", "List of nameservers (host[:port]) to check the records of private zones against, eg. the VPC resolver; if unset the records are listed instead")
+	flag.Var(&builtinNameservers, "builtin-nameserver", "List of names for the NS records of the zones served by the builtin provider, defaults to ns.<zone>")
+	flag.Var(&webhookHeaders, "webhook-header", "Header (name: value) the webhook provider sends with every request, eg. for authentication; can be repeated")
+	flag.Var(&etcdEndpoints, "etcd-endpoint", "List of etcd endpoints the etcd provider writes the records to")
+	flag.Var(&builtinSecondaries, "builtin-secondary", "List of secondary nameservers (host[:port]) that are notified of changes and allowed to transfer the zones served by the builtin provider")
 	flag.Parse()
 
 	luf := &logutils.LevelFilter{
@@ -121,10 +355,12 @@ func main() {
 	}
 	log.SetOutput(luf)
 
-	ro := registratorOptions{
-		Targets:         targets,
-		TargetLabelName: *targetLabelName,
-		Route53ZoneID:   *r53ZoneID,
+	dnsResolver = newResolver(*dnsTimeout, *dnsRetries, *dnsTCPFallback)
+
+	ro, err := loadOptions()
+	if err != nil {
+		log.Printf("[ERROR] could not load configuration: %+v", err)
+		os.Exit(1)
 	}
 	if *kubeConfig != "" {
 		config, err := clientcmd.BuildConfigFromFlags("", *kubeConfig)
@@ -134,6 +370,9 @@ func main() {
 		}
 		ro.KubernetesConfig = config
 	}
+	ro.DrainTimeout = *drainTimeout
+	ro.VerifyPropagation = *verifyDNS
+	ro.VerifyTimeout = *verifyTimeout
 
 	r, err := newRegistratorWithOptions(ro)
 	if err != nil {
@@ -141,36 +380,79 @@ func main() {
 		os.Exit(1)
 	}
 
+	if *once {
+		s, err := r.SyncOnce()
+		log.Printf("[INFO] synced %d ingress(es): %d record(s) checked, %d record(s) changed", s.Ingresses, s.Records, s.Changed)
+		if err != nil {
+			log.Printf("[ERROR] sync failed: %+v", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	reload := func() {
+		ro, err := loadOptions()
+		if err != nil {
+			log.Printf("[ERROR] could not load configuration, keeping the current one: %+v", err)
+			return
+		}
+		if err := r.Reload(ro); err != nil {
+			log.Printf("[ERROR] could not reload configuration, keeping the current one: %+v", err)
+		}
+	}
+	reloadStop := make(chan struct{})
+	if *configFile != "" {
+		go watchConfigFile(*configFile, defaultConfigWatchInterval, reloadStop, reload)
+	}
+	hupChannel := make(chan os.Signal, 1)
+	signal.Notify(hupChannel, syscall.SIGHUP)
+	go func() {
+		for range hupChannel {
+			log.Println("[INFO] hangup signal: reloading configuration ...")
+			reload()
+		}
+	}()
+
 	sigChannel := make(chan os.Signal, 1)
-	signal.Notify(sigChannel, os.Interrupt)
+	signal.Notify(sigChannel, os.Interrupt, syscall.SIGTERM)
 	go func() {
-		<-sigChannel
-		log.Println("[INFO] interrupt singal: shutting down ...")
+		s := <-sigChannel
+		log.Printf("[INFO] %s signal: shutting down ...", s)
+		close(reloadStop)
 		r.Stop()
 	}()
 
+	mux := http.NewServeMux()
+	mux.Handle("/__/", op.NewHandler(
+		op.NewStatus("ingress53", "ingress53 updates Route53 DNS records based on the ingresses available on the kubernetes cluster it runs on").
+			AddOwner("infrastructure", "#infra").
+			AddLink("github", "https://github.com/utilitywarehouse/ingress53").
+			SetRevision(appGitHash).
+			AddChecker("running", func(cr *op.CheckResponse) { cr.Healthy("service is running") }).
+			ReadyAlways(),
+	))
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Addr: ":5000", Handler: mux}
+
 	go func() {
 		log.Printf("[INFO] starting HTTP endpoints ...")
-
-		mux := http.NewServeMux()
-		mux.Handle("/__/", op.NewHandler(
-			op.NewStatus("ingress53", "ingress53 updates Route53 DNS records based on the ingresses available on the kubernetes cluster it runs on").
-				AddOwner("infrastructure", "#infra").
-				AddLink("github", "https://github.com/utilitywarehouse/ingress53").
-				SetRevision(appGitHash).
-				AddChecker("running", func(cr *op.CheckResponse) { cr.Healthy("service is running") }).
-				ReadyAlways(),
-		))
-		mux.Handle("/metrics", promhttp.Handler())
-
-		if err := http.ListenAndServe(":5000", mux); err != nil {
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Printf("[ERROR] could not start HTTP router: %+v", err)
 			os.Exit(1)
 		}
 	}()
 
-	if err := r.Start(); err != nil {
+	err = r.Start()
+
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("[ERROR] could not shut down HTTP endpoints: %+v", err)
+	}
+	cancel()
+
+	if err != nil {
 		log.Printf("[ERROR] registrator returned an error: %+v", err)
 		os.Exit(1)
 	}
+	log.Println("[INFO] shut down cleanly")
 }
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const (
+	ownerRecordPrefix       = "_ingress53"
+	ownerHeritage           = "heritage=ingress53"
+	ownerClusterPrefix      = "cluster="
+	takeOwnershipAnnotation = "ingress53.take-ownership-from"
+)
+
+var (
+	errRegistratorInvalidClusterID = errors.New("cluster id can only contain letters, digits, dots, dashes and underscores")
+	errRegistratorClusterIDChanged = errors.New("cluster id cannot be changed without a restart")
+	validClusterID                 = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+)
+
+// ownerRecordName returns the name of the TXT record that holds the owner of
+// the record: one per hostname for simple records, and one per set identifier
+// for records with a routing policy, as those can be owned by different
+// clusters.
+func ownerRecordName(record dnsRecord) string {
+	hostname := strings.Trim(record.Hostname, ".")
+	if record.Routing.SetIdentifier == "" {
+		return ownerRecordPrefix + "." + hostname
+	}
+	return ownerRecordPrefix + "-" + shortHash(record.Routing.SetIdentifier) + "." + hostname
+}
+
+// ownerRecord returns the TXT record that marks the cluster as the owner of
+// the record.
+func ownerRecord(record dnsRecord, clusterID string) dnsRecord {
+	value := `"` + ownerHeritage + "," + ownerClusterPrefix + clusterID + `"`
+	return dnsRecord{Hostname: ownerRecordName(record), Type: route53.RRTypeTxt, Values: []string{value}}
+}
+
+// parseOwner returns the cluster id in the value of an owner record, and
+// false if the value was not written by ingress53.
+func parseOwner(value string) (string, bool) {
+	fields := strings.Split(strings.Trim(value, `"`), ",")
+	if len(fields) != 2 || fields[0] != ownerHeritage || !strings.HasPrefix(fields[1], ownerClusterPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(fields[1], ownerClusterPrefix), true
+}
+
+// recordOwner returns the id of the cluster that owns the record, or an empty
+// string if it has no owner.
+func recordOwner(z dnsZone, record dnsRecord, options registratorOptions) (string, error) {
+	values, err := currentValues(z, ownerRecord(record, ""), options)
+	if err == errDNSEmptyAnswer {
+		return "", nil
+	}
+	if err != nil && err != errRecordTTLMismatch {
+		return "", err
+	}
+	for _, v := range values {
+		if id, ok := parseOwner(v); ok {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// recordExists returns true if the record exists, or if it cannot be looked
+// up. Records with a routing policy cannot be resolved, so with the dns prune
+// source they are assumed not to exist.
+func recordExists(z dnsZone, record dnsRecord, options registratorOptions) bool {
+	_, err := currentValues(z, record, options)
+	return err != errDNSEmptyAnswer && err != errDNSRoutingPolicy
+}
+
+// takesOwnership returns true if an ingress that needs the record asks to
+// take it over from the cluster that owns it.
+func (r *registrator) takesOwnership(record dnsRecord, owner string) bool {
+	for _, i := range r.ingressWatcher.HostnameIngresses(record.Hostname) {
+		routing, err := routingForIngress(i)
+		if err == nil && routing.SetIdentifier == record.Routing.SetIdentifier && i.Annotations[takeOwnershipAnnotation] == owner {
+			return true
+		}
+	}
+	return false
+}
+
+// withOwnerRecords returns the records along with their owner records. When
+// deleting, the owner records of hostnames that are still claimed with the
+// same set identifier are kept, as the records of other types need them.
+func (r *registrator) withOwnerRecords(action string, records []dnsRecord, clusterID string) []dnsRecord {
+	ret := append([]dnsRecord{}, records...)
+	for _, rec := range records {
+		o := ownerRecord(rec, clusterID)
+		if recordInSlice(o, ret) {
+			continue
+		}
+		if action == route53.ChangeActionDelete && r.claimsSetIdentifier(rec) {
+			continue
+		}
+		ret = append(ret, o)
+	}
+	return ret
+}
+
+// claimsSetIdentifier returns true if any ingress claims the hostname of the
+// record with its set identifier.
+func (r *registrator) claimsSetIdentifier(record dnsRecord) bool {
+	for _, i := range r.ingressWatcher.HostnameIngresses(record.Hostname) {
+		routing, err := routingForIngress(i)
+		if err != nil || routing.SetIdentifier == record.Routing.SetIdentifier {
+			return true
+		}
+	}
+	return false
+}
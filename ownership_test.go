@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOwnerRecord(t *testing.T) {
+	simple := ownerRecord(newCnameRecord("a.example.com.", testPrivateTarget), "one")
+	if simple.Hostname != "_ingress53.a.example.com" || simple.Type != route53.RRTypeTxt || !reflect.DeepEqual(simple.Values, []string{`"heritage=ingress53,cluster=one"`}) {
+		t.Errorf("ownerRecord returned unexpected record: %+v", simple)
+	}
+	blue := ownerRecord(dnsRecord{Hostname: "a.example.com", Type: "CNAME", Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}}, "one")
+	green := ownerRecord(dnsRecord{Hostname: "a.example.com", Type: "A", Routing: routingPolicy{SetIdentifier: "green", Weight: 10}}, "one")
+	if blue.Hostname == simple.Hostname || blue.Hostname == green.Hostname {
+		t.Errorf("ownerRecord returned the same name for different set identifiers: %s, %s", blue.Hostname, green.Hostname)
+	}
+
+	testCases := []struct {
+		value string
+		id    string
+		ok    bool
+	}{
+		{simple.Values[0], "one", true},
+		{"heritage=ingress53,cluster=two", "two", true},
+		{`"heritage=external-dns,external-dns/owner=default"`, "", false},
+		{`"v=spf1 -all"`, "", false},
+	}
+	for i, tc := range testCases {
+		id, ok := parseOwner(tc.value)
+		if id != tc.id || ok != tc.ok {
+			t.Errorf("parseOwner returned unexpected result for test case #%02d: %s, %v", i, id, ok)
+		}
+	}
+}
+
+func TestRegistrator_pruneBatch_ownership(t *testing.T) {
+	z := &mockListingDNSZone{mockDNSZone: &mockDNSZone{domain: "example.com."}, records: newRecordCache()}
+	z.records.Replace([]zoneRecord{
+		{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}},
+		{Name: "_ingress53.a.example.com", Type: "TXT", TTL: 60, Values: []string{`"heritage=ingress53,cluster=one"`}},
+		{Name: "b.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}},
+		{Name: "_ingress53.b.example.com", Type: "TXT", TTL: 60, Values: []string{`"heritage=ingress53,cluster=two"`}},
+		{Name: "c.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}},
+	})
+	handover := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "handover", Annotations: map[string]string{takeOwnershipAnnotation: "two"}},
+		Spec:       v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{Host: "b.example.com"}}},
+	}
+	r := &registrator{
+		zones:          []dnsZone{z},
+		ingressWatcher: &ingressWatcher{store: &mockStore{}},
+		options:        registratorOptions{PruneSource: pruneSourceProvider, RecordTTL: 60, ClusterID: "one"},
+	}
+	records := []dnsRecord{
+		newCnameRecord("a.example.com", testPrivateTarget),
+		newCnameRecord("b.example.com", testPrivateTarget),
+		newCnameRecord("c.example.com", testPrivateTarget),
+		newCnameRecord("d.example.com", testPrivateTarget),
+	}
+
+	// up to date records owned by this cluster are skipped, records owned by
+	// another cluster and existing records without an owner are left alone,
+	// and new records are created
+	pruned := r.pruneBatch(z, route53.ChangeActionUpsert, records)
+	if !reflect.DeepEqual(pruned, records[3:]) {
+		t.Errorf("pruneBatch returned unexpected records for upsert: %+v", pruned)
+	}
+
+	// existing records without an owner are only taken over when asked to
+	r.options.AdoptUnowned = true
+	pruned = r.pruneBatch(z, route53.ChangeActionUpsert, records)
+	if !reflect.DeepEqual(pruned, records[2:]) {
+		t.Errorf("pruneBatch returned unexpected records for upsert with adoption: %+v", pruned)
+	}
+	r.options.AdoptUnowned = false
+
+	// only records owned by this cluster are deleted
+	pruned = r.pruneBatch(z, route53.ChangeActionDelete, records)
+	if !reflect.DeepEqual(pruned, records[:1]) {
+		t.Errorf("pruneBatch returned unexpected records for delete: %+v", pruned)
+	}
+
+	// records owned by another cluster are taken over when asked to
+	r.ingressWatcher.store = &mockStore{items: []interface{}{handover}}
+	pruned = r.pruneBatch(z, route53.ChangeActionUpsert, records[1:2])
+	if !reflect.DeepEqual(pruned, records[1:2]) {
+		t.Errorf("pruneBatch returned unexpected records for a handover: %+v", pruned)
+	}
+}
+
+func TestRegistrator_withOwnerRecords(t *testing.T) {
+	claimed := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "claimed"},
+		Spec:       v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{Host: "b.example.com"}}},
+	}
+	r := &registrator{ingressWatcher: &ingressWatcher{store: &mockStore{items: []interface{}{claimed}}}}
+	records := []dnsRecord{
+		{Hostname: "a.example.com", Type: "A", Values: []string{"10.0.0.1"}},
+		{Hostname: "a.example.com", Type: "AAAA", Values: []string{"::1"}},
+		{Hostname: "b.example.com", Type: "AAAA", Values: []string{"::1"}},
+	}
+
+	upserts := r.withOwnerRecords(route53.ChangeActionUpsert, records, "one")
+	expected := append(append([]dnsRecord{}, records...), ownerRecord(records[0], "one"), ownerRecord(records[2], "one"))
+	if !reflect.DeepEqual(upserts, expected) {
+		t.Errorf("withOwnerRecords returned unexpected records for upsert: %+v", upserts)
+	}
+
+	// b.example.com is still claimed, so its owner record is needed by its
+	// other records
+	deletes := r.withOwnerRecords(route53.ChangeActionDelete, records, "one")
+	expected = append(append([]dnsRecord{}, records...), ownerRecord(records[0], "one"))
+	if !reflect.DeepEqual(deletes, expected) {
+		t.Errorf("withOwnerRecords returned unexpected records for delete: %+v", deletes)
+	}
+}
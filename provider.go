@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+const providerRoute53 = "route53"
+
+var (
+	errRegistratorUnknownProvider = errors.New("unknown dns provider")
+	errRegistratorProviderChanged = errors.New("dns provider cannot be changed without a restart")
+	errRegistratorProviderOptions = errors.New("dns provider options cannot be changed without a restart")
+
+	providers = map[string]providerFactory{}
+
+	// fullCapabilities are the capabilities of a provider that supports all
+	// the features of ingress53.
+	fullCapabilities = providerCapabilities{
+		Alias:           true,
+		RoutingPolicies: []string{routingWeighted, routingFailover, routingLatency, routingGeolocation},
+		TXT:             true,
+	}
+)
+
+// dnsProvider is a dns service that hosts zones.
+type dnsProvider interface {
+	// NewZone returns the zone with the id, which identifies it in the
+	// provider, eg. the route53 hosted zone id. The context bounds the calls
+	// made to set the zone up.
+	NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error)
+	Capabilities() providerCapabilities
+}
+
+// providerCapabilities are the features of a dns provider, so that records
+// it cannot manage are skipped instead of failing whole batches.
+type providerCapabilities struct {
+	// Alias is true if the provider has alias records, which point to other
+	// resources instead of holding values.
+	Alias bool
+	// RoutingPolicies are the routing policies supported, besides simple
+	// routing.
+	RoutingPolicies []string
+	// TXT is true if the provider can manage TXT records, which are needed
+	// to record the owner of the records when a cluster id is set.
+	TXT bool
+}
+
+// supportsRouting returns true if records with the routing policy can be
+// managed.
+func (c providerCapabilities) supportsRouting(rp routingPolicy) bool {
+	kind := rp.kind()
+	return kind == routingSimple || stringInSlice(kind, c.RoutingPolicies)
+}
+
+func (c providerCapabilities) String() string {
+	routing := append([]string{routingSimple}, c.RoutingPolicies...)
+	return "routing: " + strings.Join(routing, ",") + ", alias: " + yesNo(c.Alias) + ", txt: " + yesNo(c.TXT)
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// providerFactory returns a new provider, set up with the options.
+type providerFactory func(options registratorOptions) (dnsProvider, error)
+
+// registerProvider makes the provider available under the name, so that it
+// can be chosen with the -provider flag. It's meant to be called from init
+// functions.
+func registerProvider(name string, factory providerFactory) {
+	providers[name] = factory
+}
+
+// providerOptionsChanged returns true if the options the providers are set up
+// with differ, as they are only read when the provider is created.
+func providerOptionsChanged(a, b registratorOptions) bool {
+	return !reflect.DeepEqual(a.RFC2136, b.RFC2136) ||
+		!reflect.DeepEqual(a.Builtin, b.Builtin) ||
+		!reflect.DeepEqual(a.Etcd, b.Etcd) ||
+		!reflect.DeepEqual(a.Export, b.Export) ||
+		!reflect.DeepEqual(a.Webhook, b.Webhook)
+}
+
+func newProvider(options registratorOptions) (dnsProvider, error) {
+	factory, ok := providers[options.Provider]
+	if !ok {
+		return nil, errRegistratorUnknownProvider
+	}
+	return factory(options)
+}
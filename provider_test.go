@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+type mockProvider struct {
+	capabilities providerCapabilities
+	zones        map[string]dnsZone
+}
+
+func (m *mockProvider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	return m.zones[id], nil
+}
+
+func (m *mockProvider) Capabilities() providerCapabilities { return m.capabilities }
+
+func TestNewProvider(t *testing.T) {
+	if _, err := newProvider(registratorOptions{Provider: "bind"}); err != errRegistratorUnknownProvider {
+		t.Errorf("newProvider returned unexpected error for an unknown provider: %+v", err)
+	}
+
+	mp := &mockProvider{}
+	registerProvider("mock", func(registratorOptions) (dnsProvider, error) { return mp, nil })
+	defer delete(providers, "mock")
+	p, err := newProvider(registratorOptions{Provider: "mock"})
+	if err != nil || p != mp {
+		t.Errorf("newProvider returned unexpected result: %+v, %+v", p, err)
+	}
+	options := registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, Provider: "mock"}
+	if err := validateOptions(&options); err != nil {
+		t.Errorf("validateOptions returned unexpected error for a registered provider: %+v", err)
+	}
+}
+
+func TestProviderCapabilities_supportsRouting(t *testing.T) {
+	c := providerCapabilities{RoutingPolicies: []string{routingWeighted}}
+	testCases := []struct {
+		routing  routingPolicy
+		expected bool
+	}{
+		{routingPolicy{}, true},
+		{routingPolicy{SetIdentifier: "a", Weight: 10}, true},
+		{routingPolicy{SetIdentifier: "a", Failover: "PRIMARY"}, false},
+		{routingPolicy{SetIdentifier: "a", Region: "eu-west-1"}, false},
+	}
+	for i, tc := range testCases {
+		if s := c.supportsRouting(tc.routing); s != tc.expected {
+			t.Errorf("providerCapabilities.supportsRouting returned unexpected result for test case #%02d: %v", i, s)
+		}
+	}
+}
+
+func TestProviderCapabilities_String(t *testing.T) {
+	testCases := []struct {
+		capabilities providerCapabilities
+		expected     string
+	}{
+		{fullCapabilities, "routing: simple,weighted,failover,latency,geolocation, alias: yes, txt: yes"},
+		{providerCapabilities{TXT: true}, "routing: simple, alias: no, txt: yes"},
+	}
+	for i, tc := range testCases {
+		if s := tc.capabilities.String(); s != tc.expected {
+			t.Errorf("providerCapabilities.String returned unexpected result for test case #%02d: %s", i, s)
+		}
+	}
+}
+
+func TestRegistrator_capabilities(t *testing.T) {
+	mdz := &mockDNSZone{domain: "example.com.", zoneData: map[string]string{}}
+	server, err := mdz.startMockDNSServer()
+	defer server.Shutdown()
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	r := &registrator{
+		zones:          []dnsZone{mdz},
+		provider:       &mockProvider{},
+		ingressWatcher: &ingressWatcher{store: &mockStore{}},
+		options:        registratorOptions{Provider: "mock", ClusterID: "one"},
+	}
+	records := []dnsRecord{
+		newCnameRecord("a.example.com", testPrivateTarget),
+		{Hostname: "b.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}},
+	}
+
+	// records with unsupported routing policies are skipped, and without TXT
+	// records there is no owner to check
+	pruned := r.pruneBatch(mdz, route53.ChangeActionUpsert, records)
+	if !reflect.DeepEqual(pruned, records[:1]) {
+		t.Errorf("pruneBatch returned unexpected records for upsert: %+v", pruned)
+	}
+	if _, err := r.applyBatch([]recordChange{{route53.ChangeActionUpsert, records[0]}}); err != nil {
+		t.Errorf("applyBatch returned unexpected error: %+v", err)
+	}
+	if len(mdz.zoneData) != 1 || mdz.zoneData["a.example.com"] != testPrivateTarget {
+		t.Errorf("applyBatch did not apply the expected records: %+v", mdz.zoneData)
+	}
+
+	// records are never deleted when their owner cannot be recorded
+	pruned = r.pruneBatch(mdz, route53.ChangeActionDelete, records)
+	if len(pruned) != 0 {
+		t.Errorf("pruneBatch returned unexpected records for delete: %+v", pruned)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var errRecordCacheNotLoaded = errors.New("zone records have not been loaded yet")
+
+type zoneRecord struct {
+	Name    string
+	Type    string
+	TTL     int64
+	Values  []string
+	Routing routingPolicy
+}
+
+// recordCache holds the records of a zone, as listed by the dns provider and
+// kept up to date with the changes ingress53 makes.
+type recordCache struct {
+	mu      sync.RWMutex
+	loaded  bool
+	records map[string][]zoneRecord
+}
+
+func newRecordCache() *recordCache {
+	return &recordCache{records: map[string][]zoneRecord{}}
+}
+
+// Replace replaces the contents of the cache with the records.
+func (c *recordCache) Replace(records []zoneRecord) {
+	m := map[string][]zoneRecord{}
+	for _, r := range records {
+		r.Name = normalizeRecordName(r.Name)
+		m[r.Name] = append(m[r.Name], r)
+	}
+	c.mu.Lock()
+	c.records = m
+	c.loaded = true
+	c.mu.Unlock()
+}
+
+// Upsert replaces the record with the same name, type and set identifier, or
+// adds it.
+func (c *recordCache) Upsert(record zoneRecord) {
+	record.Name = normalizeRecordName(record.Name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	existing := c.records[record.Name]
+	for i, r := range existing {
+		if r.Type == record.Type && r.Routing.SetIdentifier == record.Routing.SetIdentifier {
+			existing[i] = record
+			return
+		}
+	}
+	c.records[record.Name] = append(existing, record)
+}
+
+// Delete removes the record with the name, type and set identifier.
+func (c *recordCache) Delete(name string, recordType string, setIdentifier string) {
+	name = normalizeRecordName(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	remaining := []zoneRecord{}
+	for _, r := range c.records[name] {
+		if r.Type != recordType || r.Routing.SetIdentifier != setIdentifier {
+			remaining = append(remaining, r)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(c.records, name)
+		return
+	}
+	c.records[name] = remaining
+}
+
+// Lookup returns all the records with the name.
+func (c *recordCache) Lookup(name string) ([]zoneRecord, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.loaded {
+		return nil, errRecordCacheNotLoaded
+	}
+	return c.records[normalizeRecordName(name)], nil
+}
+
+// normalizeRecordName lowercases the name, removes the trailing dot and
+// unescapes the wildcard, so that names from different sources can be
+// compared.
+func normalizeRecordName(name string) string {
+	name = strings.Replace(name, "\\052", "*", -1)
+	return strings.ToLower(strings.Trim(name, "."))
+}
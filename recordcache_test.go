@@ -0,0 +1,69 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRecordCache(t *testing.T) {
+	c := newRecordCache()
+	if _, err := c.Lookup("a.example.com"); err != errRecordCacheNotLoaded {
+		t.Errorf("recordCache.Lookup returned unexpected error: %+v", err)
+	}
+
+	c.Replace([]zoneRecord{
+		{Name: "A.example.com.", Type: "CNAME", TTL: 60, Values: []string{"target.example.com"}},
+		{Name: "a.example.com.", Type: "TXT", TTL: 60, Values: []string{"\"text\""}},
+		{Name: "\\052.example.com.", Type: "CNAME", TTL: 60, Values: []string{"target.example.com"}},
+	})
+
+	testCases := []struct {
+		name     string
+		expected []zoneRecord
+	}{
+		{"a.example.com", []zoneRecord{
+			{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{"target.example.com"}},
+			{Name: "a.example.com", Type: "TXT", TTL: 60, Values: []string{"\"text\""}},
+		}},
+		{"*.example.com.", []zoneRecord{
+			{Name: "*.example.com", Type: "CNAME", TTL: 60, Values: []string{"target.example.com"}},
+		}},
+		{"b.example.com", nil},
+	}
+	for i, tc := range testCases {
+		records, err := c.Lookup(tc.name)
+		if err != nil {
+			t.Errorf("recordCache.Lookup returned unexpected error for test case #%02d: %+v", i, err)
+		}
+		if !reflect.DeepEqual(records, tc.expected) {
+			t.Errorf("recordCache.Lookup returned unexpected records for test case #%02d: %+v", i, records)
+		}
+	}
+
+	c.Upsert(zoneRecord{Name: "a.example.com", Type: "CNAME", TTL: 300, Values: []string{"other.example.com"}})
+	c.Upsert(zoneRecord{Name: "b.example.com", Type: "CNAME", TTL: 300, Values: []string{"other.example.com"}})
+	c.Delete("a.example.com.", "TXT", "")
+	c.Delete("*.example.com", "CNAME", "")
+
+	testCases = []struct {
+		name     string
+		expected []zoneRecord
+	}{
+		{"a.example.com", []zoneRecord{
+			{Name: "a.example.com", Type: "CNAME", TTL: 300, Values: []string{"other.example.com"}},
+		}},
+		{"b.example.com", []zoneRecord{
+			{Name: "b.example.com", Type: "CNAME", TTL: 300, Values: []string{"other.example.com"}},
+		}},
+		{"*.example.com", nil},
+	}
+	for i, tc := range testCases {
+		records, err := c.Lookup(tc.name)
+		if err != nil {
+			t.Errorf("recordCache.Lookup returned unexpected error for test case #%02d: %+v", i, err)
+		}
+		if !reflect.DeepEqual(records, tc.expected) {
+			t.Errorf("recordCache.Lookup returned unexpected records after changes for test case #%02d: %+v", i, records)
+		}
+	}
+}
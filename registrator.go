@@ -1,64 +1,200 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"path"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"time"
+	"unicode"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
-	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/api/extensions/v1beta1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 )
 
 var (
-	errRegistratorMissingOption = errors.New("missing required registrator option")
-	errDNSEmptyAnswer           = errors.New("DNS nameserver returned an empty answer")
-	defaultResyncPeriod         = 15 * time.Minute
-	defaultBatchProcessCycle    = 5 * time.Second
-	dnsClient                   = &dns.Client{}
+	errRegistratorMissingOption      = errors.New("missing required registrator option")
+	errRegistratorInvalidPolicy      = errors.New("invalid registrator policy")
+	errRegistratorInvalidPruneSource = errors.New("invalid registrator prune source")
+	errRecordTTLMismatch             = errors.New("record has a different TTL")
+	errRecordRoutingMismatch         = errors.New("record has a different routing policy")
+	errRegistratorTargetLabelChanged = errors.New("target label cannot be changed without a restart")
+	errRegistratorNotStarted         = errors.New("registrator has not been started")
+	errRegistratorTargetsMapChanged  = errors.New("targets configmap cannot be changed without a restart")
+	errRegistratorEmptyTarget        = errors.New("target alias maps to an empty target")
+	errRegistratorDrainTimedOut      = errors.New("timed out draining the update queue")
+	defaultResyncPeriod              = 15 * time.Minute
+	defaultDrainTimeout              = 30 * time.Second
+	defaultSyncWaitTimeout           = 5 * time.Minute
+	defaultBatchProcessCycle         = 5 * time.Second
+	// maxBatchRecords is the largest number of record values submitted to a
+	// zone at once, as route53 rejects bigger change batches
+	maxBatchRecords = 1000
 )
 
+const (
+	policySync       = "sync"
+	policyUpsertOnly = "upsert-only"
+
+	pruneSourceDNS      = "dns"
+	pruneSourceProvider = "provider"
+
+	// actionPrune deletes records that are no longer wanted after the
+	// options change, regardless of the hostname filters in use
+	actionPrune = "PRUNE"
+)
+
+// dnsZone is a zone created by a dns provider. Zones that can list their
+// records, or that apply changes asynchronously, also implement recordLister
+// and asyncZone.
 type dnsZone interface {
-	UpsertCnames(records []cnameRecord) error
-	DeleteCnames(records []cnameRecord) error
+	UpsertRecords(ctx context.Context, records []dnsRecord) error
+	DeleteRecords(ctx context.Context, records []dnsRecord) error
 	Domain() string
 	ListNameservers() []string
 }
 
-type cnameChange struct {
+// asyncZone is implemented by zones that apply changes asynchronously and need
+// to track them in the background.
+type asyncZone interface {
+	Run(ctx context.Context)
+	WaitForChanges(ctx context.Context) error
+}
+
+// recordLister is implemented by zones that can look up their current records
+// without querying the nameservers.
+type recordLister interface {
+	LookupRecords(name string) ([]zoneRecord, error)
+}
+
+type recordChange struct {
 	Action string
-	Record cnameRecord
+	Record dnsRecord
 }
 
-type cnameRecord struct {
+// dnsRecord is the set of values of a type of record for a hostname.
+type dnsRecord struct {
 	Hostname string
-	Target   string
+	Type     string
+	Values   []string
+	Routing  routingPolicy
+	Private  bool // only written to private zones
+}
+
+func newCnameRecord(hostname string, target string) dnsRecord {
+	return dnsRecord{Hostname: hostname, Type: route53.RRTypeCname, Values: []string{target}}
+}
+
+// recordsForTarget returns the records needed for the hostname to point to
+// the target: a CNAME record if the target is a hostname, or A and AAAA
+// records if it is a list of IP addresses separated by spaces or commas.
+func recordsForTarget(hostname string, target string) []dnsRecord {
+	fields := strings.FieldsFunc(target, func(c rune) bool { return c == ',' || unicode.IsSpace(c) })
+	v4 := []string{}
+	v6 := []string{}
+	for _, f := range fields {
+		ip := net.ParseIP(f)
+		if ip == nil {
+			return []dnsRecord{newCnameRecord(hostname, target)}
+		}
+		if ip.To4() != nil {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
+		}
+	}
+	ret := []dnsRecord{}
+	if len(v4) > 0 {
+		sort.Strings(v4)
+		ret = append(ret, dnsRecord{Hostname: hostname, Type: route53.RRTypeA, Values: v4})
+	}
+	if len(v6) > 0 {
+		sort.Strings(v6)
+		ret = append(ret, dnsRecord{Hostname: hostname, Type: route53.RRTypeAaaa, Values: v6})
+	}
+	return ret
+}
+
+// staleRecords returns the records of the hostnames pointing to the old
+// target that are not replaced by the records pointing to the new one: those
+// with a type that is no longer needed, eg. the CNAME records when moving from
+// a hostname to IP addresses, or with a different set identifier.
+func staleRecords(hostnames []string, oldTarget string, oldRouting routingPolicy, newTarget string, newRouting routingPolicy) []dnsRecord {
+	ret := []dnsRecord{}
+	for _, h := range hostnames {
+		needed := withRouting(recordsForTarget(h, newTarget), newRouting)
+		for _, o := range withRouting(recordsForTarget(h, oldTarget), oldRouting) {
+			if !recordInSlice(o, needed) {
+				ret = append(ret, o)
+			}
+		}
+	}
+	return ret
 }
 
 type registrator struct {
-	dnsZone
 	*ingressWatcher
-	options     registratorOptions
-	sats        []selectorAndTarget
-	updateQueue chan cnameChange
+	targetsWatcher *configMapWatcher
+	mu             sync.RWMutex
+	ctx            context.Context
+	cancel         context.CancelFunc
+	zones          []dnsZone
+	zonesCtx       context.Context
+	zonesCancel    context.CancelFunc
+	options        registratorOptions
+	sats           []selectorAndTarget
+	targetAliases  map[string]string
+	updateQueue    chan recordChange
+	provider       dnsProvider
+	recorder       record.EventRecorder
 }
 
 type registratorOptions struct {
 	AWSSessionOptions *session.Options
 	KubernetesConfig  *rest.Config
-	Targets           []string // required
+	KubernetesClient  kubernetes.Interface
+	Targets           []string // required, unless TargetsConfigMap is set
+	TargetsConfigMap  string   // namespace/name of a configmap mapping target aliases to targets
 	TargetLabelName   string   // required
-	Route53ZoneID     string   // required
+	Provider          string   // name of the dns provider, defaults to route53
+	ZoneIDs           []string // required
+	ZoneRoles         map[string]awsRole
+	AWS               awsOptions
+	RFC2136           rfc2136Options
+	Builtin           builtinOptions
+	Etcd              etcdOptions
+	Export            exportOptions
+	Webhook           webhookOptions
 	ResyncPeriod      time.Duration
+	DrainTimeout      time.Duration
+	VerifyPropagation bool
+	VerifyTimeout     time.Duration
+	RecordTTL         int64
+	Policy            string
+	PruneSource       string
+	RecordsRefresh    time.Duration
+	Namespaces        []string
+	HostnameFilters   []string
+	Resolvers         []string // queried instead of the zone nameservers, if set
+	PrivateTargets    []string // targets whose records are only written to private zones
+	PrivateResolvers  []string // queried for the records of private zones, which are listed if unset
+	ClusterID         string   // if set, only records owned by this cluster are updated or deleted
+	AdoptUnowned      bool     // take over the existing records without an owner, if ClusterID is set
 }
 
 type selectorAndTarget struct {
@@ -69,29 +205,24 @@ type selectorAndTarget struct {
 func newRegistrator(zoneID string, targets []string, targetLabelName string) (*registrator, error) {
 	return newRegistratorWithOptions(
 		registratorOptions{
-			Route53ZoneID:   zoneID,
+			ZoneIDs:         []string{zoneID},
 			Targets:         targets,
 			TargetLabelName: targetLabelName,
 		})
 }
 
 func newRegistratorWithOptions(options registratorOptions) (*registrator, error) {
-	// check required options are set
-	if len(options.Targets) == 0 || options.Route53ZoneID == "" || options.TargetLabelName == "" {
-		return nil, errRegistratorMissingOption
+	if err := validateOptions(&options); err != nil {
+		return nil, err
 	}
-	var sats []selectorAndTarget
-	for _, target := range options.Targets {
-		s, err := labels.Parse(options.TargetLabelName + "=" + target)
-		if err != nil {
-			return nil, err
-		}
-		sats = append(sats, selectorAndTarget{Selector: s, Target: target})
+	sats, err := buildSelectors(options.TargetLabelName, options.Targets, nil)
+	if err != nil {
+		return nil, err
 	}
 	if options.AWSSessionOptions == nil {
 		options.AWSSessionOptions = &session.Options{}
 	}
-	if options.KubernetesConfig == nil {
+	if options.KubernetesConfig == nil && options.KubernetesClient == nil {
 		c, err := rest.InClusterConfig()
 		if err != nil {
 			return nil, err
@@ -101,176 +232,828 @@ func newRegistratorWithOptions(options registratorOptions) (*registrator, error)
 	if options.ResyncPeriod == 0 {
 		options.ResyncPeriod = defaultResyncPeriod
 	}
+	if options.DrainTimeout == 0 {
+		options.DrainTimeout = defaultDrainTimeout
+	}
+	if options.VerifyTimeout == 0 {
+		options.VerifyTimeout = defaultVerifyTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &registrator{
+		ctx:         ctx,
+		cancel:      cancel,
 		options:     options,
 		sats:        sats,
-		updateQueue: make(chan cnameChange, 64),
+		updateQueue: make(chan recordChange, 64),
 	}, nil
 }
 
-func (r *registrator) Start() error {
-	sess, err := session.NewSessionWithOptions(*r.options.AWSSessionOptions)
-	if err != nil {
+// validateOptions checks the options that can be changed on a running
+// registrator and sets their defaults.
+func validateOptions(options *registratorOptions) error {
+	// check required options are set
+	if (len(options.Targets) == 0 && options.TargetsConfigMap == "") || len(options.ZoneIDs) == 0 || options.TargetLabelName == "" {
+		return errRegistratorMissingOption
+	}
+	for _, id := range options.ZoneIDs {
+		if id == "" {
+			return errRegistratorMissingOption
+		}
+	}
+	if _, err := buildSelectors(options.TargetLabelName, options.Targets, nil); err != nil {
 		return err
 	}
-	dns, err := newRoute53Zone(r.options.Route53ZoneID, route53.New(sess))
+	if options.TargetsConfigMap != "" {
+		if _, _, err := parseConfigMapName(options.TargetsConfigMap); err != nil {
+			return err
+		}
+	}
+	switch options.Provider {
+	case "":
+		options.Provider = providerRoute53
+	default:
+		if _, ok := providers[options.Provider]; !ok {
+			return errRegistratorUnknownProvider
+		}
+	}
+	for _, f := range options.HostnameFilters {
+		if _, err := path.Match(f, ""); err != nil {
+			return err
+		}
+	}
+	if err := validateRoleARN(options.AWS.RoleARN); err != nil {
+		return err
+	}
+	for _, role := range options.ZoneRoles {
+		if err := validateRoleARN(role.ARN); err != nil {
+			return err
+		}
+	}
+	if options.ClusterID != "" && !validClusterID.MatchString(options.ClusterID) {
+		return errRegistratorInvalidClusterID
+	}
+	options.Resolvers = withDNSPort(options.Resolvers)
+	options.PrivateResolvers = withDNSPort(options.PrivateResolvers)
+	switch options.Policy {
+	case "":
+		options.Policy = policySync
+	case policySync, policyUpsertOnly:
+	default:
+		return errRegistratorInvalidPolicy
+	}
+	switch options.PruneSource {
+	case "":
+		options.PruneSource = pruneSourceDNS
+	case pruneSourceDNS, pruneSourceProvider:
+	default:
+		return errRegistratorInvalidPruneSource
+	}
+	if options.RecordsRefresh == 0 {
+		options.RecordsRefresh = defaultRoute53RecordsRefreshInterval
+	}
+	if options.RecordTTL == 0 {
+		options.RecordTTL = defaultRoute53RecordTTL
+	}
+	return nil
+}
+
+// withDNSPort returns the nameservers with the default port, if they have
+// none.
+func withDNSPort(nameservers []string) []string {
+	if len(nameservers) == 0 {
+		return nameservers
+	}
+	ret := make([]string, len(nameservers))
+	for i, ns := range nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, "53")
+		}
+		ret[i] = ns
+	}
+	return ret
+}
+
+// buildSelectors returns the selectors for the targets, which match the label
+// value to the target itself, followed by the selectors for the aliases, which
+// match the alias to the target it maps to.
+func buildSelectors(labelName string, targets []string, aliases map[string]string) ([]selectorAndTarget, error) {
+	var sats []selectorAndTarget
+	for _, target := range targets {
+		s, err := labels.Parse(labelName + "=" + target)
+		if err != nil {
+			return nil, err
+		}
+		sats = append(sats, selectorAndTarget{Selector: s, Target: target})
+	}
+	keys := make([]string, 0, len(aliases))
+	for alias := range aliases {
+		keys = append(keys, alias)
+	}
+	sort.Strings(keys)
+	for _, alias := range keys {
+		target := strings.TrimSpace(aliases[alias])
+		if target == "" {
+			return nil, errRegistratorEmptyTarget
+		}
+		s, err := labels.Parse(labelName + "=" + alias)
+		if err != nil {
+			return nil, err
+		}
+		sats = append(sats, selectorAndTarget{Selector: s, Target: target})
+	}
+	return sats, nil
+}
+
+type syncSummary struct {
+	Ingresses int
+	Records   int
+	Changed   int
+}
+
+func (r *registrator) setup() error {
+	provider, err := newProvider(r.options)
 	if err != nil {
 		return err
 	}
-	r.dnsZone = dns
-	log.Println("[INFO] setup route53 session")
-	kubeClient, err := kubernetes.NewForConfig(r.options.KubernetesConfig)
+	r.provider = provider
+	log.Printf("[INFO] using dns provider %s (%s)", r.options.Provider, provider.Capabilities())
+	if r.options.ClusterID != "" && !provider.Capabilities().TXT {
+		log.Printf("[ERROR] dns provider %s cannot manage TXT records: the owner of the records will not be recorded, and no records will be deleted", r.options.Provider)
+	}
+	zones, err := r.newZones(r.context(), r.options)
 	if err != nil {
 		return err
 	}
+	r.zones = zones
+	kubeClient := r.options.KubernetesClient
+	if kubeClient == nil {
+		if kubeClient, err = kubernetes.NewForConfig(r.options.KubernetesConfig); err != nil {
+			return err
+		}
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	r.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "ingress53"})
+	r.zonesCtx, r.zonesCancel = context.WithCancel(r.ctx)
+	r.runZones(r.zonesCtx, zones, r.options)
 	r.ingressWatcher = newIngressWatcher(kubeClient, r.handler, r.options.TargetLabelName, r.options.ResyncPeriod)
 	log.Println("[INFO] setup kubernetes ingress watcher")
-	wg := sync.WaitGroup{}
-	wg.Add(1)
+	if r.options.TargetsConfigMap != "" {
+		namespace, name, _ := parseConfigMapName(r.options.TargetsConfigMap)
+		r.targetsWatcher = newConfigMapWatcher(kubeClient, namespace, name, r.setTargetAliases, r.options.ResyncPeriod)
+		aliases, err := r.targetsWatcher.Get()
+		if err != nil {
+			log.Printf("[ERROR] could not get targets configmap %s, will wait for it to be created: %+v", r.options.TargetsConfigMap, err)
+		} else {
+			r.setTargetAliases(aliases)
+		}
+		log.Println("[INFO] setup kubernetes targets configmap watcher")
+	}
+	return nil
+}
+
+// runZones starts tracking the changes of the zones that apply them
+// asynchronously, verifying them once they are in sync if required. The
+// verification outlives the zones, so that it's not cut short by a reload.
+func (r *registrator) runZones(ctx context.Context, zones []dnsZone, options registratorOptions) {
+	for _, z := range zones {
+		if sn, ok := z.(syncNotifier); ok && options.VerifyPropagation {
+			zone := z
+			sn.OnSync(func(action string, records []dnsRecord) {
+				if action != route53.ChangeActionDelete {
+					go r.verifyRecords(r.context(), zone, records)
+				}
+			})
+		}
+		if az, ok := z.(asyncZone); ok {
+			go az.Run(ctx)
+		}
+	}
+}
+
+func (r *registrator) newZones(ctx context.Context, options registratorOptions) ([]dnsZone, error) {
+	zones := make([]dnsZone, len(options.ZoneIDs))
+	for i, id := range options.ZoneIDs {
+		z, err := r.provider.NewZone(ctx, id, options)
+		if err != nil {
+			return nil, err
+		}
+		zones[i] = z
+	}
+	return zones, nil
+}
+
+// Reload validates the new options and swaps them in, along with the target
+// selectors and the dns zones, without restarting the ingress watcher. The
+// records that are no longer wanted, eg. because the filters were narrowed,
+// are queued for deletion from the zones that are still configured, and all
+// known ingresses are queued for an update afterwards, so that any changes in
+// the targets are reflected in the zones.
+func (r *registrator) Reload(options registratorOptions) error {
+	if r.provider == nil {
+		return errRegistratorNotStarted
+	}
+	current := r.getOptions()
+	if options.TargetLabelName != current.TargetLabelName {
+		return errRegistratorTargetLabelChanged
+	}
+	if options.TargetsConfigMap != current.TargetsConfigMap {
+		return errRegistratorTargetsMapChanged
+	}
+	if options.ClusterID != current.ClusterID {
+		return errRegistratorClusterIDChanged
+	}
+	if err := validateOptions(&options); err != nil {
+		return err
+	}
+	if options.Provider != current.Provider {
+		return errRegistratorProviderChanged
+	}
+	if providerOptionsChanged(options, current) {
+		return errRegistratorProviderOptions
+	}
+	options.AWSSessionOptions = current.AWSSessionOptions
+	options.KubernetesConfig = current.KubernetesConfig
+	options.KubernetesClient = current.KubernetesClient
+	options.AWS.Region = current.AWS.Region
+	options.AWS.Route53Endpoint = current.AWS.Route53Endpoint
+	options.ResyncPeriod = current.ResyncPeriod
+	options.DrainTimeout = current.DrainTimeout
+	options.VerifyPropagation = current.VerifyPropagation
+	options.VerifyTimeout = current.VerifyTimeout
+	zones, err := r.newZones(r.context(), options)
+	if err != nil {
+		return err
+	}
+	previous := r.wantedRecords()
+	r.mu.Lock()
+	sats, err := buildSelectors(options.TargetLabelName, options.Targets, r.targetAliases)
+	if err != nil {
+		r.mu.Unlock()
+		return err
+	}
+	previousZones, previousCtx, previousCancel := r.zones, r.zonesCtx, r.zonesCancel
+	r.options = options
+	r.sats = sats
+	r.zones = zones
+	r.zonesCtx, r.zonesCancel = context.WithCancel(r.ctx)
+	r.runZones(r.zonesCtx, zones, options)
+	r.mu.Unlock()
+	if previousCancel != nil {
+		go retireZones(previousCtx, previousCancel, previousZones)
+	}
+	log.Printf("[INFO] reloaded configuration: %d target(s), %d zone(s)", len(sats), len(zones))
+	r.pruneUnwanted(previous)
+	r.resyncAll()
+	return nil
+}
+
+// retireZones keeps tracking the changes submitted to zones that were
+// replaced, until they are in sync or defaultSyncWaitTimeout passes, before
+// cancelling their context.
+func retireZones(ctx context.Context, cancel context.CancelFunc, zones []dnsZone) {
+	defer cancel()
+	ctx, done := context.WithTimeout(ctx, defaultSyncWaitTimeout)
+	defer done()
+	for _, z := range zones {
+		if az, ok := z.(asyncZone); ok {
+			if err := az.WaitForChanges(ctx); err != nil {
+				log.Printf("[ERROR] changes to replaced zone %s are not in sync, will stop tracking them: %+v", z.Domain(), err)
+				return
+			}
+		}
+	}
+}
+
+// setTargetAliases replaces the target aliases and queues an update for all
+// the ingresses whose alias now maps to a different target. The records that
+// are no longer needed, eg. those of the ingresses whose alias was removed,
+// are queued for deletion first.
+func (r *registrator) setTargetAliases(aliases map[string]string) {
+	previous := r.wantedRecords()
+	r.mu.Lock()
+	sats, err := buildSelectors(r.options.TargetLabelName, r.options.Targets, aliases)
+	if err != nil {
+		r.mu.Unlock()
+		log.Printf("[ERROR] invalid target aliases, keeping the current ones: %+v", err)
+		return
+	}
+	changed := diffTargetAliases(r.targetAliases, aliases)
+	labelName := r.options.TargetLabelName
+	r.targetAliases = aliases
+	r.sats = sats
+	r.mu.Unlock()
+	if len(changed) == 0 {
+		return
+	}
+	log.Printf("[INFO] target aliases changed: %s", strings.Join(changed, ", "))
+	r.pruneUnwanted(previous)
+	r.resyncIngresses(func(i *v1beta1.Ingress) bool {
+		return stringInSlice(i.Labels[labelName], changed)
+	})
+}
+
+// wantedRecords returns the records needed by the known ingresses that are
+// allowed by the current options, by zone id.
+func (r *registrator) wantedRecords() map[string][]dnsRecord {
+	ret := map[string][]dnsRecord{}
+	if r.ingressWatcher == nil || r.ingressWatcher.store == nil {
+		return ret
+	}
+	options := r.getOptions()
+	records := []dnsRecord{}
+	for _, i := range r.ingressWatcher.store.List() {
+		ingress := i.(*v1beta1.Ingress)
+		if !r.namespaceAllowed(ingress) {
+			continue
+		}
+		target := r.getTargetForIngress(ingress)
+		routing, err := routingForIngress(ingress)
+		if target == "" || err != nil {
+			continue
+		}
+		for _, h := range getHostnamesFromIngress(ingress) {
+			if hostnameMatchesFilters(h, options.HostnameFilters) {
+				records = append(records, r.recordsForIngress(h, target, routing)...)
+			}
+		}
+	}
+	for i, z := range r.getZones() {
+		if i < len(options.ZoneIDs) {
+			ret[options.ZoneIDs[i]] = visibleRecords(z, records)
+		}
+	}
+	return ret
+}
+
+// pruneUnwanted queues the deletion of the records that were wanted before
+// the options changed but no longer are, in the zones that are still
+// configured. Records in zones that were removed are left alone.
+func (r *registrator) pruneUnwanted(previous map[string][]dnsRecord) {
+	current := r.wantedRecords()
+	unwanted := []dnsRecord{}
+	for id, records := range previous {
+		wanted, ok := current[id]
+		if !ok {
+			continue
+		}
+		for _, rec := range records {
+			if !recordInSlice(rec, wanted) && !recordInSlice(rec, unwanted) {
+				unwanted = append(unwanted, rec)
+			}
+		}
+	}
+	if len(unwanted) > 0 {
+		log.Printf("[INFO] queued deletion of %d record(s) that are no longer wanted", len(unwanted))
+		r.queueRecords(actionPrune, unwanted)
+	}
+}
+
+func (r *registrator) resyncAll() {
+	r.resyncIngresses(func(*v1beta1.Ingress) bool { return true })
+}
+
+// resyncIngresses queues an update for all the known ingresses that match the
+// filter.
+func (r *registrator) resyncIngresses(filter func(*v1beta1.Ingress) bool) {
+	if r.ingressWatcher == nil || r.ingressWatcher.store == nil {
+		return
+	}
+	for _, i := range r.ingressWatcher.store.List() {
+		ingress := i.(*v1beta1.Ingress)
+		if !filter(ingress) || !r.namespaceAllowed(ingress) {
+			continue
+		}
+		target := r.getTargetForIngress(ingress)
+		routing, err := routingForIngress(ingress)
+		if target != "" && err == nil {
+			r.queueUpdates(route53.ChangeActionUpsert, getHostnamesFromIngress(ingress), target, routing)
+		}
+	}
+}
+
+func (r *registrator) getOptions() registratorOptions {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.options
+}
+
+func (r *registrator) getZones() []dnsZone {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.zones
+}
+
+// context returns the context of the registrator, which is cancelled when it
+// gives up draining.
+func (r *registrator) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// zonesContext returns the context of the current zones, which is cancelled
+// when the zones are replaced or the registrator gives up draining.
+func (r *registrator) zonesContext() context.Context {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.zonesCtx == nil {
+		return context.Background()
+	}
+	return r.zonesCtx
+}
+
+func (r *registrator) Start() error {
+	if err := r.setup(); err != nil {
+		return err
+	}
+	drained := make(chan struct{})
 	go func() {
-		defer wg.Done()
+		defer close(drained)
 		r.processUpdateQueue()
 	}()
+	wg := sync.WaitGroup{}
+	if r.targetsWatcher != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.targetsWatcher.Start()
+		}()
+	}
 	r.ingressWatcher.Start()
 	wg.Wait()
+	return r.waitForDrain(drained)
+}
+
+// waitForDrain waits for the update queue to be drained, for up to the drain
+// timeout.
+func (r *registrator) waitForDrain(drained <-chan struct{}) error {
+	timeout := r.getOptions().DrainTimeout
+	if timeout <= 0 {
+		<-drained
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-drained:
+		return nil
+	case <-timer.C:
+		log.Printf("[ERROR] update queue was not drained within %s, pending changes will be lost", timeout)
+		if r.cancel != nil {
+			r.cancel()
+		}
+		return errRegistratorDrainTimedOut
+	}
+}
+
+// Stop stops the kubernetes watchers, which in turn stops the registrator.
+func (r *registrator) Stop() {
+	if r.targetsWatcher != nil {
+		r.targetsWatcher.Stop()
+	}
+	r.ingressWatcher.Stop()
+}
+
+// SyncOnce lists all the ingresses once and upserts their records, returning
+// after the changes are in sync. Records are never deleted, as those of the
+// ingresses that no longer exist are not known.
+func (r *registrator) SyncOnce() (syncSummary, error) {
+	if err := r.setup(); err != nil {
+		return syncSummary{}, err
+	}
+	ingresses, err := r.ingressWatcher.List()
+	if err != nil {
+		return syncSummary{}, err
+	}
+	summary, err := r.reconcile(ingresses)
+	ctx, cancel := context.WithTimeout(r.zonesContext(), defaultSyncWaitTimeout)
+	defer cancel()
+	if werr := r.waitForChanges(ctx); werr != nil && err == nil {
+		err = werr
+	}
+	return summary, err
+}
+
+// waitForChanges blocks until the changes submitted to all the zones are in
+// sync.
+func (r *registrator) waitForChanges(ctx context.Context) error {
+	for _, z := range r.getZones() {
+		if az, ok := z.(asyncZone); ok {
+			if err := az.WaitForChanges(ctx); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+func (r *registrator) reconcile(ingresses []*v1beta1.Ingress) (syncSummary, error) {
+	summary := syncSummary{Ingresses: len(ingresses)}
+	labelName := r.getOptions().TargetLabelName
+	changes := []recordChange{}
+	for _, ingress := range ingresses {
+		if !r.namespaceAllowed(ingress) {
+			log.Printf("[DEBUG] ignoring ingress %s: namespace %s is filtered out", ingress.Name, ingress.Namespace)
+			continue
+		}
+		hostnames := getHostnamesFromIngress(ingress)
+		target := r.getTargetForIngress(ingress)
+		if target == "" {
+			log.Printf("[INFO] invalid ingress target for ingress %s: %s", ingress.Name, ingress.Labels[labelName])
+			continue
+		}
+		routing, err := routingForIngress(ingress)
+		if err != nil {
+			log.Printf("[INFO] invalid routing annotations for ingress %s: %v", ingress.Name, err)
+			continue
+		}
+		for _, h := range hostnames {
+			for _, rec := range r.recordsForIngress(h, target, routing) {
+				changes = append(changes, recordChange{route53.ChangeActionUpsert, rec})
+			}
+		}
+	}
+	summary.Records = len(changes)
+	if len(changes) == 0 {
+		return summary, nil
+	}
+	changed, err := r.applyBatch(changes)
+	summary.Changed = changed
+	return summary, err
+}
+
 func (r *registrator) handler(eventType watch.EventType, oldIngress *v1beta1.Ingress, newIngress *v1beta1.Ingress) {
+	for _, i := range []*v1beta1.Ingress{newIngress, oldIngress} {
+		if i != nil && !r.namespaceAllowed(i) {
+			log.Printf("[DEBUG] ignoring %s event for %s: namespace %s is filtered out", eventType, i.Name, i.Namespace)
+			return
+		}
+	}
+	labelName := r.getOptions().TargetLabelName
 	switch eventType {
 	case watch.Added:
 		log.Printf("[DEBUG] received %s event for %s", eventType, newIngress.Name)
 		metricUpdatesReceived.WithLabelValues(newIngress.Name, "add").Inc()
 		hostnames := getHostnamesFromIngress(newIngress)
 		target := r.getTargetForIngress(newIngress)
+		routing, err := routingForIngress(newIngress)
 		if target == "" {
-			log.Printf("[INFO] invalid ingress target for new ingress %s: %s", newIngress.Name, newIngress.Labels[r.options.TargetLabelName])
+			log.Printf("[INFO] invalid ingress target for new ingress %s: %s", newIngress.Name, newIngress.Labels[labelName])
+		} else if err != nil {
+			log.Printf("[INFO] invalid routing annotations for new ingress %s: %v", newIngress.Name, err)
 		} else if len(hostnames) == 0 {
 			log.Printf("[INFO] could not extract hostnames from new ingress %s", newIngress.Name)
 		} else {
 			log.Printf("[DEBUG] queued update of %d record(s) for new ingress %s, pointing to %s", len(hostnames), newIngress.Name, target)
-			r.queueUpdates(route53.ChangeActionUpsert, hostnames, target)
+			r.queueUpdates(route53.ChangeActionUpsert, hostnames, target, routing)
 		}
 	case watch.Modified:
 		log.Printf("[DEBUG] received %s event for %s", eventType, newIngress.Name)
 		metricUpdatesReceived.WithLabelValues(newIngress.Name, "modify").Inc()
 		newHostnames := getHostnamesFromIngress(newIngress)
 		newTarget := r.getTargetForIngress(newIngress)
+		newRouting, newRoutingErr := routingForIngress(newIngress)
 		oldHostnames := getHostnamesFromIngress(oldIngress)
 		oldTarget := r.getTargetForIngress(oldIngress)
+		oldRouting, oldRoutingErr := routingForIngress(oldIngress)
 		diffHostnames := diffStringSlices(oldHostnames, newHostnames)
-		if len(diffHostnames) == 0 && newIngress.Labels[r.options.TargetLabelName] == oldIngress.Labels[r.options.TargetLabelName] {
+		if len(diffHostnames) == 0 && newIngress.Labels[labelName] == oldIngress.Labels[labelName] && newRouting == oldRouting {
 			log.Printf("[DEBUG] no changes for ingress %s, looks like a no-op resync", newIngress.Name)
 			break
 		}
+		if newTarget != "" && oldTarget != "" && newRoutingErr == nil && oldRoutingErr == nil {
+			// records that are not replaced by the new ones have to be
+			// deleted before the new ones can be created
+			common := diffStringSlices(newHostnames, diffStringSlices(newHostnames, oldHostnames))
+			stale := r.withVisibility(staleRecords(common, oldTarget, oldRouting, newTarget, newRouting), oldTarget)
+			if !r.targetIsPrivate(oldTarget) && r.targetIsPrivate(newTarget) {
+				// the records are still needed in the private zones, so
+				// they are only deleted from the public ones
+				for _, h := range common {
+					for _, o := range r.recordsForIngress(h, oldTarget, oldRouting) {
+						if !recordInSlice(o, stale) {
+							stale = append(stale, o)
+						}
+					}
+				}
+			}
+			if len(stale) > 0 {
+				log.Printf("[DEBUG] queued deletion of %d stale record(s) for modified ingress %s", len(stale), newIngress.Name)
+				r.queueRecords(route53.ChangeActionDelete, stale)
+			}
+		}
 		if newTarget == "" {
-			log.Printf("[INFO] invalid ingress target for modified ingress %s: %s", newIngress.Name, newIngress.Labels[r.options.TargetLabelName])
+			log.Printf("[INFO] invalid ingress target for modified ingress %s: %s", newIngress.Name, newIngress.Labels[labelName])
+		} else if newRoutingErr != nil {
+			log.Printf("[INFO] invalid routing annotations for modified ingress %s: %v", newIngress.Name, newRoutingErr)
 		} else if len(newHostnames) == 0 {
 			log.Printf("[INFO] could not extract hostnames from modified ingress %s", newIngress.Name)
 		} else {
 			log.Printf("[DEBUG] queued update of %d record(s) for modified ingress %s, pointing to %s", len(newHostnames), newIngress.Name, newTarget)
-			r.queueUpdates(route53.ChangeActionUpsert, newHostnames, newTarget)
+			r.queueUpdates(route53.ChangeActionUpsert, newHostnames, newTarget, newRouting)
 		}
 		if oldTarget == "" {
-			log.Printf("[INFO] invalid ingress target for previous ingress %s: %s", oldIngress.Name, oldIngress.Labels[r.options.TargetLabelName])
+			log.Printf("[INFO] invalid ingress target for previous ingress %s: %s", oldIngress.Name, oldIngress.Labels[labelName])
+		} else if oldRoutingErr != nil {
+			log.Printf("[INFO] invalid routing annotations for previous ingress %s: %v", oldIngress.Name, oldRoutingErr)
 		} else if len(diffHostnames) == 0 {
 			log.Printf("[DEBUG] no difference in hostnames from previous ingress %s", oldIngress.Name)
 		} else {
 			log.Printf("[DEBUG] queued deletion of %d record(s) for previous ingress %s", len(diffHostnames), oldIngress.Name)
-			r.queueUpdates(route53.ChangeActionDelete, diffHostnames, oldTarget)
+			r.queueUpdates(route53.ChangeActionDelete, diffHostnames, oldTarget, oldRouting)
 		}
 	case watch.Deleted:
 		log.Printf("[DEBUG] received %s event for %s", eventType, oldIngress.Name)
 		metricUpdatesReceived.WithLabelValues(oldIngress.Name, "delete").Inc()
 		hostnames := getHostnamesFromIngress(oldIngress)
 		target := r.getTargetForIngress(oldIngress)
+		routing, err := routingForIngress(oldIngress)
 		if target == "" {
-			log.Printf("[INFO] invalid ingress target for old ingress %s: %s", oldIngress.Name, oldIngress.Labels[r.options.TargetLabelName])
+			log.Printf("[INFO] invalid ingress target for old ingress %s: %s", oldIngress.Name, oldIngress.Labels[labelName])
+		} else if err != nil {
+			log.Printf("[INFO] invalid routing annotations for old ingress %s: %v", oldIngress.Name, err)
 		} else if len(hostnames) == 0 {
 			log.Printf("[INFO] could not extract hostnames from old ingress %s", oldIngress.Name)
 		} else {
 			log.Printf("[DEBUG] queued deletion of %d record(s) for old ingress %s", len(hostnames), oldIngress.Name)
-			r.queueUpdates(route53.ChangeActionDelete, hostnames, target)
+			r.queueUpdates(route53.ChangeActionDelete, hostnames, target, routing)
 		}
 	default:
 		log.Printf("[DEBUG] received %s event: cannot handle", eventType)
 	}
 }
 
-func (r *registrator) queueUpdates(action string, hostnames []string, target string) {
+func (r *registrator) queueUpdates(action string, hostnames []string, target string, routing routingPolicy) {
 	for _, h := range hostnames {
-		r.updateQueue <- cnameChange{action, cnameRecord{h, target}}
+		r.queueRecords(action, r.recordsForIngress(h, target, routing))
+	}
+}
+
+func (r *registrator) queueRecords(action string, records []dnsRecord) {
+	for _, rec := range records {
+		r.updateQueue <- recordChange{action, rec}
 	}
 }
 
 func (r *registrator) processUpdateQueue() {
-	ret := []cnameChange{}
+	ret := []recordChange{}
 	for {
 		select {
 		case t := <-r.updateQueue:
-			if len(ret) > 0 && ((ret[0].Action == route53.ChangeActionDelete && t.Action != route53.ChangeActionDelete) || (ret[0].Action != route53.ChangeActionDelete && t.Action == route53.ChangeActionDelete)) {
-				r.applyBatch(ret)
-				ret = []cnameChange{}
-			}
-			ret = append(ret, t)
+			ret = r.appendToBatch(ret, t)
 		case <-r.stopChannel:
-			if len(ret) > 0 {
-				r.applyBatch(ret)
-				ret = []cnameChange{}
+			log.Println("[INFO] draining update queue ...")
+			for {
+				select {
+				case t := <-r.updateQueue:
+					ret = r.appendToBatch(ret, t)
+				default:
+					if len(ret) > 0 {
+						r.applyBatch(ret)
+					}
+					log.Println("[INFO] update queue drained")
+					return
+				}
 			}
-			return
 		default:
 			if len(ret) > 0 {
 				r.applyBatch(ret)
-				ret = []cnameChange{}
+				ret = []recordChange{}
 			}
 			time.Sleep(100 * time.Millisecond)
 		}
 	}
 }
 
-func (r *registrator) applyBatch(changes []cnameChange) {
+// appendToBatch appends the change to the batch, applying the batch first if
+// the change cannot be part of it.
+func (r *registrator) appendToBatch(batch []recordChange, c recordChange) []recordChange {
+	if len(batch) > 0 && batch[0].Action != c.Action {
+		r.applyBatch(batch)
+		batch = []recordChange{}
+	}
+	return append(batch, c)
+}
+
+func (r *registrator) applyBatch(changes []recordChange) (int, error) {
 	action := changes[0].Action
-	records := make([]cnameRecord, len(changes))
+	records := make([]dnsRecord, len(changes))
 	for i, c := range changes {
 		records[i] = c.Record
 	}
-	pruned := r.pruneBatch(action, records)
-	if len(pruned) == 0 {
-		return
+	zones := r.getZones()
+	for _, rec := range records {
+		if !recordHasZone(zones, rec) {
+			metricUpdatesRejected.Inc()
+			log.Printf("[INFO] cannot handle dns record %s, will ignore it", rec.Hostname)
+		}
+	}
+	zoneAction := action
+	if action == actionPrune {
+		zoneAction = route53.ChangeActionDelete
+	}
+	applied := 0
+	var retErr error
+	ctx := r.zonesContext()
+	clusterID := r.getOptions().ClusterID
+	if !r.capabilities().TXT {
+		clusterID = ""
+	}
+	// public and private zones with the same name are pruned separately, as
+	// their records may differ
+	for _, z := range zones {
+		zoneRecords := r.pruneBatch(z, action, visibleRecords(z, records))
+		if len(zoneRecords) == 0 {
+			continue
+		}
+		for _, batch := range splitBatch(zoneAction, zoneRecords, clusterID != "") {
+			changes := batch
+			if clusterID != "" {
+				changes = r.withOwnerRecords(zoneAction, batch, clusterID)
+			}
+			if err := applyZoneBatch(ctx, z, zoneAction, changes); err != nil {
+				retErr = err
+				continue
+			}
+			applied += len(batch)
+			// zones that cannot report when changes are in sync are
+			// verified straight away
+			if _, ok := z.(syncNotifier); !ok && r.getOptions().VerifyPropagation && zoneAction != route53.ChangeActionDelete && !*dryRun {
+				go r.verifyRecords(r.context(), z, batch)
+			}
+		}
+	}
+	return applied, retErr
+}
+
+// splitBatch splits the records in batches of up to maxBatchRecords values,
+// counting those of upserts twice as route53 does, and the owner record of
+// each record if withOwners is set, so that records and their owner records
+// are always submitted together.
+func splitBatch(action string, records []dnsRecord, withOwners bool) [][]dnsRecord {
+	ret := [][]dnsRecord{}
+	batch := []dnsRecord{}
+	size := 0
+	for _, rec := range records {
+		n := len(rec.Values)
+		if withOwners {
+			n++
+		}
+		if action != route53.ChangeActionDelete {
+			n *= 2
+		}
+		if len(batch) > 0 && size+n > maxBatchRecords {
+			ret = append(ret, batch)
+			batch = []dnsRecord{}
+			size = 0
+		}
+		batch = append(batch, rec)
+		size += n
+	}
+	if len(batch) > 0 {
+		ret = append(ret, batch)
 	}
-	hostnames := make([]string, len(pruned))
-	for i, p := range pruned {
+	return ret
+}
+
+func applyZoneBatch(ctx context.Context, z dnsZone, action string, records []dnsRecord) error {
+	hostnames := make([]string, len(records))
+	for i, p := range records {
 		hostnames[i] = p.Hostname
 	}
 	if action == route53.ChangeActionDelete {
-		log.Printf("[INFO] deleting %d record(s): %+v", len(pruned), hostnames)
+		log.Printf("[INFO] deleting %d record(s): %+v", len(records), hostnames)
 		if !*dryRun {
-			if err := r.DeleteCnames(pruned); err != nil {
+			if err := z.DeleteRecords(ctx, records); err != nil {
 				log.Printf("[ERROR] error deleting records: %+v", err)
-			} else {
-				log.Printf("[INFO] records were deleted")
-				for _, p := range pruned {
-					metricUpdatesApplied.WithLabelValues(p.Hostname, "delete").Inc()
-				}
+				return err
+			}
+			log.Printf("[INFO] records were deleted")
+			for _, p := range records {
+				metricUpdatesApplied.WithLabelValues(p.Hostname, "delete").Inc()
 			}
 		}
 	} else {
-		log.Printf("[INFO] modifying %d record(s): %+v", len(pruned), hostnames)
+		log.Printf("[INFO] modifying %d record(s): %+v", len(records), hostnames)
 		if !*dryRun {
-			if err := r.UpsertCnames(pruned); err != nil {
+			if err := z.UpsertRecords(ctx, records); err != nil {
 				log.Printf("[ERROR] error modifying records: %+v", err)
-			} else {
-				log.Printf("[INFO] records were modified")
-				for _, p := range pruned {
-					metricUpdatesApplied.WithLabelValues(p.Hostname, "upsert").Inc()
-				}
+				return err
+			}
+			log.Printf("[INFO] records were modified")
+			for _, p := range records {
+				metricUpdatesApplied.WithLabelValues(p.Hostname, "upsert").Inc()
 			}
 		}
 	}
+	return nil
 }
 
 func (r *registrator) getTargetForIngress(ingress *v1beta1.Ingress) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 	for _, sat := range r.sats {
 		if sat.Selector.Matches(labels.Set(ingress.Labels)) {
 			return sat.Target
@@ -279,18 +1062,67 @@ func (r *registrator) getTargetForIngress(ingress *v1beta1.Ingress) string {
 	return ""
 }
 
-func (r *registrator) pruneBatch(action string, records []cnameRecord) []cnameRecord {
-	pruned := []cnameRecord{}
+// pruneBatch returns the records of the zone that need to be changed. Records
+// being pruned are deleted even if they no longer match the hostname filters.
+func (r *registrator) pruneBatch(z dnsZone, action string, records []dnsRecord) []dnsRecord {
+	options := r.getOptions()
+	capabilities := r.capabilities()
+	prune := action == actionPrune
+	if prune {
+		action = route53.ChangeActionDelete
+	}
+	pruned := []dnsRecord{}
 	for _, u := range records {
-		if !r.canHandleRecord(u.Hostname) {
+		if !prune && !hostnameMatchesFilters(u.Hostname, options.HostnameFilters) {
+			metricUpdatesRejected.Inc()
+			log.Printf("[INFO] dns record %s does not match the hostname filters, will ignore it", u.Hostname)
+			continue
+		}
+		if !capabilities.supportsRouting(u.Routing) {
 			metricUpdatesRejected.Inc()
-			log.Printf("[INFO] cannot handle dns record %s, will ignore it", u.Hostname)
+			log.Printf("[INFO] dns provider %s does not support %s routing, will ignore record %s", options.Provider, u.Routing.kind(), u.Hostname)
 			continue
 		}
-		t, err := resolveCname(fmt.Sprintf("%s.", strings.Trim(u.Hostname, ".")), r.ListNameservers())
+		if action == route53.ChangeActionDelete && options.Policy == policyUpsertOnly {
+			log.Printf("[DEBUG] will not delete record %s because of the %s policy", u.Hostname, options.Policy)
+			continue
+		}
+		if action == route53.ChangeActionDelete && options.ClusterID != "" && !capabilities.TXT {
+			log.Printf("[DEBUG] will not delete record %s because its owner cannot be recorded with dns provider %s", u.Hostname, options.Provider)
+			continue
+		}
+		if options.ClusterID != "" && capabilities.TXT {
+			owner, err := recordOwner(z, u, options)
+			if err != nil {
+				log.Printf("[INFO] could not look up the owner of record %s, will skip it: %+v", u.Hostname, err)
+				continue
+			}
+			if owner != options.ClusterID {
+				if action == route53.ChangeActionDelete {
+					log.Printf("[DEBUG] will not delete record %s because it's not owned by this cluster", u.Hostname)
+					continue
+				}
+				if owner != "" && !r.takesOwnership(u, owner) {
+					metricUpdatesRejected.Inc()
+					log.Printf("[INFO] will not update record %s because it's owned by cluster %s", u.Hostname, owner)
+					continue
+				}
+				if owner == "" && !options.AdoptUnowned && recordExists(z, u, options) {
+					metricUpdatesRejected.Inc()
+					log.Printf("[INFO] will not update record %s because it has no owner, set -adopt-unowned-records to take it over", u.Hostname)
+					continue
+				}
+				// the record is upserted even if it's up to date, so that
+				// the owner record is written
+				log.Printf("[INFO] taking ownership of record %s", u.Hostname)
+				pruned = append(pruned, u)
+				continue
+			}
+		}
+		values, err := currentValues(z, u, options)
 		switch action {
 		case route53.ChangeActionDelete:
-			o := r.ingressWatcher.HostnameOwners(u.Hostname)
+			o := r.recordOwners(z, u, prune)
 			if len(o) > 0 {
 				log.Printf("[DEBUG] will not delete record %s because it's still claimed by: %s", u.Hostname, strings.Join(o, ","))
 			} else if err == nil {
@@ -302,10 +1134,13 @@ func (r *registrator) pruneBatch(action string, records []cnameRecord) []cnameRe
 				log.Printf("[DEBUG] %s does not resolve, no-op", u.Hostname)
 			}
 		case route53.ChangeActionUpsert:
-			if err != nil {
+			if err == errDNSInconsistentAnswers {
+				log.Printf("[DEBUG] nameservers disagree on %s, will update the record", u.Hostname)
+				pruned = append(pruned, u)
+			} else if err != nil {
 				log.Printf("[DEBUG] error resolving %s: %+v, will try to update the record", u.Hostname, err)
 				pruned = append(pruned, u)
-			} else if strings.Trim(t, ".") != u.Target {
+			} else if !valuesMatch(values, u.Values) {
 				pruned = append(pruned, u)
 			} else {
 				log.Printf("[DEBUG] %s resolves correctly, no-op", u.Hostname)
@@ -316,8 +1151,119 @@ func (r *registrator) pruneBatch(action string, records []cnameRecord) []cnameRe
 	return pruned
 }
 
+// currentValues returns the current values of the record, either by looking
+// up the records of the zone or by querying its nameservers, depending on the
+// prune source. errDNSEmptyAnswer is returned if there is no such record.
+func currentValues(z dnsZone, record dnsRecord, options registratorOptions) ([]string, error) {
+	rl, ok := z.(recordLister)
+	if !ok || (options.PruneSource != pruneSourceProvider && !listsPrivateZone(z, options)) {
+		if record.Routing.SetIdentifier != "" {
+			return nil, errDNSRoutingPolicy
+		}
+		return resolveRecord(fmt.Sprintf("%s.", strings.Trim(record.Hostname, ".")), record.Type, zoneNameservers(z, options))
+	}
+	records, err := rl.LookupRecords(record.Hostname)
+	if err != nil {
+		return nil, err
+	}
+	for _, rec := range records {
+		if rec.Type != record.Type || rec.Routing.SetIdentifier != record.Routing.SetIdentifier || len(rec.Values) == 0 {
+			continue
+		}
+		if rec.TTL != options.RecordTTL {
+			return rec.Values, errRecordTTLMismatch
+		}
+		if !record.Routing.matches(rec.Routing) {
+			return rec.Values, errRecordRoutingMismatch
+		}
+		return rec.Values, nil
+	}
+	return nil, errDNSEmptyAnswer
+}
+
+// recordOwners returns the names of the ingresses that claim the hostname of
+// the record and point to a target that needs a record of its type and set
+// identifier in the zone. Ingresses with an invalid target or routing are
+// assumed to need it, unless only the ingresses that are allowed by the
+// current options count, eg. when pruning the records that are no longer
+// wanted.
+func (r *registrator) recordOwners(z dnsZone, record dnsRecord, allowedOnly bool) []string {
+	filters := r.getOptions().HostnameFilters
+	owners := []string{}
+	for _, i := range r.ingressWatcher.HostnameIngresses(record.Hostname) {
+		if allowedOnly && (!r.namespaceAllowed(i) || !hostnameMatchesFilters(record.Hostname, filters)) {
+			continue
+		}
+		target := r.getTargetForIngress(i)
+		routing, err := routingForIngress(i)
+		if target == "" || err != nil {
+			if !allowedOnly {
+				owners = append(owners, i.Name)
+			}
+			continue
+		}
+		if recordInSlice(record, visibleRecords(z, r.recordsForIngress(record.Hostname, target, routing))) {
+			owners = append(owners, i.Name)
+		}
+	}
+	return owners
+}
+
+// capabilities returns the capabilities of the dns provider. Until the
+// registrator is set up there is no provider, and all the capabilities are
+// assumed.
+func (r *registrator) capabilities() providerCapabilities {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.provider == nil {
+		return fullCapabilities
+	}
+	return r.provider.Capabilities()
+}
+
 func (r *registrator) canHandleRecord(record string) bool {
-	zone := strings.Trim(r.Domain(), ".")
+	return r.zoneForRecord(record) != nil
+}
+
+// recordHasZone returns true if the record belongs in any of the zones.
+func recordHasZone(zones []dnsZone, record dnsRecord) bool {
+	for _, z := range zones {
+		if zoneAcceptsRecord(z, record) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *registrator) zoneForRecord(record string) dnsZone {
+	for _, z := range r.getZones() {
+		if zoneCanHandleRecord(z, record) {
+			return z
+		}
+	}
+	return nil
+}
+
+func (r *registrator) namespaceAllowed(ingress *v1beta1.Ingress) bool {
+	namespaces := r.getOptions().Namespaces
+	return len(namespaces) == 0 || stringInSlice(ingress.Namespace, namespaces)
+}
+
+func hostnameMatchesFilters(hostname string, filters []string) bool {
+	if len(filters) == 0 {
+		return true
+	}
+	hostname = strings.Trim(hostname, ".")
+	for _, f := range filters {
+		if matches, _ := path.Match(f, hostname); matches {
+			return true
+		}
+	}
+	return false
+}
+
+func zoneCanHandleRecord(z dnsZone, record string) bool {
+	zone := strings.Trim(z.Domain(), ".")
 	record = strings.Trim(record, ".")
 	matches, err := regexp.MatchString(fmt.Sprintf("^[^.]+\\.%s$", strings.Replace(zone, ".", "\\.", -1)), record)
 	if err != nil {
@@ -327,26 +1273,22 @@ func (r *registrator) canHandleRecord(record string) bool {
 	return matches
 }
 
-func resolveCname(name string, nameservers []string) (string, error) {
-	m := dns.Msg{}
-	m.SetQuestion(name, dns.TypeCNAME)
-	var retError error
-	var retTarget string
-	for _, nameserver := range nameservers {
-		r, _, err := dnsClient.Exchange(&m, nameserver)
-		if err != nil {
-			retError = err
-			continue
+// diffTargetAliases returns the aliases that were added, removed or that map to
+// a different target.
+func diffTargetAliases(a map[string]string, b map[string]string) []string {
+	ret := []string{}
+	for alias, target := range a {
+		if t, ok := b[alias]; !ok || t != target {
+			ret = append(ret, alias)
 		}
-		if len(r.Answer) == 0 {
-			retError = errDNSEmptyAnswer
-			continue
+	}
+	for alias := range b {
+		if _, ok := a[alias]; !ok {
+			ret = append(ret, alias)
 		}
-		retTarget = r.Answer[0].(*dns.CNAME).Target
-		retError = nil
-		break
 	}
-	return retTarget, retError
+	sort.Strings(ret)
+	return ret
 }
 
 func diffStringSlices(a []string, b []string) []string {
@@ -366,28 +1308,36 @@ func diffStringSlices(a []string, b []string) []string {
 	return ret
 }
 
-func uniqueRecords(records []cnameRecord) []cnameRecord {
-	uniqueRecords := []cnameRecord{}
+func uniqueRecords(records []dnsRecord) []dnsRecord {
+	uniqueRecords := []dnsRecord{}
 	rejectedRecords := []string{}
 	for i, r1 := range records {
-		if stringInSlice(r1.Hostname, rejectedRecords) || recordHostnameInSlice(r1.Hostname, uniqueRecords) {
+		if stringInSlice(r1.Hostname, rejectedRecords) || recordInSlice(r1, uniqueRecords) {
 			continue
 		}
-		duplicates := []cnameRecord{}
+		conflict := false
 		for j, r2 := range records {
-			if i != j && r1.Hostname == r2.Hostname {
-				duplicates = append(duplicates, r2)
+			if i != j && recordsConflict(r1, r2) {
+				conflict = true
+				break
 			}
 		}
-		if recordTargetsAllMatch(r1.Target, duplicates) {
-			uniqueRecords = append(uniqueRecords, r1)
-		} else {
+		if conflict {
 			rejectedRecords = append(rejectedRecords, r1.Hostname)
+		} else {
+			uniqueRecords = append(uniqueRecords, r1)
 		}
 	}
 	if len(rejectedRecords) > 0 {
 		metricUpdatesRejected.Add(float64(len(rejectedRecords)))
 		log.Printf("[INFO] refusing to modify the following records: [%s]: they are claimed by multiple ingresses but are pointing to different targets", strings.Join(rejectedRecords, ", "))
+		ret := []dnsRecord{}
+		for _, u := range uniqueRecords {
+			if !stringInSlice(u.Hostname, rejectedRecords) {
+				ret = append(ret, u)
+			}
+		}
+		uniqueRecords = ret
 	}
 	return uniqueRecords
 }
@@ -401,18 +1351,53 @@ func stringInSlice(s string, slice []string) bool {
 	return false
 }
 
-func recordHostnameInSlice(h string, records []cnameRecord) bool {
+// recordInSlice returns true if there is a record with the same hostname, type
+// and set identifier in the slice.
+func recordInSlice(r dnsRecord, records []dnsRecord) bool {
 	for _, x := range records {
-		if h == x.Hostname {
+		if r.Hostname == x.Hostname && r.Type == x.Type && r.Routing.SetIdentifier == x.Routing.SetIdentifier {
 			return true
 		}
 	}
 	return false
 }
 
-func recordTargetsAllMatch(target string, records []cnameRecord) bool {
-	for _, r := range records {
-		if target != r.Target {
+// recordsConflict returns true if both records are for the same hostname but
+// cannot both be applied: one of them is a CNAME record and the other is not,
+// they have different kinds of routing policies or the same geolocation, or
+// they have the same set identifier and different values or routing.
+func recordsConflict(a dnsRecord, b dnsRecord) bool {
+	if a.Hostname != b.Hostname {
+		return false
+	}
+	if a.Type != b.Type {
+		return a.Type == route53.RRTypeCname || b.Type == route53.RRTypeCname
+	}
+	if a.Routing.SetIdentifier != b.Routing.SetIdentifier {
+		if a.Routing.kind() != b.Routing.kind() {
+			return true
+		}
+		return a.Routing.kind() == routingGeolocation && a.Routing.GeoContinent == b.Routing.GeoContinent && a.Routing.GeoCountry == b.Routing.GeoCountry
+	}
+	return !valuesMatch(a.Values, b.Values) || a.Routing != b.Routing
+}
+
+// valuesMatch returns true if both lists have the same values, regardless of
+// their order and trailing dots.
+func valuesMatch(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	na := make([]string, len(a))
+	nb := make([]string, len(b))
+	for i := range a {
+		na[i] = strings.Trim(a[i], ".")
+		nb[i] = strings.Trim(b[i], ".")
+	}
+	sort.Strings(na)
+	sort.Strings(nb)
+	for i := range na {
+		if na[i] != nb[i] {
 			return false
 		}
 	}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"reflect"
@@ -9,6 +10,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/miekg/dns"
 
 	"k8s.io/api/extensions/v1beta1"
@@ -21,6 +23,7 @@ const (
 	testPrivateTarget   string = "private.cluster-entrypoint.com"
 	testPublicTarget    string = "public.cluster-entrypoint.com"
 	testTargetLabelName string = "ingress53.target"
+	testAlias           string = "private"
 )
 
 func TestNewRegistrator_defaults(t *testing.T) {
@@ -48,7 +51,7 @@ func TestNewRegistrator_defaults(t *testing.T) {
 	}
 
 	// working
-	_, err = newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, Route53ZoneID: "c"})
+	_, err = newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"c"}})
 	if err != nil {
 		t.Errorf("newRegistrator returned an unexpected error: %+v", err)
 	}
@@ -56,7 +59,7 @@ func TestNewRegistrator_defaults(t *testing.T) {
 
 func TestRegistrator_GetTargetForIngress(t *testing.T) {
 	// ingress ab
-	r, err := newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, Route53ZoneID: "c"})
+	r, err := newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"c"}})
 	if err != nil {
 		t.Errorf("newRegistrator returned an unexpected error: %+v", err)
 	}
@@ -67,7 +70,7 @@ func TestRegistrator_GetTargetForIngress(t *testing.T) {
 	}
 
 	// ingress c
-	r, err = newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, Route53ZoneID: "c"})
+	r, err = newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"c"}})
 	if err != nil {
 		t.Errorf("newRegistrator returned an unexpected error: %+v", err)
 	}
@@ -77,7 +80,7 @@ func TestRegistrator_GetTargetForIngress(t *testing.T) {
 	}
 
 	// ingress target not registered with ingress53
-	r, err = newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, Route53ZoneID: "c"})
+	r, err = newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"c"}})
 	if err != nil {
 		t.Errorf("newRegistrator returned an unexpected error: %+v", err)
 	}
@@ -93,14 +96,14 @@ type mockDNSZone struct {
 	nameservers []string
 }
 
-func (m *mockDNSZone) UpsertCnames(records []cnameRecord) error {
+func (m *mockDNSZone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
 	for _, r := range records {
-		m.zoneData[r.Hostname] = r.Target
+		m.zoneData[r.Hostname] = r.Values[0]
 	}
 	return nil
 }
 
-func (m *mockDNSZone) DeleteCnames(records []cnameRecord) error {
+func (m *mockDNSZone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
 	for _, r := range records {
 		delete(m.zoneData, r.Hostname)
 	}
@@ -159,6 +162,15 @@ func (m *mockDNSZone) startMockDNSServer() (*dns.Server, error) {
 	return server, nil
 }
 
+type mockListingDNSZone struct {
+	*mockDNSZone
+	records *recordCache
+}
+
+func (m *mockListingDNSZone) LookupRecords(name string) ([]zoneRecord, error) {
+	return m.records.Lookup(name)
+}
+
 type mockEvent struct {
 	et  watch.EventType
 	old *v1beta1.Ingress
@@ -196,9 +208,9 @@ func TestRegistratorHandler(t *testing.T) {
 	}
 
 	r := &registrator{
-		dnsZone:     mdz,
+		zones:       []dnsZone{mdz},
 		sats:        sats,
-		updateQueue: make(chan cnameChange, 16),
+		updateQueue: make(chan recordChange, 16),
 		ingressWatcher: &ingressWatcher{
 			stopChannel: make(chan struct{}),
 			store:       &mockStore{},
@@ -206,7 +218,7 @@ func TestRegistratorHandler(t *testing.T) {
 		options: registratorOptions{
 			Targets:         []string{testPrivateTarget, testPublicTarget},
 			TargetLabelName: testTargetLabelName,
-			Route53ZoneID:   "c",
+			ZoneIDs:         []string{"c"},
 		},
 	}
 
@@ -334,7 +346,7 @@ func TestRegistratorHandler(t *testing.T) {
 		r.ingressWatcher.store = &mockStore{items: test.storeIngresses}
 		mdz.domain = test.domain
 		mdz.zoneData = map[string]string{}
-		r.updateQueue = make(chan cnameChange, 16)
+		r.updateQueue = make(chan recordChange, 16)
 		for _, e := range test.events {
 			r.handler(e.et, e.old, e.new)
 		}
@@ -353,6 +365,501 @@ func TestRegistratorHandler(t *testing.T) {
 	}
 }
 
+func TestRegistrator_reconcile(t *testing.T) {
+	mdz := &mockDNSZone{domain: "example.com.", zoneData: map[string]string{"c.example.com": testPrivateTarget}}
+	server, err := mdz.startMockDNSServer()
+	defer server.Shutdown()
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	r, err := newRegistratorWithOptions(registratorOptions{KubernetesConfig: &rest.Config{}, Targets: []string{testPrivateTarget, testPublicTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"c"}})
+	if err != nil {
+		t.Fatalf("newRegistrator returned an unexpected error: %+v", err)
+	}
+	r.zones = []dnsZone{mdz}
+
+	s, err := r.reconcile([]*v1beta1.Ingress{privateIngressHostsAB, publicIngressHostC, nonRegisteredIngress})
+	if err != nil {
+		t.Fatalf("reconcile returned an unexpected error: %+v", err)
+	}
+	expectedSummary := syncSummary{Ingresses: 3, Records: 3, Changed: 3}
+	if s != expectedSummary {
+		t.Errorf("reconcile returned unexpected summary: %+v, expected: %+v", s, expectedSummary)
+	}
+	expectedData := map[string]string{
+		"a.example.com": testPrivateTarget,
+		"b.example.com": testPrivateTarget,
+		"c.example.com": testPublicTarget,
+	}
+	if !reflect.DeepEqual(mdz.zoneData, expectedData) {
+		t.Errorf("reconcile produced unexcepted zone data: %+v, expected: %+v", mdz.zoneData, expectedData)
+	}
+
+	// second run should be a no-op
+	s, err = r.reconcile([]*v1beta1.Ingress{privateIngressHostsAB, publicIngressHostC})
+	if err != nil {
+		t.Fatalf("reconcile returned an unexpected error: %+v", err)
+	}
+	expectedSummary = syncSummary{Ingresses: 2, Records: 3, Changed: 0}
+	if s != expectedSummary {
+		t.Errorf("reconcile returned unexpected summary: %+v, expected: %+v", s, expectedSummary)
+	}
+}
+
+func TestRegistrator_applyBatch_split(t *testing.T) {
+	api := newFakeRoute53()
+	id := api.addZone("example.com", false)
+	z, err := newRoute53Zone(context.Background(), id, api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+	z.records.Replace(nil)
+	r := &registrator{
+		zones:          []dnsZone{z},
+		ingressWatcher: &ingressWatcher{store: &mockStore{}},
+		options:        registratorOptions{PruneSource: pruneSourceProvider, RecordTTL: 60, ClusterID: "one"},
+	}
+
+	// upserts count twice, along with their owner records, so this is well
+	// over what route53 accepts in a single batch
+	upserts := []recordChange{}
+	deletes := []recordChange{}
+	for i := 0; i < fakeRoute53MaxBatchRecords/2; i++ {
+		rec := newCnameRecord(fmt.Sprintf("%d.example.com", i), testPublicTarget)
+		upserts = append(upserts, recordChange{route53.ChangeActionUpsert, rec})
+		deletes = append(deletes, recordChange{route53.ChangeActionDelete, rec})
+	}
+	if applied, err := r.applyBatch(upserts); applied != len(upserts) || err != nil {
+		t.Errorf("applyBatch returned unexpected result: %d, %+v", applied, err)
+	}
+	if rrs := fakeRecordSetsWithout(api.recordSets(id), route53.RRTypeNs, route53.RRTypeSoa); len(rrs) != 2*len(upserts) {
+		t.Errorf("applyBatch did not write all the records and their owners: %d", len(rrs))
+	}
+	if applied, err := r.applyBatch(deletes); applied != len(deletes) || err != nil {
+		t.Errorf("applyBatch returned unexpected result: %d, %+v", applied, err)
+	}
+	if rrs := fakeRecordSetsWithout(api.recordSets(id), route53.RRTypeNs, route53.RRTypeSoa); len(rrs) != 0 {
+		t.Errorf("applyBatch did not delete all the records and their owners: %d", len(rrs))
+	}
+}
+
+func TestSplitBatch(t *testing.T) {
+	records := []dnsRecord{
+		{Hostname: "a.example.com", Type: route53.RRTypeA, Values: make([]string, 300)},
+		{Hostname: "b.example.com", Type: route53.RRTypeA, Values: make([]string, 200)},
+		{Hostname: "c.example.com", Type: route53.RRTypeA, Values: make([]string, 499)},
+	}
+	testCases := []struct {
+		action     string
+		withOwners bool
+		expected   []int
+	}{
+		{route53.ChangeActionDelete, false, []int{3}},
+		{route53.ChangeActionDelete, true, []int{2, 1}},
+		{route53.ChangeActionUpsert, false, []int{2, 1}},
+		{route53.ChangeActionUpsert, true, []int{1, 1, 1}},
+	}
+	for i, tc := range testCases {
+		sizes := []int{}
+		for _, b := range splitBatch(tc.action, records, tc.withOwners) {
+			sizes = append(sizes, len(b))
+		}
+		if !reflect.DeepEqual(sizes, tc.expected) {
+			t.Errorf("splitBatch returned unexpected batches for test case #%02d: %v", i, sizes)
+		}
+	}
+}
+
+func TestRegistrator_processUpdateQueue_drain(t *testing.T) {
+	mdz := &mockDNSZone{domain: "example.com.", zoneData: map[string]string{}}
+	server, err := mdz.startMockDNSServer()
+	defer server.Shutdown()
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	sats, _ := buildSelectors(testTargetLabelName, []string{testPrivateTarget, testPublicTarget}, nil)
+	r := &registrator{
+		zones:          []dnsZone{mdz},
+		sats:           sats,
+		updateQueue:    make(chan recordChange, 16),
+		ingressWatcher: &ingressWatcher{stopChannel: make(chan struct{}), store: &mockStore{}},
+		options: registratorOptions{
+			Targets:         []string{testPrivateTarget, testPublicTarget},
+			TargetLabelName: testTargetLabelName,
+			ZoneIDs:         []string{"c"},
+		},
+	}
+	r.handler(watch.Added, nil, privateIngressHostsAB)
+	r.handler(watch.Added, nil, publicIngressHostC)
+	r.handler(watch.Deleted, privateIngressHostsAB, nil)
+	r.handler(watch.Added, nil, privateIngressHostE)
+	if n := len(r.updateQueue); n != 6 {
+		t.Fatalf("handler queued unexpected number of changes: %d", n)
+	}
+
+	// stop before processing anything, all the queued changes should still be
+	// applied
+	close(r.stopChannel)
+	r.processUpdateQueue()
+
+	expected := map[string]string{
+		"c.example.com": testPublicTarget,
+		"e.example.com": testPrivateTarget,
+	}
+	if !reflect.DeepEqual(mdz.zoneData, expected) {
+		t.Errorf("processUpdateQueue produced unexcepted zone data: %+v, expected: %+v", mdz.zoneData, expected)
+	}
+	if len(r.updateQueue) != 0 {
+		t.Errorf("processUpdateQueue did not drain the update queue")
+	}
+}
+
+func TestRegistrator_Reload_prune(t *testing.T) {
+	mdz := &mockListingDNSZone{
+		mockDNSZone: &mockDNSZone{domain: "example.com.", zoneData: map[string]string{"a.example.com": testPrivateTarget, "b.example.com": testPrivateTarget, "c.example.com": testPublicTarget}},
+		records:     newRecordCache(),
+	}
+	for h, target := range mdz.zoneData {
+		mdz.records.Upsert(zoneRecord{Name: h, Type: route53.RRTypeCname, TTL: 60, Values: []string{target}})
+	}
+	registerProvider("mock", func(registratorOptions) (dnsProvider, error) { return nil, nil })
+	defer delete(providers, "mock")
+
+	options := registratorOptions{
+		Targets:         []string{testPrivateTarget, testPublicTarget},
+		TargetLabelName: testTargetLabelName,
+		ZoneIDs:         []string{"z"},
+		Provider:        "mock",
+		PruneSource:     pruneSourceProvider,
+	}
+	if err := validateOptions(&options); err != nil {
+		t.Fatalf("validateOptions returned unexpected error: %+v", err)
+	}
+	sats, _ := buildSelectors(testTargetLabelName, options.Targets, nil)
+	r := &registrator{
+		ctx:            context.Background(),
+		zones:          []dnsZone{mdz},
+		sats:           sats,
+		options:        options,
+		provider:       &mockProvider{capabilities: fullCapabilities, zones: map[string]dnsZone{"z": mdz}},
+		updateQueue:    make(chan recordChange, 16),
+		ingressWatcher: &ingressWatcher{stopChannel: make(chan struct{}), store: &mockStore{items: []interface{}{privateIngressHostsAB, publicIngressHostC}}},
+	}
+
+	// narrowing the hostname filters deletes the records that no longer
+	// match them
+	options.HostnameFilters = []string{"a.example.com", "c.example.com"}
+	if err := r.Reload(options); err != nil {
+		t.Fatalf("registrator.Reload returned unexpected error: %+v", err)
+	}
+	close(r.stopChannel)
+	r.processUpdateQueue()
+	expected := map[string]string{"a.example.com": testPrivateTarget, "c.example.com": testPublicTarget}
+	if !reflect.DeepEqual(mdz.zoneData, expected) {
+		t.Errorf("registrator.Reload left unexpected zone data: %+v, expected: %+v", mdz.zoneData, expected)
+	}
+
+	// and so does narrowing the namespaces
+	r.ingressWatcher.stopChannel = make(chan struct{})
+	options.Namespaces = []string{"other"}
+	if err := r.Reload(options); err != nil {
+		t.Fatalf("registrator.Reload returned unexpected error: %+v", err)
+	}
+	close(r.stopChannel)
+	r.processUpdateQueue()
+	if len(mdz.zoneData) != 0 {
+		t.Errorf("registrator.Reload left unexpected zone data: %+v", mdz.zoneData)
+	}
+
+	// the provider options are only read on start
+	options.Etcd.Prefix = "/coredns"
+	if err := r.Reload(options); err != errRegistratorProviderOptions {
+		t.Errorf("registrator.Reload returned unexpected error for changed provider options: %+v", err)
+	}
+}
+
+func TestRegistrator_waitForDrain(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &registrator{ctx: ctx, cancel: cancel, options: registratorOptions{DrainTimeout: 10 * time.Millisecond}}
+
+	drained := make(chan struct{})
+	if err := r.waitForDrain(drained); err != errRegistratorDrainTimedOut {
+		t.Errorf("waitForDrain returned unexpected error: %+v", err)
+	}
+	if r.ctx.Err() != context.Canceled {
+		t.Errorf("waitForDrain did not cancel the registrator context")
+	}
+
+	close(drained)
+	if err := r.waitForDrain(drained); err != nil {
+		t.Errorf("waitForDrain returned unexpected error: %+v", err)
+	}
+}
+
+func TestRegistrator_pruneBatch_policyAndFilters(t *testing.T) {
+	mdz := &mockDNSZone{domain: "example.com.", zoneData: map[string]string{"a.example.com": testPrivateTarget}}
+	server, err := mdz.startMockDNSServer()
+	defer server.Shutdown()
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	r := &registrator{
+		zones:          []dnsZone{mdz},
+		ingressWatcher: &ingressWatcher{store: &mockStore{}},
+		options: registratorOptions{
+			Policy:          policyUpsertOnly,
+			HostnameFilters: []string{"a.*", "b.example.com"},
+		},
+	}
+	records := []dnsRecord{
+		newCnameRecord("a.example.com", testPublicTarget),
+		newCnameRecord("b.example.com", testPublicTarget),
+		newCnameRecord("c.example.com", testPublicTarget),
+	}
+
+	pruned := r.pruneBatch(mdz, route53.ChangeActionUpsert, records)
+	if !reflect.DeepEqual(pruned, records[:2]) {
+		t.Errorf("pruneBatch returned unexpected records for upsert: %+v", pruned)
+	}
+
+	pruned = r.pruneBatch(mdz, route53.ChangeActionDelete, records)
+	if len(pruned) != 0 {
+		t.Errorf("pruneBatch returned unexpected records for delete: %+v", pruned)
+	}
+}
+
+func TestValidateOptions(t *testing.T) {
+	testCases := []struct {
+		options registratorOptions
+		err     bool
+	}{
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a", "b"}}, false},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a", ""}}, true},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, Policy: policyUpsertOnly}, false},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, Policy: "delete-all"}, true},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, HostnameFilters: []string{"["}}, true},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, ClusterID: "eu-west-1.prod"}, false},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, ClusterID: "eu west"}, true},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, PruneSource: pruneSourceProvider}, false},
+		{registratorOptions{Targets: []string{testPrivateTarget}, TargetLabelName: testTargetLabelName, ZoneIDs: []string{"a"}, PruneSource: "ldap"}, true},
+	}
+
+	for i, tc := range testCases {
+		err := validateOptions(&tc.options)
+		if (err != nil) != tc.err {
+			t.Errorf("validateOptions returned unexpected error for test case #%02d: %+v", i, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if tc.options.Policy == "" || tc.options.RecordTTL == 0 {
+			t.Errorf("validateOptions did not set defaults for test case #%02d: %+v", i, tc.options)
+		}
+	}
+}
+
+func TestRegistrator_setTargetAliases(t *testing.T) {
+	r := &registrator{
+		zones:       []dnsZone{&mockDNSZone{domain: "example.com."}},
+		updateQueue: make(chan recordChange, 16),
+		ingressWatcher: &ingressWatcher{
+			store: &mockStore{items: []interface{}{privateIngressHostsAB, aliasIngressHostF}},
+		},
+		options: registratorOptions{
+			Targets:         []string{testPrivateTarget},
+			TargetLabelName: testTargetLabelName,
+			ZoneIDs:         []string{"z"},
+		},
+	}
+	queued := func() []recordChange {
+		ret := []recordChange{}
+		for {
+			select {
+			case c := <-r.updateQueue:
+				ret = append(ret, c)
+			default:
+				return ret
+			}
+		}
+	}
+
+	testCases := []struct {
+		aliases  map[string]string
+		target   string
+		expected []recordChange
+	}{
+		{
+			map[string]string{testAlias: testPrivateTarget},
+			testPrivateTarget,
+			[]recordChange{{route53.ChangeActionUpsert, newCnameRecord("f.example.com", testPrivateTarget)}},
+		},
+		{
+			map[string]string{testAlias: testPrivateTarget, "other": testPublicTarget},
+			testPrivateTarget,
+			[]recordChange{},
+		},
+		{
+			map[string]string{testAlias: testPublicTarget},
+			testPublicTarget,
+			[]recordChange{{route53.ChangeActionUpsert, newCnameRecord("f.example.com", testPublicTarget)}},
+		},
+		{ // invalid mapping, should be ignored
+			map[string]string{testAlias: ""},
+			testPublicTarget,
+			[]recordChange{},
+		},
+		{ // the CNAME record is replaced by an A record
+			map[string]string{testAlias: "10.0.0.1"},
+			"10.0.0.1",
+			[]recordChange{
+				{actionPrune, newCnameRecord("f.example.com", testPublicTarget)},
+				{route53.ChangeActionUpsert, dnsRecord{Hostname: "f.example.com", Type: route53.RRTypeA, Values: []string{"10.0.0.1"}}},
+			},
+		},
+		{ // the ingress has no target anymore, its record is deleted
+			map[string]string{},
+			"",
+			[]recordChange{{actionPrune, dnsRecord{Hostname: "f.example.com", Type: route53.RRTypeA, Values: []string{"10.0.0.1"}}}},
+		},
+	}
+
+	for i, tc := range testCases {
+		r.setTargetAliases(tc.aliases)
+		if target := r.getTargetForIngress(aliasIngressHostF); target != tc.target {
+			t.Errorf("getTargetForIngress returned unexpected target for test case #%02d: %s", i, target)
+		}
+		if target := r.getTargetForIngress(privateIngressHostsAB); target != testPrivateTarget {
+			t.Errorf("getTargetForIngress returned unexpected target for test case #%02d: %s", i, target)
+		}
+		if q := queued(); !reflect.DeepEqual(q, tc.expected) {
+			t.Errorf("setTargetAliases queued unexpected changes for test case #%02d: %+v", i, q)
+		}
+	}
+}
+
+func TestCurrentValues(t *testing.T) {
+	z := &mockListingDNSZone{mockDNSZone: &mockDNSZone{domain: "example.com."}, records: newRecordCache()}
+	z.records.Replace([]zoneRecord{
+		{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}},
+		{Name: "b.example.com", Type: "CNAME", TTL: 300, Values: []string{testPrivateTarget}},
+		{Name: "c.example.com", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}},
+		{Name: "w.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}},
+		{Name: "w.example.com", Type: "CNAME", TTL: 60, Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "green", Weight: 90}},
+	})
+	options := registratorOptions{PruneSource: pruneSourceProvider, RecordTTL: 60}
+
+	testCases := []struct {
+		record dnsRecord
+		values []string
+		err    error
+	}{
+		{newCnameRecord("a.example.com", testPrivateTarget), []string{testPrivateTarget}, nil},
+		{newCnameRecord("b.example.com", testPrivateTarget), []string{testPrivateTarget}, errRecordTTLMismatch},
+		{newCnameRecord("c.example.com", testPrivateTarget), nil, errDNSEmptyAnswer},
+		{dnsRecord{Hostname: "c.example.com", Type: "A", Values: []string{"10.0.0.1"}}, []string{"10.0.0.1", "10.0.0.2"}, nil},
+		{dnsRecord{Hostname: "c.example.com", Type: "AAAA", Values: []string{"::1"}}, nil, errDNSEmptyAnswer},
+		{newCnameRecord("d.example.com", testPrivateTarget), nil, errDNSEmptyAnswer},
+		{dnsRecord{Hostname: "w.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}}, []string{testPrivateTarget}, nil},
+		{dnsRecord{Hostname: "w.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "green", Weight: 50}}, []string{testPublicTarget}, errRecordRoutingMismatch},
+		{newCnameRecord("w.example.com", testPrivateTarget), nil, errDNSEmptyAnswer},
+	}
+	for i, tc := range testCases {
+		values, err := currentValues(z, tc.record, options)
+		if !reflect.DeepEqual(values, tc.values) || err != tc.err {
+			t.Errorf("currentValues returned unexpected result for test case #%02d: %+v, %+v", i, values, err)
+		}
+	}
+
+	// weighted records cannot be checked with dns queries
+	options.PruneSource = pruneSourceDNS
+	if _, err := currentValues(z, dnsRecord{Hostname: "w.example.com", Type: "CNAME", Routing: routingPolicy{SetIdentifier: "blue"}}, options); err != errDNSRoutingPolicy {
+		t.Errorf("currentValues returned unexpected error for a weighted record: %+v", err)
+	}
+}
+
+func TestRecordsForTarget(t *testing.T) {
+	testCases := []struct {
+		target   string
+		expected []dnsRecord
+	}{
+		{testPrivateTarget, []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget)}},
+		{"10.0.0.2, 10.0.0.1", []dnsRecord{{Hostname: "a.example.com", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}}}},
+		{"2001:DB8::1", []dnsRecord{{Hostname: "a.example.com", Type: "AAAA", Values: []string{"2001:db8::1"}}}},
+		{"10.0.0.1 2001:db8::1", []dnsRecord{
+			{Hostname: "a.example.com", Type: "A", Values: []string{"10.0.0.1"}},
+			{Hostname: "a.example.com", Type: "AAAA", Values: []string{"2001:db8::1"}},
+		}},
+		{"", []dnsRecord{}},
+	}
+	for i, tc := range testCases {
+		if records := recordsForTarget("a.example.com", tc.target); !reflect.DeepEqual(records, tc.expected) {
+			t.Errorf("recordsForTarget returned unexpected records for test case #%02d: %+v", i, records)
+		}
+	}
+
+	stale := staleRecords([]string{"a.example.com"}, testPrivateTarget, routingPolicy{}, "10.0.0.1 2001:db8::1", routingPolicy{})
+	if !reflect.DeepEqual(stale, []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget)}) {
+		t.Errorf("staleRecords returned unexpected records: %+v", stale)
+	}
+	stale = staleRecords([]string{"a.example.com"}, "10.0.0.1 2001:db8::1", routingPolicy{}, "10.0.0.2", routingPolicy{})
+	if !reflect.DeepEqual(stale, []dnsRecord{{Hostname: "a.example.com", Type: "AAAA", Values: []string{"2001:db8::1"}}}) {
+		t.Errorf("staleRecords returned unexpected records: %+v", stale)
+	}
+	blue := routingPolicy{SetIdentifier: "blue", Weight: 10}
+	stale = staleRecords([]string{"a.example.com"}, testPrivateTarget, routingPolicy{}, testPrivateTarget, blue)
+	if !reflect.DeepEqual(stale, []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget)}) {
+		t.Errorf("staleRecords returned unexpected records: %+v", stale)
+	}
+	stale = staleRecords([]string{"a.example.com"}, testPrivateTarget, blue, testPrivateTarget, routingPolicy{SetIdentifier: "blue", Weight: 20})
+	if len(stale) != 0 {
+		t.Errorf("staleRecords returned unexpected records: %+v", stale)
+	}
+}
+
+func TestUniqueRecords(t *testing.T) {
+	records := []dnsRecord{
+		newCnameRecord("a.example.com", testPrivateTarget),
+		newCnameRecord("a.example.com", testPrivateTarget),
+		newCnameRecord("b.example.com", testPrivateTarget),
+		newCnameRecord("b.example.com", testPublicTarget),
+		{Hostname: "c.example.com", Type: "A", Values: []string{"10.0.0.1"}},
+		{Hostname: "c.example.com", Type: "AAAA", Values: []string{"::1"}},
+		{Hostname: "d.example.com", Type: "A", Values: []string{"10.0.0.1"}},
+		{Hostname: "d.example.com", Type: "AAAA", Values: []string{"::1"}},
+		newCnameRecord("d.example.com", testPrivateTarget),
+		{Hostname: "w.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}},
+		{Hostname: "w.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "green", Weight: 90}},
+		{Hostname: "x.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}},
+		newCnameRecord("x.example.com", testPrivateTarget),
+		{Hostname: "l.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "eu", Region: "eu-west-1"}},
+		{Hostname: "l.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "us", Region: "us-east-1"}},
+		{Hostname: "m.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "eu", Region: "eu-west-1"}},
+		{Hostname: "m.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "gb", GeoCountry: "GB"}},
+		{Hostname: "g.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "eu", GeoContinent: "EU"}},
+		{Hostname: "g.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "default", GeoCountry: "*"}},
+		{Hostname: "h.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", GeoCountry: "GB"}},
+		{Hostname: "h.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "green", GeoCountry: "GB"}},
+	}
+	expected := []dnsRecord{
+		newCnameRecord("a.example.com", testPrivateTarget),
+		{Hostname: "c.example.com", Type: "A", Values: []string{"10.0.0.1"}},
+		{Hostname: "c.example.com", Type: "AAAA", Values: []string{"::1"}},
+		{Hostname: "w.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "blue", Weight: 10}},
+		{Hostname: "w.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "green", Weight: 90}},
+		{Hostname: "l.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "eu", Region: "eu-west-1"}},
+		{Hostname: "l.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "us", Region: "us-east-1"}},
+		{Hostname: "g.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Routing: routingPolicy{SetIdentifier: "eu", GeoContinent: "EU"}},
+		{Hostname: "g.example.com", Type: "CNAME", Values: []string{testPublicTarget}, Routing: routingPolicy{SetIdentifier: "default", GeoCountry: "*"}},
+	}
+	if u := uniqueRecords(records); !reflect.DeepEqual(u, expected) {
+		t.Errorf("uniqueRecords returned unexpected records: %+v", u)
+	}
+}
+
 func TestRegistrator_canHandleRecord(t *testing.T) {
 	testCases := []struct {
 		record   string
@@ -365,7 +872,7 @@ func TestRegistrator_canHandleRecord(t *testing.T) {
 		{"test.example.com.", true},
 	}
 	defer mockRoute53Timers()()
-	r := registrator{dnsZone: &mockDNSZone{domain: "example.com"}}
+	r := registrator{zones: []dnsZone{&mockDNSZone{domain: "example.com"}}}
 
 	for i, tc := range testCases {
 		v := r.canHandleRecord(tc.record)
@@ -493,43 +1000,6 @@ func stopMockDNSServerFleet(servers []*dns.Server) {
 	}
 }
 
-func TestDNSClient_ResolveCname_noServer(t *testing.T) {
-	_, err := resolveCname("example.com.", []string{"127.0.0.1:65111"})
-	if err == nil {
-		t.Fatalf("Client.ResolveA should have returned an error")
-	}
-}
-
-func TestDNSClient_ResolveCname_empty(t *testing.T) {
-	servers, serverAddresses, err := startMockDNSServerFleet(map[string]string{})
-	defer stopMockDNSServerFleet(servers)
-	if err != nil {
-		t.Fatalf("dnstest: unable to run test server: %v", err)
-	}
-
-	_, err = resolveCname("example.com.", serverAddresses)
-	if err != errDNSEmptyAnswer {
-		t.Fatalf("Client.ResolveA should have returned an empty answer error")
-	}
-}
-
-func TestDNSClient_ResolveCname_broken(t *testing.T) {
-	servers, serverAddresses, err := startMockSemiBrokenDNSServerFleet(map[string]string{"example.com.": "target.example.com."})
-	defer stopMockDNSServerFleet(servers)
-	if err != nil {
-		t.Fatalf("dnstest: unable to run test server: %v", err)
-	}
-
-	resp, err := resolveCname("example.com.", serverAddresses)
-	if err != nil {
-		t.Fatalf("Client.ResolveA returned unexpected error: %+v", err)
-	}
-
-	if resp != "target.example.com." {
-		t.Fatalf("Client.ResolveA returned unexpected response")
-	}
-}
-
 func TestDiffStringSlices(t *testing.T) {
 	testCases := []struct {
 		A []string
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+var (
+	errDNSEmptyAnswer         = errors.New("DNS nameserver returned an empty answer")
+	errDNSInconsistentAnswers = errors.New("DNS nameservers returned different answers")
+	errDNSUnsupportedType     = errors.New("unsupported DNS record type")
+	errDNSRoutingPolicy       = errors.New("records with a routing policy cannot be checked with DNS queries")
+	errDNSNoNameservers       = errors.New("no nameservers to query")
+	defaultDNSTimeout         = 2 * time.Second
+	defaultDNSRetries         = 2
+	dnsResolver               = newResolver(defaultDNSTimeout, defaultDNSRetries, true)
+)
+
+// resolver sends DNS queries over UDP, retrying on errors and optionally
+// falling back to TCP when the answer is truncated.
+type resolver struct {
+	udp         *dns.Client
+	tcp         *dns.Client
+	retries     int
+	tcpFallback bool
+}
+
+func newResolver(timeout time.Duration, retries int, tcpFallback bool) *resolver {
+	return &resolver{
+		udp:         &dns.Client{Net: "udp", Timeout: timeout},
+		tcp:         &dns.Client{Net: "tcp", Timeout: timeout},
+		retries:     retries,
+		tcpFallback: tcpFallback,
+	}
+}
+
+// Exchange sends the query to the nameserver and returns its answer.
+func (r *resolver) Exchange(m *dns.Msg, nameserver string) (*dns.Msg, error) {
+	var resp *dns.Msg
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		resp, _, err = r.udp.Exchange(m, nameserver)
+		if err == nil || err == dns.ErrTruncated {
+			break
+		}
+		log.Printf("[DEBUG] query to %s failed (attempt %d): %+v", nameserver, attempt+1, err)
+	}
+	truncated := err == dns.ErrTruncated || (err == nil && resp.Truncated)
+	if truncated && r.tcpFallback {
+		log.Printf("[DEBUG] answer from %s is truncated, retrying over tcp", nameserver)
+		resp, _, err = r.tcp.Exchange(m, nameserver)
+	}
+	return resp, err
+}
+
+// zoneNameservers returns the nameservers to query for the records of the
+// zone: the private resolvers for private zones, otherwise the configured
+// resolvers if there are any, or the nameservers the zone is delegated to.
+func zoneNameservers(z dnsZone, options registratorOptions) []string {
+	if isPrivateZone(z) {
+		return options.PrivateResolvers
+	}
+	if len(options.Resolvers) > 0 {
+		return options.Resolvers
+	}
+	return z.ListNameservers()
+}
+
+type dnsAnswer struct {
+	nameserver string
+	values     []string
+	err        error
+}
+
+// resolveRecord queries all the nameservers concurrently for the records of
+// the type and returns the values they agree on, sorted and without trailing
+// dots. Nameservers that cannot be reached are ignored, unless none can.
+// errDNSEmptyAnswer is returned if none of them has the record and
+// errDNSInconsistentAnswers if they disagree, including when only some of
+// them have it.
+func resolveRecord(name string, rrType string, nameservers []string) ([]string, error) {
+	if len(nameservers) == 0 {
+		return nil, errDNSNoNameservers
+	}
+	answers := make(chan dnsAnswer, len(nameservers))
+	for _, nameserver := range nameservers {
+		go func(nameserver string) {
+			v, err := queryNameserver(name, rrType, nameserver)
+			answers <- dnsAnswer{nameserver: nameserver, values: v, err: err}
+		}(nameserver)
+	}
+	var retError error
+	answered := map[string][]string{}
+	var values []string
+	for range nameservers {
+		a := <-answers
+		if a.err != nil && a.err != errDNSEmptyAnswer {
+			log.Printf("[DEBUG] could not query nameserver %s for %s: %+v", a.nameserver, name, a.err)
+			retError = a.err
+			continue
+		}
+		key := strings.Join(a.values, ",")
+		answered[key] = append(answered[key], a.nameserver)
+		values = a.values
+	}
+	if len(answered) == 0 {
+		return nil, retError
+	}
+	if len(answered) > 1 {
+		metricDNSInconsistentAnswers.WithLabelValues(strings.Trim(name, ".")).Inc()
+		log.Printf("[INFO] nameservers returned different %s answers for %s: %+v", rrType, name, answered)
+		return nil, errDNSInconsistentAnswers
+	}
+	if len(values) == 0 {
+		return nil, errDNSEmptyAnswer
+	}
+	return values, nil
+}
+
+// queryNameserver returns the values of the records of the type, ignoring any
+// other records in the answer.
+func queryNameserver(name string, rrType string, nameserver string) ([]string, error) {
+	qtype, ok := dns.StringToType[rrType]
+	if !ok {
+		return nil, errDNSUnsupportedType
+	}
+	m := dns.Msg{}
+	m.SetQuestion(name, qtype)
+	r, err := dnsResolver.Exchange(&m, nameserver)
+	if err != nil {
+		return nil, err
+	}
+	values := []string{}
+	for _, rr := range r.Answer {
+		if rr.Header().Rrtype != qtype {
+			continue
+		}
+		values = append(values, rrValue(rr))
+	}
+	if len(values) == 0 {
+		return nil, errDNSEmptyAnswer
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// rrValue returns the value of the resource record, in the same format as
+// the values of dnsRecord.
+func rrValue(rr dns.RR) string {
+	switch r := rr.(type) {
+	case *dns.CNAME:
+		return strings.Trim(r.Target, ".")
+	case *dns.A:
+		return r.A.String()
+	case *dns.AAAA:
+		return r.AAAA.String()
+	case *dns.TXT:
+		return `"` + strings.Join(r.Txt, "") + `"`
+	case *dns.NS:
+		return strings.Trim(r.Ns, ".")
+	default:
+		return strings.TrimPrefix(rr.String(), rr.Header().String())
+	}
+}
+
+// resourceRecords returns the resource records holding the values of the
+// record.
+func resourceRecords(r dnsRecord, ttl int64) ([]dns.RR, error) {
+	name := dns.Fqdn(r.Hostname)
+	ret := make([]dns.RR, len(r.Values))
+	for i, v := range r.Values {
+		if r.Type == dns.TypeToString[dns.TypeCNAME] {
+			v = dns.Fqdn(v)
+		}
+		rr, err := dns.NewRR(fmt.Sprintf("%s %d IN %s %s", name, ttl, r.Type, v))
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = rr
+	}
+	if len(ret) == 0 {
+		return nil, fmt.Errorf("record %s has no values", r.Hostname)
+	}
+	return ret, nil
+}
+
+// zoneRecordsFromRRs groups the resource records by name and type.
+func zoneRecordsFromRRs(rrs []dns.RR) []zoneRecord {
+	sets := map[string]*zoneRecord{}
+	keys := []string{}
+	for _, rr := range rrs {
+		h := rr.Header()
+		key := strings.ToLower(h.Name) + " " + dns.TypeToString[h.Rrtype]
+		zr, ok := sets[key]
+		if !ok {
+			zr = &zoneRecord{Name: h.Name, Type: dns.TypeToString[h.Rrtype], TTL: int64(h.Ttl)}
+			sets[key] = zr
+			keys = append(keys, key)
+		}
+		value := rrValue(rr)
+		if !stringInSlice(value, zr.Values) {
+			zr.Values = append(zr.Values, value)
+		}
+	}
+	records := make([]zoneRecord, len(keys))
+	for i, k := range keys {
+		records[i] = *sets[k]
+	}
+	return records
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestResolveRecord_noServer(t *testing.T) {
+	_, err := resolveRecord("example.com.", "CNAME", []string{"127.0.0.1:65111"})
+	if err == nil {
+		t.Fatalf("resolveRecord should have returned an error")
+	}
+}
+
+func TestResolveRecord_noNameservers(t *testing.T) {
+	if _, err := resolveRecord("example.com.", "CNAME", nil); err != errDNSNoNameservers {
+		t.Fatalf("resolveRecord returned unexpected error: %+v", err)
+	}
+}
+
+func TestResolveRecord_empty(t *testing.T) {
+	servers, serverAddresses, err := startMockDNSServerFleet(map[string]string{})
+	defer stopMockDNSServerFleet(servers)
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	_, err = resolveRecord("example.com.", "CNAME", serverAddresses)
+	if err != errDNSEmptyAnswer {
+		t.Fatalf("resolveRecord should have returned an empty answer error")
+	}
+}
+
+func TestResolveRecord_broken(t *testing.T) {
+	servers, serverAddresses, err := startMockSemiBrokenDNSServerFleet(map[string]string{"example.com.": "target.example.com."})
+	defer stopMockDNSServerFleet(servers)
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	// one of the reachable nameservers does not have the record
+	_, err = resolveRecord("example.com.", "CNAME", serverAddresses)
+	if err != errDNSInconsistentAnswers {
+		t.Fatalf("resolveRecord should have returned an inconsistent answers error: %+v", err)
+	}
+
+	resp, err := resolveRecord("example.com.", "CNAME", append([]string{serverAddresses[0]}, serverAddresses[2:]...))
+	if err != nil {
+		t.Fatalf("resolveRecord returned unexpected error: %+v", err)
+	}
+
+	if !reflect.DeepEqual(resp, []string{"target.example.com"}) {
+		t.Fatalf("resolveRecord returned unexpected response: %+v", resp)
+	}
+}
+
+func TestResolveRecord_inconsistent(t *testing.T) {
+	s1, addr1, err := startMockDNSServer("127.0.0.1:0", map[string]string{"example.com.": "target.example.com."})
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+	s2, addr2, err := startMockDNSServer("127.0.0.1:0", map[string]string{"example.com.": "other.example.com."})
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+	defer stopMockDNSServerFleet([]*dns.Server{s1, s2})
+
+	_, err = resolveRecord("example.com.", "CNAME", []string{addr1, addr2})
+	if err != errDNSInconsistentAnswers {
+		t.Fatalf("resolveRecord should have returned an inconsistent answers error: %+v", err)
+	}
+}
+
+func TestResolveRecord_addresses(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+	mux := dns.NewServeMux()
+	mux.HandleFunc("example.com.", func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		m.Answer = append(m.Answer,
+			&dns.AAAA{Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET}, AAAA: net.ParseIP("::1")},
+			&dns.A{Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET}, A: net.ParseIP("10.0.0.1")},
+		)
+		w.WriteMsg(m)
+	})
+	server := &dns.Server{PacketConn: pc, Handler: mux}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	defer server.Shutdown()
+
+	testCases := []struct {
+		rrType   string
+		expected []string
+		err      error
+	}{
+		{"A", []string{"10.0.0.1"}, nil},
+		{"AAAA", []string{"::1"}, nil},
+		{"CNAME", nil, errDNSEmptyAnswer},
+		{"BOGUS", nil, errDNSUnsupportedType},
+	}
+	for i, tc := range testCases {
+		resp, err := resolveRecord("example.com.", tc.rrType, []string{pc.LocalAddr().String()})
+		if err != tc.err || !reflect.DeepEqual(resp, tc.expected) {
+			t.Errorf("resolveRecord returned unexpected result for test case #%02d: %+v, %+v", i, resp, err)
+		}
+	}
+}
+
+func TestResolver_Exchange_tcpFallback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Skipf("dnstest: unable to listen on udp %s: %v", l.Addr(), err)
+	}
+
+	mux := dns.NewServeMux()
+	mux.HandleFunc("example.com.", func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if w.LocalAddr().Network() == "udp" {
+			m.Truncated = true
+		} else {
+			m.Answer = append(m.Answer, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET},
+				Target: "target.example.com.",
+			})
+		}
+		w.WriteMsg(m)
+	})
+	udpServer := &dns.Server{PacketConn: pc, Handler: mux}
+	tcpServer := &dns.Server{Listener: l, Handler: mux}
+	for _, s := range []*dns.Server{udpServer, tcpServer} {
+		started := make(chan struct{})
+		s.NotifyStartedFunc = func() { close(started) }
+		go s.ActivateAndServe()
+		<-started
+	}
+	defer stopMockDNSServerFleet([]*dns.Server{udpServer, tcpServer})
+
+	m := &dns.Msg{}
+	m.SetQuestion("example.com.", dns.TypeCNAME)
+
+	resp, err := newResolver(time.Second, 0, false).Exchange(m, l.Addr().String())
+	if err == nil && (!resp.Truncated || len(resp.Answer) != 0) {
+		t.Errorf("resolver.Exchange without tcp fallback returned unexpected answer: %+v", resp)
+	}
+
+	resp, err = newResolver(time.Second, 0, true).Exchange(m, l.Addr().String())
+	if err != nil {
+		t.Fatalf("resolver.Exchange returned unexpected error: %+v", err)
+	}
+	if len(resp.Answer) != 1 || resp.Answer[0].(*dns.CNAME).Target != "target.example.com." {
+		t.Errorf("resolver.Exchange returned unexpected answer: %+v", resp)
+	}
+}
+
+func TestZoneNameservers(t *testing.T) {
+	z := &mockDNSZone{nameservers: []string{"ns1.example.com:53"}}
+	if ns := zoneNameservers(z, registratorOptions{}); !reflect.DeepEqual(ns, z.nameservers) {
+		t.Errorf("zoneNameservers returned unexpected nameservers: %+v", ns)
+	}
+
+	o := registratorOptions{
+		Targets:         []string{testPrivateTarget},
+		TargetLabelName: testTargetLabelName,
+		ZoneIDs:         []string{"a"},
+		Resolvers:       []string{"10.0.0.2", "10.0.0.3:5353"},
+	}
+	if err := validateOptions(&o); err != nil {
+		t.Fatalf("validateOptions returned unexpected error: %+v", err)
+	}
+	expected := []string{"10.0.0.2:53", "10.0.0.3:5353"}
+	if ns := zoneNameservers(z, o); !reflect.DeepEqual(ns, expected) {
+		t.Errorf("zoneNameservers returned unexpected nameservers: %+v", ns)
+	}
+}
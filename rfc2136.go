@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/miekg/dns"
+)
+
+const providerRFC2136 = "rfc2136"
+
+var (
+	errRFC2136MissingServer        = errors.New("the rfc2136 provider requires a server")
+	errRFC2136InvalidTSIG          = errors.New("the rfc2136 tsig key name and secret must be set together")
+	errRFC2136InvalidTSIGAlgorithm = errors.New("unsupported rfc2136 tsig algorithm")
+	errRFC2136ZoneNotFound         = errors.New("the rfc2136 server is not authoritative for the zone")
+
+	defaultRFC2136TSIGAlgorithm = "hmac-sha256"
+	defaultRFC2136Timeout       = 10 * time.Second
+	rfc2136TSIGFudge            = uint16(300)
+)
+
+func init() {
+	registerProvider(providerRFC2136, newRFC2136Provider)
+}
+
+// rfc2136Options are the settings of the rfc2136 provider.
+type rfc2136Options struct {
+	Server        string `json:"server"` // host[:port] of the primary nameserver of the zones
+	TSIGKeyName   string `json:"tsigKeyName"`
+	TSIGSecret    string `json:"tsigSecret"`    // base64 encoded
+	TSIGAlgorithm string `json:"tsigAlgorithm"` // defaults to hmac-sha256
+}
+
+// rfc2136Provider manages zones on a nameserver that accepts dynamic updates
+// (RFC 2136), eg. BIND, optionally signing the messages with TSIG.
+type rfc2136Provider struct {
+	server    string
+	keyName   string
+	secret    string
+	algorithm string
+}
+
+func newRFC2136Provider(options registratorOptions) (dnsProvider, error) {
+	o := options.RFC2136
+	if o.Server == "" {
+		return nil, errRFC2136MissingServer
+	}
+	if (o.TSIGKeyName == "") != (o.TSIGSecret == "") {
+		return nil, errRFC2136InvalidTSIG
+	}
+	p := &rfc2136Provider{server: o.Server}
+	if _, _, err := net.SplitHostPort(p.server); err != nil {
+		p.server = net.JoinHostPort(p.server, "53")
+	}
+	if o.TSIGKeyName != "" {
+		algorithm := o.TSIGAlgorithm
+		if algorithm == "" {
+			algorithm = defaultRFC2136TSIGAlgorithm
+		}
+		p.algorithm = dns.Fqdn(strings.ToLower(algorithm))
+		switch p.algorithm {
+		case dns.HmacMD5, dns.HmacSHA1, dns.HmacSHA256, dns.HmacSHA512:
+		default:
+			return nil, errRFC2136InvalidTSIGAlgorithm
+		}
+		p.keyName = dns.Fqdn(strings.ToLower(o.TSIGKeyName))
+		p.secret = o.TSIGSecret
+	}
+	log.Printf("[INFO] setup rfc2136 provider for server %s", p.server)
+	return p, nil
+}
+
+func (p *rfc2136Provider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	z := &rfc2136Zone{
+		provider: p,
+		Name:     dns.Fqdn(id),
+		TTL:      options.RecordTTL,
+		client:   &dns.Client{Net: "tcp", Timeout: defaultRFC2136Timeout},
+		records:  newRecordCache(),
+	}
+	if p.keyName != "" {
+		z.client.TsigSecret = map[string]string{p.keyName: p.secret}
+	}
+	if err := z.checkZone(ctx); err != nil {
+		return nil, err
+	}
+	if options.PruneSource == pruneSourceProvider {
+		z.RecordsRefreshInterval = options.RecordsRefresh
+	}
+	return z, nil
+}
+
+func (p *rfc2136Provider) Capabilities() providerCapabilities {
+	return providerCapabilities{TXT: true}
+}
+
+// rfc2136Zone is a zone on the server of an rfc2136 provider. Changes are
+// applied synchronously, and records are listed with zone transfers (AXFR).
+type rfc2136Zone struct {
+	provider *rfc2136Provider
+	Name     string
+	TTL      int64
+	// RecordsRefreshInterval is how often the records of the zone are
+	// transferred while running; if zero they are never listed.
+	RecordsRefreshInterval time.Duration
+	client                 *dns.Client
+	records                *recordCache
+}
+
+// checkZone checks that the server is authoritative for the zone.
+func (z *rfc2136Zone) checkZone(ctx context.Context) error {
+	m := &dns.Msg{}
+	m.SetQuestion(z.Name, dns.TypeSOA)
+	resp, err := z.exchange(ctx, m)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess || !resp.Authoritative {
+		return errRFC2136ZoneNotFound
+	}
+	return nil
+}
+
+// exchange signs the message, if a tsig key is set, and sends it to the
+// server, until the context is done.
+func (z *rfc2136Zone) exchange(ctx context.Context, m *dns.Msg) (*dns.Msg, error) {
+	if z.provider.keyName != "" {
+		m.SetTsig(z.provider.keyName, z.provider.algorithm, rfc2136TSIGFudge, time.Now().Unix())
+	}
+	resp, _, err := z.client.ExchangeContext(ctx, m, z.provider.server)
+	return resp, err
+}
+
+func (z *rfc2136Zone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionUpsert, records)
+}
+
+func (z *rfc2136Zone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionDelete, records)
+}
+
+// changeRecords sends a single update message for all the records: the
+// current record sets are removed, along with any values, and on upserts
+// replaced with the new ones.
+func (z *rfc2136Zone) changeRecords(ctx context.Context, action string, records []dnsRecord) error {
+	m := &dns.Msg{}
+	m.SetUpdate(z.Name)
+	for _, r := range records {
+		rrs, err := resourceRecords(r, z.TTL)
+		if err != nil {
+			return err
+		}
+		m.RemoveRRset(rrs[:1])
+		if action != route53.ChangeActionDelete {
+			m.Insert(rrs)
+		}
+	}
+	resp, err := z.exchange(ctx, m)
+	if err != nil {
+		return err
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("rfc2136 update of zone %s failed: %s", z.Name, dns.RcodeToString[resp.Rcode])
+	}
+	for _, r := range records {
+		if action == route53.ChangeActionDelete {
+			z.records.Delete(r.Hostname, r.Type, "")
+		} else {
+			z.records.Upsert(zoneRecord{Name: r.Hostname, Type: r.Type, TTL: z.TTL, Values: r.Values})
+		}
+	}
+	return nil
+}
+
+// Run transfers the records of the zone periodically, if required.
+func (z *rfc2136Zone) Run(ctx context.Context) {
+	if z.RecordsRefreshInterval == 0 {
+		return
+	}
+	tick := time.NewTicker(z.RecordsRefreshInterval)
+	defer tick.Stop()
+	for {
+		if err := z.refreshRecords(ctx); err != nil {
+			log.Printf("[ERROR] could not transfer the records of zone %s: %+v", z.Name, err)
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WaitForChanges returns straight away, as the changes are applied by the
+// time the update is acknowledged.
+func (z *rfc2136Zone) WaitForChanges(ctx context.Context) error {
+	return nil
+}
+
+// refreshRecords transfers all the records of the zone and replaces the
+// cached ones. The transfer is abandoned when the context is done.
+func (z *rfc2136Zone) refreshRecords(ctx context.Context) error {
+	m := &dns.Msg{}
+	m.SetAxfr(z.Name)
+	t := &dns.Transfer{DialTimeout: defaultRFC2136Timeout, ReadTimeout: defaultRFC2136Timeout}
+	if z.provider.keyName != "" {
+		m.SetTsig(z.provider.keyName, z.provider.algorithm, rfc2136TSIGFudge, time.Now().Unix())
+		t.TsigSecret = map[string]string{z.provider.keyName: z.provider.secret}
+	}
+	conn, err := (&net.Dialer{Timeout: defaultRFC2136Timeout}).DialContext(ctx, "tcp", z.provider.server)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	t.Conn = &dns.Conn{Conn: conn}
+	envelopes, err := t.In(m, z.provider.server)
+	if err != nil {
+		return err
+	}
+	rrs := []dns.RR{}
+	for done := false; !done; {
+		select {
+		case e, ok := <-envelopes:
+			if !ok {
+				done = true
+				break
+			}
+			if e.Error != nil {
+				return e.Error
+			}
+			rrs = append(rrs, e.RR...)
+		case <-ctx.Done():
+			// closing the connection fails the transfer, whose last
+			// envelope is discarded
+			conn.Close()
+			go func() {
+				for range envelopes {
+				}
+			}()
+			return ctx.Err()
+		}
+	}
+	records := zoneRecordsFromRRs(rrs)
+	z.records.Replace(records)
+	log.Printf("[DEBUG] transferred %d record(s) of zone %s", len(records), z.Name)
+	return nil
+}
+
+func (z *rfc2136Zone) LookupRecords(name string) ([]zoneRecord, error) {
+	return z.records.Lookup(name)
+}
+
+func (z *rfc2136Zone) Domain() string {
+	return z.Name
+}
+
+// ListNameservers returns the server, which is authoritative for the zone.
+func (z *rfc2136Zone) ListNameservers() []string {
+	return []string{z.provider.server}
+}
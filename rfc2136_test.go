@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	testTSIGKeyName = "ingress53."
+	testTSIGSecret  = "c2VjcmV0c2VjcmV0c2VjcmV0"
+)
+
+// mockRFC2136Server is an authoritative nameserver for a single zone that
+// accepts dynamic updates and zone transfers signed with the test TSIG key.
+type mockRFC2136Server struct {
+	mu   sync.Mutex
+	zone string
+	rrs  []dns.RR
+}
+
+func (m *mockRFC2136Server) soa() dns.RR {
+	rr, _ := dns.NewRR(m.zone + " 60 IN SOA ns." + m.zone + " admin." + m.zone + " 1 60 60 60 60")
+	return rr
+}
+
+func (m *mockRFC2136Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := &dns.Msg{}
+	resp.SetReply(req)
+	if req.IsTsig() == nil || w.TsigStatus() != nil {
+		resp.SetRcode(req, dns.RcodeRefused)
+		w.WriteMsg(resp)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case req.Opcode == dns.OpcodeUpdate:
+		for _, rr := range req.Ns {
+			h := rr.Header()
+			if h.Class != dns.ClassANY {
+				m.rrs = append(m.rrs, rr)
+				continue
+			}
+			kept := []dns.RR{}
+			for _, e := range m.rrs {
+				if !strings.EqualFold(e.Header().Name, h.Name) || e.Header().Rrtype != h.Rrtype {
+					kept = append(kept, e)
+				}
+			}
+			m.rrs = kept
+		}
+	case !strings.EqualFold(req.Question[0].Name, m.zone):
+		resp.SetRcode(req, dns.RcodeNameError)
+	case req.Question[0].Qtype == dns.TypeAXFR:
+		resp.Answer = append(append([]dns.RR{m.soa()}, m.rrs...), m.soa())
+	case req.Question[0].Qtype == dns.TypeSOA:
+		resp.Authoritative = true
+		resp.Answer = []dns.RR{m.soa()}
+	}
+	resp.SetTsig(testTSIGKeyName, dns.HmacSHA256, 300, time.Now().Unix())
+	w.WriteMsg(resp)
+}
+
+func startMockRFC2136Server(m *mockRFC2136Server) (*dns.Server, string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	server := &dns.Server{
+		Listener:   l,
+		Handler:    m,
+		TsigSecret: map[string]string{testTSIGKeyName: testTSIGSecret},
+	}
+
+	waitLock := sync.Mutex{}
+	waitLock.Lock()
+	server.NotifyStartedFunc = waitLock.Unlock
+
+	go func() {
+		server.ActivateAndServe()
+		l.Close()
+	}()
+
+	waitLock.Lock()
+	return server, l.Addr().String(), nil
+}
+
+func TestRFC2136Zone_cancel(t *testing.T) {
+	// a server that accepts connections and never answers
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %+v", err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	z := &rfc2136Zone{
+		provider: &rfc2136Provider{server: l.Addr().String()},
+		Name:     "example.com.",
+		TTL:      60,
+		client:   &dns.Client{Net: "tcp", Timeout: defaultRFC2136Timeout},
+		records:  newRecordCache(),
+	}
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := z.UpsertRecords(ctx, []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget)}); err == nil {
+		t.Errorf("rfc2136Zone.UpsertRecords did not return an error for a cancelled context")
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := z.refreshRecords(ctx); err != context.DeadlineExceeded {
+		t.Errorf("rfc2136Zone.refreshRecords returned unexpected error for a cancelled context: %+v", err)
+	}
+	if d := time.Since(start); d >= defaultRFC2136Timeout {
+		t.Errorf("rfc2136Zone did not stop when the context was done, took %s", d)
+	}
+}
+
+func TestNewRFC2136Provider(t *testing.T) {
+	testCases := []struct {
+		options rfc2136Options
+		server  string
+		err     error
+	}{
+		{rfc2136Options{}, "", errRFC2136MissingServer},
+		{rfc2136Options{Server: "10.0.0.1"}, "10.0.0.1:53", nil},
+		{rfc2136Options{Server: "10.0.0.1:5353", TSIGKeyName: "key", TSIGSecret: testTSIGSecret}, "10.0.0.1:5353", nil},
+		{rfc2136Options{Server: "10.0.0.1", TSIGKeyName: "key"}, "", errRFC2136InvalidTSIG},
+		{rfc2136Options{Server: "10.0.0.1", TSIGKeyName: "key", TSIGSecret: testTSIGSecret, TSIGAlgorithm: "hmac-sha3"}, "", errRFC2136InvalidTSIGAlgorithm},
+	}
+	for i, tc := range testCases {
+		p, err := newRFC2136Provider(registratorOptions{RFC2136: tc.options})
+		if err != tc.err {
+			t.Errorf("newRFC2136Provider returned unexpected error for test case #%02d: %+v", i, err)
+			continue
+		}
+		if err == nil && p.(*rfc2136Provider).server != tc.server {
+			t.Errorf("newRFC2136Provider returned unexpected server for test case #%02d: %s", i, p.(*rfc2136Provider).server)
+		}
+	}
+}
+
+func TestRFC2136Zone(t *testing.T) {
+	m := &mockRFC2136Server{zone: "example.com."}
+	server, addr, err := startMockRFC2136Server(m)
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+	defer server.Shutdown()
+
+	options := registratorOptions{RecordTTL: 60, RFC2136: rfc2136Options{Server: addr, TSIGKeyName: "ingress53", TSIGSecret: testTSIGSecret}}
+	p, err := newRFC2136Provider(options)
+	if err != nil {
+		t.Fatalf("newRFC2136Provider returned unexpected error: %+v", err)
+	}
+	if _, err := p.NewZone(context.Background(), "example.org", options); err != errRFC2136ZoneNotFound {
+		t.Errorf("rfc2136Provider.NewZone returned unexpected error for an unknown zone: %+v", err)
+	}
+	dz, err := p.NewZone(context.Background(), "example.com", options)
+	if err != nil {
+		t.Fatalf("rfc2136Provider.NewZone returned unexpected error: %+v", err)
+	}
+	z := dz.(*rfc2136Zone)
+
+	cname := newCnameRecord("a.example.com", testPrivateTarget)
+	ips := dnsRecord{Hostname: "b.example.com", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}}
+	owner := ownerRecord(ips, "one")
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{cname, ips, owner}); err != nil {
+		t.Fatalf("rfc2136Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	// upserts replace the current values
+	cname.Values = []string{testPublicTarget}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{cname}); err != nil {
+		t.Fatalf("rfc2136Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if err := z.refreshRecords(context.Background()); err != nil {
+		t.Fatalf("rfc2136Zone.refreshRecords returned unexpected error: %+v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		expected []zoneRecord
+	}{
+		{"a.example.com", []zoneRecord{{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{testPublicTarget}}}},
+		{"b.example.com", []zoneRecord{{Name: "b.example.com", Type: "A", TTL: 60, Values: []string{"10.0.0.1", "10.0.0.2"}}}},
+		{"_ingress53.b.example.com", []zoneRecord{{Name: "_ingress53.b.example.com", Type: "TXT", TTL: 60, Values: owner.Values}}},
+	}
+	for i, tc := range testCases {
+		records, err := z.LookupRecords(tc.name)
+		if err != nil || !reflect.DeepEqual(records, tc.expected) {
+			t.Errorf("rfc2136Zone.LookupRecords returned unexpected result for test case #%02d: %+v, %+v", i, records, err)
+		}
+	}
+
+	if err := z.DeleteRecords(context.Background(), []dnsRecord{cname}); err != nil {
+		t.Fatalf("rfc2136Zone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if err := z.refreshRecords(context.Background()); err != nil {
+		t.Fatalf("rfc2136Zone.refreshRecords returned unexpected error: %+v", err)
+	}
+	if records, _ := z.LookupRecords("a.example.com"); len(records) != 0 {
+		t.Errorf("rfc2136Zone.LookupRecords returned a deleted record: %+v", records)
+	}
+
+	// messages signed with the wrong key are refused
+	z.provider.secret = "d3Jvbmd3cm9uZ3dyb25n"
+	z.client.TsigSecret = map[string]string{z.provider.keyName: z.provider.secret}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{cname}); err == nil {
+		t.Errorf("rfc2136Zone.UpsertRecords did not return an error for a wrong tsig secret")
+	}
+}
@@ -1,61 +1,179 @@
 package main
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 )
 
 var (
-	errRoute53WaitWatchTimedOut = errors.New("timed out waiting for changes to be applied")
+	defaultRoute53RecordTTL                 int64 = 60
+	defaultRoute53ZoneWaitWatchInterval           = 10 * time.Second
+	defaultRoute53ZoneWaitWatchTimeout            = 2 * time.Minute
+	defaultRoute53ZoneTimedOutCheckInterval       = time.Minute
+	defaultRoute53RecordsRefreshInterval          = 5 * time.Minute
 
-	defaultRoute53RecordTTL             int64 = 60
-	defaultRoute53ZoneWaitWatchInterval       = 10 * time.Second
-	defaultRoute53ZoneWaitWatchTimeout        = 2 * time.Minute
+	// newRoute53API returns the client of the route53 provider, replaced in
+	// the end to end tests by an in memory api
+	newRoute53API = func(sess *session.Session, configs ...*aws.Config) route53iface.Route53API {
+		return route53.New(sess, configs...)
+	}
 )
 
+func init() {
+	registerProvider(providerRoute53, newRoute53Provider)
+}
+
+type route53Provider struct {
+	api    route53iface.Route53API // with the credentials of the session
+	sess   *session.Session
+	config *aws.Config
+	// roleAPIs are the clients of the roles assumed so far, shared by the
+	// zones that use the same role
+	mu       sync.Mutex
+	roleAPIs map[awsRole]route53iface.Route53API
+}
+
+func newRoute53Provider(options registratorOptions) (dnsProvider, error) {
+	sess, err := newAWSSession(options)
+	if err != nil {
+		return nil, err
+	}
+	config := &aws.Config{}
+	if options.AWS.Route53Endpoint != "" {
+		log.Printf("[INFO] using route53 endpoint %s", options.AWS.Route53Endpoint)
+		config.Endpoint = aws.String(options.AWS.Route53Endpoint)
+	}
+	log.Println("[INFO] setup route53 session")
+	return &route53Provider{
+		api:      newRoute53API(sess, config),
+		sess:     sess,
+		config:   config,
+		roleAPIs: map[awsRole]route53iface.Route53API{},
+	}, nil
+}
+
+// apiForZone returns the client that manages the zone, assuming its role if
+// it needs one.
+func (p *route53Provider) apiForZone(id string, options registratorOptions) route53iface.Route53API {
+	role := roleForZone(id, options)
+	if role.ARN == "" {
+		return p.api
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	api, ok := p.roleAPIs[role]
+	if !ok {
+		log.Printf("[INFO] assuming role %s for route53", role.ARN)
+		api = newRoute53API(p.sess, p.config, &aws.Config{Credentials: assumeRoleCredentials(p.sess, role)})
+		p.roleAPIs[role] = api
+	}
+	return api
+}
+
+func (p *route53Provider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	z, err := newRoute53Zone(ctx, id, p.apiForZone(id, options))
+	if err != nil {
+		return nil, err
+	}
+	z.TTL = options.RecordTTL
+	if options.PruneSource == pruneSourceProvider || listsPrivateZone(z, options) {
+		z.RecordsRefreshInterval = options.RecordsRefresh
+	}
+	return z, nil
+}
+
+func (p *route53Provider) Capabilities() providerCapabilities {
+	return fullCapabilities
+}
+
 type route53Zone struct {
 	api         route53iface.Route53API
 	Name        string
 	ID          string
 	Nameservers []string
+	Private     bool
+	TTL         int64
+	// RecordsRefreshInterval is how often the records of the zone are
+	// listed while running; if zero they are never listed.
+	RecordsRefreshInterval time.Duration
+	tracker                *changeTracker
+	records                *recordCache
 }
 
-func newRoute53Zone(zoneID string, route53session route53iface.Route53API) (*route53Zone, error) {
-	ret := &route53Zone{api: route53session}
-	if err := ret.setZone(zoneID); err != nil {
+func newRoute53Zone(ctx context.Context, zoneID string, route53session route53iface.Route53API) (*route53Zone, error) {
+	ret := &route53Zone{
+		api:     route53session,
+		TTL:     defaultRoute53RecordTTL,
+		tracker: newChangeTracker(route53session),
+		records: newRecordCache(),
+	}
+	if err := ret.setZone(ctx, zoneID); err != nil {
 		return nil, err
 	}
 	return ret, nil
 }
 
-func (z *route53Zone) UpsertCnames(records []cnameRecord) error {
-	return z.changeCnames(route53.ChangeActionUpsert, records)
+func (z *route53Zone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionUpsert, records)
 }
 
-func (z *route53Zone) DeleteCnames(records []cnameRecord) error {
-	return z.changeCnames(route53.ChangeActionDelete, records)
+func (z *route53Zone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionDelete, records)
 }
 
-func (z *route53Zone) changeCnames(action string, records []cnameRecord) error {
+func (z *route53Zone) changeRecords(ctx context.Context, action string, records []dnsRecord) error {
+	records, obsoleteChecks, createdChecks, err := z.resolveHealthChecks(ctx, action, records)
+	if err != nil {
+		return err
+	}
 	changes := make([]*route53.Change, len(records))
 	for i, r := range records {
+		rrs := make([]*route53.ResourceRecord, len(r.Values))
+		for j, v := range r.Values {
+			rrs[j] = &route53.ResourceRecord{Value: aws.String(v)}
+		}
+		rrset := &route53.ResourceRecordSet{
+			Name:            aws.String(r.Hostname),
+			TTL:             aws.Int64(z.TTL),
+			Type:            aws.String(r.Type),
+			ResourceRecords: rrs,
+		}
+		if r.Routing.SetIdentifier != "" {
+			rrset.SetIdentifier = aws.String(r.Routing.SetIdentifier)
+			switch r.Routing.kind() {
+			case routingFailover:
+				rrset.Failover = aws.String(r.Routing.Failover)
+			case routingLatency:
+				rrset.Region = aws.String(r.Routing.Region)
+			case routingGeolocation:
+				rrset.GeoLocation = &route53.GeoLocation{}
+				if r.Routing.GeoContinent != "" {
+					rrset.GeoLocation.ContinentCode = aws.String(r.Routing.GeoContinent)
+				} else {
+					rrset.GeoLocation.CountryCode = aws.String(r.Routing.GeoCountry)
+				}
+			default:
+				rrset.Weight = aws.Int64(r.Routing.Weight)
+			}
+		}
+		if r.Routing.HealthCheckID != "" {
+			rrset.HealthCheckId = aws.String(r.Routing.HealthCheckID)
+		}
 		changes[i] = &route53.Change{
-			Action: aws.String(action),
-			ResourceRecordSet: &route53.ResourceRecordSet{
-				Name:            aws.String(r.Hostname),
-				TTL:             aws.Int64(defaultRoute53RecordTTL),
-				Type:            aws.String(route53.RRTypeCname),
-				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(r.Target)}},
-			},
+			Action:            aws.String(action),
+			ResourceRecordSet: rrset,
 		}
 	}
-	resp, err := z.api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+	resp, err := z.api.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
 		ChangeBatch: &route53.ChangeBatch{
 			Changes: changes,
 			Comment: aws.String("updated by ingress53"),
@@ -63,52 +181,183 @@ func (z *route53Zone) changeCnames(action string, records []cnameRecord) error {
 		HostedZoneId: aws.String(z.ID),
 	})
 	if err != nil {
+		z.deleteHealthChecks(ctx, createdChecks)
 		return err
 	}
-	log.Println("[DEBUG] route53 changes have been submitted, waiting for nameservers to sync")
-	return z.waitForSync(*resp.ChangeInfo.Id)
+	log.Printf("[DEBUG] route53 change %s has been submitted", *resp.ChangeInfo.Id)
+	for _, r := range records {
+		if action == route53.ChangeActionDelete {
+			z.records.Delete(r.Hostname, r.Type, r.Routing.SetIdentifier)
+		} else {
+			z.records.Upsert(zoneRecord{Name: r.Hostname, Type: r.Type, TTL: z.TTL, Values: r.Values, Routing: r.Routing})
+		}
+	}
+	submittedAt := time.Now()
+	if resp.ChangeInfo.SubmittedAt != nil {
+		submittedAt = *resp.ChangeInfo.SubmittedAt
+	}
+	z.tracker.Track(trackedChange{
+		ID:          *resp.ChangeInfo.Id,
+		Action:      action,
+		Records:     records,
+		SubmittedAt: submittedAt,
+	})
+	z.deleteHealthChecks(ctx, obsoleteChecks)
+	return nil
+}
+
+// Run tracks the submitted changes, and refreshes the records of the zone if
+// required, until the context is done.
+func (z *route53Zone) Run(ctx context.Context) {
+	if z.RecordsRefreshInterval > 0 {
+		go z.refreshRecordsEvery(ctx, z.RecordsRefreshInterval)
+	}
+	z.tracker.Run(ctx)
 }
 
-func (z *route53Zone) waitForSync(changeID string) error {
-	timeout := time.NewTimer(defaultRoute53ZoneWaitWatchTimeout)
-	tick := time.NewTicker(defaultRoute53ZoneWaitWatchInterval)
-	defer func() {
-		timeout.Stop()
-		tick.Stop()
-	}()
-	var err error
-	var change *route53.GetChangeOutput
+func (z *route53Zone) refreshRecordsEvery(ctx context.Context, interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
 	for {
+		if err := z.refreshRecords(ctx); err != nil {
+			log.Printf("[ERROR] could not list the records of route53 zone %s: %+v", z.ID, err)
+		}
 		select {
 		case <-tick.C:
-			change, err = z.api.GetChange(&route53.GetChangeInput{Id: aws.String(changeID)})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// lookupRecordSet returns the record set with the name, type and set
+// identifier of the record, or nil if there is none.
+func (z *route53Zone) lookupRecordSet(ctx context.Context, record dnsRecord) (*route53.ResourceRecordSet, error) {
+	in := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(z.ID),
+		StartRecordName: aws.String(record.Hostname),
+		StartRecordType: aws.String(record.Type),
+		MaxItems:        aws.String("1"),
+	}
+	if record.Routing.SetIdentifier != "" {
+		in.StartRecordIdentifier = aws.String(record.Routing.SetIdentifier)
+	}
+	resp, err := z.api.ListResourceRecordSetsWithContext(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	for _, rrs := range resp.ResourceRecordSets {
+		if normalizeRecordName(aws.StringValue(rrs.Name)) == normalizeRecordName(record.Hostname) && aws.StringValue(rrs.Type) == record.Type && aws.StringValue(rrs.SetIdentifier) == record.Routing.SetIdentifier {
+			return rrs, nil
+		}
+	}
+	return nil, nil
+}
+
+// refreshRecords lists all the records of the zone and replaces the cached
+// ones.
+func (z *route53Zone) refreshRecords(ctx context.Context) error {
+	records := []zoneRecord{}
+	err := z.api.ListResourceRecordSetsPagesWithContext(ctx, &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(z.ID)}, func(page *route53.ListResourceRecordSetsOutput, lastPage bool) bool {
+		for _, rrs := range page.ResourceRecordSets {
+			zr := zoneRecord{
+				Name: aws.StringValue(rrs.Name),
+				Type: aws.StringValue(rrs.Type),
+				TTL:  aws.Int64Value(rrs.TTL),
+				Routing: routingPolicy{
+					SetIdentifier: aws.StringValue(rrs.SetIdentifier),
+					Weight:        aws.Int64Value(rrs.Weight),
+					Failover:      aws.StringValue(rrs.Failover),
+					Region:        aws.StringValue(rrs.Region),
+					HealthCheckID: aws.StringValue(rrs.HealthCheckId),
+				},
+			}
+			if rrs.GeoLocation != nil {
+				zr.Routing.GeoContinent = aws.StringValue(rrs.GeoLocation.ContinentCode)
+				zr.Routing.GeoCountry = aws.StringValue(rrs.GeoLocation.CountryCode)
+			}
+			for _, rr := range rrs.ResourceRecords {
+				zr.Values = append(zr.Values, strings.Trim(aws.StringValue(rr.Value), "."))
+			}
+			if rrs.AliasTarget != nil {
+				zr.Values = append(zr.Values, strings.Trim(aws.StringValue(rrs.AliasTarget.DNSName), "."))
+			}
+			records = append(records, zr)
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	if err := z.setHealthCheckPaths(ctx, records); err != nil {
+		return err
+	}
+	z.records.Replace(records)
+	log.Printf("[DEBUG] listed %d record(s) of route53 zone %s", len(records), z.ID)
+	return nil
+}
+
+// setHealthCheckPaths sets the path of the records with a managed health
+// check, so that they can be compared with the requested ones.
+func (z *route53Zone) setHealthCheckPaths(ctx context.Context, records []zoneRecord) error {
+	var paths map[string]string
+	for i, r := range records {
+		if r.Routing.HealthCheckID == "" {
+			continue
+		}
+		if paths == nil {
+			checks, err := z.managedHealthChecks(ctx)
 			if err != nil {
 				return err
 			}
-			if *change.ChangeInfo.Status == route53.ChangeStatusInsync {
-				return nil
-			}
-			log.Printf("[DEBUG] route53 changes are still being applied, waiting for %s", defaultRoute53ZoneWaitWatchInterval.String())
-		case <-timeout.C:
-			return errRoute53WaitWatchTimedOut
+			paths = healthCheckPaths(checks)
+		}
+		if p, ok := paths[r.Routing.HealthCheckID]; ok {
+			records[i].Routing.HealthCheckPath = p
 		}
 	}
+	return nil
+}
+
+// LookupRecords returns the records of the zone with the name, from the
+// records listed last.
+func (z *route53Zone) LookupRecords(name string) ([]zoneRecord, error) {
+	return z.records.Lookup(name)
+}
+
+// OnSync sets a function to be called with the records of every change once
+// it is in sync. It must be called before Run.
+func (z *route53Zone) OnSync(f func(action string, records []dnsRecord)) {
+	z.tracker.onSync = f
 }
 
-func (z *route53Zone) setZone(id string) error {
-	zone, err := z.api.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String(id)})
+// WaitForChanges blocks until all the submitted changes are in sync.
+func (z *route53Zone) WaitForChanges(ctx context.Context) error {
+	return z.tracker.Wait(ctx)
+}
+
+func (z *route53Zone) setZone(ctx context.Context, id string) error {
+	zone, err := z.api.GetHostedZoneWithContext(ctx, &route53.GetHostedZoneInput{Id: aws.String(id)})
 	if err != nil {
 		return err
 	}
 	z.Name = *zone.HostedZone.Name
 	z.ID = *zone.HostedZone.Id
-	z.Nameservers = make([]string, len(zone.DelegationSet.NameServers))
-	for i, ns := range zone.DelegationSet.NameServers {
-		z.Nameservers[i] = *ns
+	z.Private = zone.HostedZone.Config != nil && aws.BoolValue(zone.HostedZone.Config.PrivateZone)
+	// private zones are not delegated
+	z.Nameservers = []string{}
+	if zone.DelegationSet != nil {
+		for _, ns := range zone.DelegationSet.NameServers {
+			z.Nameservers = append(z.Nameservers, *ns)
+		}
 	}
 	return nil
 }
 
+func (z *route53Zone) IsPrivate() bool {
+	return z.Private
+}
+
 func (z *route53Zone) Domain() string {
 	return z.Name
 }
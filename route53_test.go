@@ -1,12 +1,16 @@
 package main
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/route53/route53iface"
 )
@@ -19,20 +23,76 @@ type mockRoute53API struct {
 	getChangeErr  error
 	changeRRResp  *route53.ChangeResourceRecordSetsOutput
 	changeRRErr   error
+	listRRPages   []*route53.ListResourceRecordSetsOutput
+	listRRErr     error
+	healthChecks  *mockHealthChecks
 }
 
-func (m mockRoute53API) GetHostedZone(in *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+type mockHealthChecks struct {
+	checks  []*route53.HealthCheck
+	created int
+	deleted []string
+}
+
+func (m mockRoute53API) GetHostedZoneWithContext(ctx aws.Context, in *route53.GetHostedZoneInput, opts ...request.Option) (*route53.GetHostedZoneOutput, error) {
 	return m.getZoneResp, m.getZoneErr
 }
 
-func (m mockRoute53API) ChangeResourceRecordSets(in *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+func (m mockRoute53API) ChangeResourceRecordSetsWithContext(ctx aws.Context, in *route53.ChangeResourceRecordSetsInput, opts ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
 	return m.changeRRResp, m.changeRRErr
 }
 
-func (m mockRoute53API) GetChange(in *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+func (m mockRoute53API) GetChangeWithContext(ctx aws.Context, in *route53.GetChangeInput, opts ...request.Option) (*route53.GetChangeOutput, error) {
 	return m.getChangeResp, m.getChangeErr
 }
 
+func (m mockRoute53API) ListResourceRecordSetsWithContext(ctx aws.Context, in *route53.ListResourceRecordSetsInput, opts ...request.Option) (*route53.ListResourceRecordSetsOutput, error) {
+	if m.listRRErr != nil || len(m.listRRPages) == 0 {
+		return &route53.ListResourceRecordSetsOutput{}, m.listRRErr
+	}
+	return m.listRRPages[0], nil
+}
+
+func (m mockRoute53API) ListResourceRecordSetsPagesWithContext(ctx aws.Context, in *route53.ListResourceRecordSetsInput, fn func(*route53.ListResourceRecordSetsOutput, bool) bool, opts ...request.Option) error {
+	if m.listRRErr != nil {
+		return m.listRRErr
+	}
+	for i, p := range m.listRRPages {
+		if !fn(p, i == len(m.listRRPages)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m mockRoute53API) ListHealthChecksPagesWithContext(ctx aws.Context, in *route53.ListHealthChecksInput, fn func(*route53.ListHealthChecksOutput, bool) bool, opts ...request.Option) error {
+	fn(&route53.ListHealthChecksOutput{HealthChecks: m.healthChecks.checks}, true)
+	return nil
+}
+
+func (m mockRoute53API) CreateHealthCheckWithContext(ctx aws.Context, in *route53.CreateHealthCheckInput, opts ...request.Option) (*route53.CreateHealthCheckOutput, error) {
+	m.healthChecks.created++
+	hc := &route53.HealthCheck{
+		Id:                aws.String(fmt.Sprintf("hc-%d", m.healthChecks.created)),
+		CallerReference:   in.CallerReference,
+		HealthCheckConfig: in.HealthCheckConfig,
+	}
+	m.healthChecks.checks = append(m.healthChecks.checks, hc)
+	return &route53.CreateHealthCheckOutput{HealthCheck: hc}, nil
+}
+
+func (m mockRoute53API) DeleteHealthCheckWithContext(ctx aws.Context, in *route53.DeleteHealthCheckInput, opts ...request.Option) (*route53.DeleteHealthCheckOutput, error) {
+	remaining := []*route53.HealthCheck{}
+	for _, hc := range m.healthChecks.checks {
+		if *hc.Id != *in.HealthCheckId {
+			remaining = append(remaining, hc)
+		}
+	}
+	m.healthChecks.checks = remaining
+	m.healthChecks.deleted = append(m.healthChecks.deleted, *in.HealthCheckId)
+	return &route53.DeleteHealthCheckOutput{}, nil
+}
+
 func mockRoute53Timers() func() {
 	dwi := defaultRoute53ZoneWaitWatchInterval
 	dwt := defaultRoute53ZoneWaitWatchTimeout
@@ -105,7 +165,7 @@ var (
 	}
 )
 
-func TestRoute53Zone_UpsertCname(t *testing.T) {
+func TestRoute53Zone_UpsertRecords(t *testing.T) {
 	testCases := []struct {
 		getZoneErr      error
 		getZoneResponse *route53.GetHostedZoneOutput
@@ -116,7 +176,7 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 		getChangeResponse *route53.GetChangeOutput
 
 		zoneID string
-		record cnameRecord
+		record dnsRecord
 
 		expectedNewErr    error
 		expectedUpsertErr error
@@ -129,7 +189,7 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 			nil,
 			nil,
 			"example.com.",
-			cnameRecord{"test.example.com", "cname.example.com"},
+			newCnameRecord("test.example.com", "cname.example.com"),
 			errTestRoute53ZoneMock,
 			nil,
 		},
@@ -141,11 +201,11 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 			nil,
 			nil,
 			"example.com.",
-			cnameRecord{"test.example.com", "cname.example.com"},
+			newCnameRecord("test.example.com", "cname.example.com"),
 			nil,
 			errTestRoute53ZoneMock,
 		},
-		{ // error in get change request
+		{ // error in get change request, changes are tracked asynchronously
 			nil,
 			testRoute53ZoneGetZoneOK,
 			nil,
@@ -153,11 +213,11 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 			errTestRoute53ZoneMock,
 			nil,
 			"example.com.",
-			cnameRecord{"test.example.com", "cname.example.com"},
+			newCnameRecord("test.example.com", "cname.example.com"),
+			nil,
 			nil,
-			errTestRoute53ZoneMock,
 		},
-		{ // timeout in get change
+		{ // pending change, changes are tracked asynchronously
 			nil,
 			testRoute53ZoneGetZoneOK,
 			nil,
@@ -165,9 +225,9 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 			nil,
 			testRoute53ZoneGetChangePending,
 			"example.com.",
-			cnameRecord{"test.example.com", "cname.example.com"},
+			newCnameRecord("test.example.com", "cname.example.com"),
+			nil,
 			nil,
-			errRoute53WaitWatchTimedOut,
 		},
 		{ // works end to end
 			nil,
@@ -177,7 +237,7 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 			nil,
 			testRoute53ZoneGetChangeOK,
 			"example.com.",
-			cnameRecord{"test.example.com", "cname.example.com"},
+			newCnameRecord("test.example.com", "cname.example.com"),
 			nil,
 			nil,
 		},
@@ -186,7 +246,7 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 	defer mockRoute53Timers()()
 
 	for i, tc := range testCases {
-		p, err := newRoute53Zone(tc.zoneID, &mockRoute53API{
+		p, err := newRoute53Zone(context.Background(), tc.zoneID, &mockRoute53API{
 			getZoneResp:   tc.getZoneResponse,
 			getZoneErr:    tc.getZoneErr,
 			getChangeResp: tc.getChangeResponse,
@@ -214,26 +274,349 @@ func TestRoute53Zone_UpsertCname(t *testing.T) {
 			t.Errorf("Route53Zone has unexpected Nameservers: %+v", p.Nameservers)
 		}
 
-		if err := p.UpsertCnames([]cnameRecord{tc.record}); err != tc.expectedUpsertErr {
-			t.Errorf("Route53Zone.UpsertCname returned unexpected error for case #%02d: %+v", i, err)
+		if err := p.UpsertRecords(context.Background(), []dnsRecord{tc.record}); err != tc.expectedUpsertErr {
+			t.Errorf("Route53Zone.UpsertRecords returned unexpected error for case #%02d: %+v", i, err)
+		}
+	}
+}
+
+func TestRoute53Zone_DeleteRecords(t *testing.T) {
+	api := newFakeRoute53()
+	id := api.addZone("example.com", false)
+	p, err := newRoute53Zone(context.Background(), id, api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+	ctx := context.Background()
+
+	record := newCnameRecord("test.example.com", "foo.example.com")
+	if err := p.DeleteRecords(ctx, []dnsRecord{record}); fakeErrorCode(err) != route53.ErrCodeInvalidChangeBatch {
+		t.Errorf("Route53Zone.DeleteRecords returned unexpected error for a missing record: %+v", err)
+	}
+	if err := p.UpsertRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if err := p.DeleteRecords(ctx, []dnsRecord{newCnameRecord("test.example.com", "bar.example.com")}); fakeErrorCode(err) != route53.ErrCodeInvalidChangeBatch {
+		t.Errorf("Route53Zone.DeleteRecords returned unexpected error for a record with other values: %+v", err)
+	}
+	if err := p.DeleteRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Errorf("Route53Zone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if rrs := api.recordSet(id, "test.example.com", route53.RRTypeCname, ""); rrs != nil {
+		t.Errorf("Route53Zone.DeleteRecords did not delete the record: %+v", rrs)
+	}
+}
+
+func TestRoute53Zone_changes(t *testing.T) {
+	api := newFakeRoute53()
+	id := api.addZone("example.com", false)
+	p, err := newRoute53Zone(context.Background(), id, api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+	p.records.Replace(nil)
+	ctx := context.Background()
+
+	records := []dnsRecord{
+		newCnameRecord("A.example.com", "cname.example.com"),
+		{Hostname: "b.example.com", Type: route53.RRTypeA, Values: []string{"10.0.0.2", "10.0.0.1"}},
+		{Hostname: "w.example.com", Type: route53.RRTypeCname, Values: []string{"one.example.com"}, Routing: routingPolicy{SetIdentifier: "one", Weight: 10}},
+	}
+	if err := p.UpsertRecords(ctx, records); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	expected := []*route53.ResourceRecordSet{
+		{Name: aws.String("a.example.com."), Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("cname.example.com")}}},
+		{Name: aws.String("b.example.com."), Type: aws.String(route53.RRTypeA), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.0.0.2")}, {Value: aws.String("10.0.0.1")}}},
+		{Name: aws.String("w.example.com."), Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(60), SetIdentifier: aws.String("one"), Weight: aws.Int64(10), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("one.example.com")}}},
+	}
+	if rrs := fakeRecordSetsWithout(api.recordSets(id), route53.RRTypeNs, route53.RRTypeSoa); !reflect.DeepEqual(rrs, expected) {
+		t.Errorf("Route53Zone.UpsertRecords left unexpected record sets: %+v", rrs)
+	}
+
+	// batches are applied entirely or not at all
+	testCases := []struct {
+		action  string
+		records []dnsRecord
+		code    string
+	}{
+		{route53.ChangeActionUpsert, []dnsRecord{{Hostname: "c.example.com", Type: route53.RRTypeA, Values: []string{"10.0.0.3"}}, {Hostname: "a.example.com", Type: route53.RRTypeA, Values: []string{"10.0.0.3"}}}, route53.ErrCodeInvalidChangeBatch},
+		{route53.ChangeActionUpsert, []dnsRecord{newCnameRecord("c.example.org", "cname.example.com")}, route53.ErrCodeInvalidChangeBatch},
+		{route53.ChangeActionDelete, []dnsRecord{records[0], newCnameRecord("missing.example.com", "cname.example.com")}, route53.ErrCodeInvalidChangeBatch},
+	}
+	for i, tc := range testCases {
+		if err := p.changeRecords(ctx, tc.action, tc.records); fakeErrorCode(err) != tc.code {
+			t.Errorf("Route53Zone.changeRecords returned unexpected error for test case #%02d: %+v", i, err)
+		}
+		if rrs := api.recordSets(id); len(rrs) != 5 || api.recordSet(id, "c.example.com", route53.RRTypeA, "") != nil {
+			t.Errorf("Route53Zone.changeRecords partially applied the batch of test case #%02d: %+v", i, rrs)
 		}
+		if cached, _ := p.LookupRecords("a.example.com"); len(cached) != 1 || cached[0].Type != route53.RRTypeCname {
+			t.Errorf("Route53Zone.changeRecords updated the cached records for test case #%02d: %+v", i, cached)
+		}
+	}
+
+	// upserts count twice towards the limit of records in a batch
+	many := []dnsRecord{}
+	for i := 0; i <= fakeRoute53MaxBatchRecords/2; i++ {
+		many = append(many, newCnameRecord(fmt.Sprintf("%d.example.com", i), "cname.example.com"))
+	}
+	if err := p.UpsertRecords(ctx, many); fakeErrorCode(err) != route53.ErrCodeInvalidChangeBatch {
+		t.Errorf("Route53Zone.UpsertRecords returned unexpected error for a batch over the limit: %+v", err)
+	}
+	if err := p.UpsertRecords(ctx, many[1:]); err != nil {
+		t.Errorf("Route53Zone.UpsertRecords returned unexpected error for a batch within the limit: %+v", err)
+	}
+
+	// throttled requests fail without changing anything
+	api.throttle = 1
+	c := newCnameRecord("c.example.com", "cname.example.com")
+	if err := p.UpsertRecords(ctx, []dnsRecord{c}); fakeErrorCode(err) != fakeRoute53ThrottlingCode {
+		t.Errorf("Route53Zone.UpsertRecords returned unexpected error when throttled: %+v", err)
+	}
+	if cached, _ := p.LookupRecords("c.example.com"); len(cached) != 0 {
+		t.Errorf("Route53Zone.UpsertRecords cached a record that was throttled: %+v", cached)
+	}
+	if err := p.UpsertRecords(ctx, []dnsRecord{c}); err != nil {
+		t.Errorf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+
+	// the listed records match the changes, over several pages
+	api.pageSize = 2
+	if err := p.refreshRecords(ctx); err != nil {
+		t.Fatalf("Route53Zone.refreshRecords returned unexpected error: %+v", err)
+	}
+	if cached, _ := p.LookupRecords("w.example.com"); len(cached) != 1 || !records[2].Routing.matches(cached[0].Routing) || !reflect.DeepEqual(cached[0].Values, []string{"one.example.com"}) {
+		t.Errorf("Route53Zone.LookupRecords returned unexpected records: %+v", cached)
+	}
+	if cached, _ := p.LookupRecords("1.example.com"); len(cached) != 1 {
+		t.Errorf("Route53Zone.LookupRecords returned unexpected records: %+v", cached)
 	}
 }
 
-func TestRoute53Zone_DeleteCname(t *testing.T) {
+func TestRoute53Zone_WaitForChanges(t *testing.T) {
 	defer mockRoute53Timers()()
+	defaultRoute53ZoneWaitWatchTimeout = time.Minute
 
-	p, err := newRoute53Zone("example.com.", &mockRoute53API{
-		getZoneResp:   testRoute53ZoneGetZoneOK,
-		getChangeResp: testRoute53ZoneGetChangeOK,
-		changeRRResp:  testRoute53ZoneChangeRROK,
-	})
+	api := newFakeRoute53()
+	api.pendingPolls = 1
+	id := api.addZone("example.com", false)
+	p, err := newRoute53Zone(context.Background(), id, api)
 	if err != nil {
 		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
 	}
 
-	if err := p.DeleteCnames([]cnameRecord{{Hostname: "test.example.com", Target: "foo.example.com"}}); err != nil {
-		t.Errorf("Route53Zone.DeleteCname returned unexpected error: %+v", err)
+	if err := p.UpsertRecords(context.Background(), []dnsRecord{newCnameRecord("test.example.com", "foo.example.com")}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := p.WaitForChanges(ctx); err != context.Canceled {
+		t.Errorf("Route53Zone.WaitForChanges returned unexpected error: %+v", err)
+	}
+
+	// the first status check is throttled and the change is still pending on
+	// the second one
+	api.throttle = 1
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go p.Run(ctx)
+	if err := p.WaitForChanges(ctx); err != nil {
+		t.Errorf("Route53Zone.WaitForChanges returned unexpected error: %+v", err)
+	}
+	if api.throttle != 0 {
+		t.Errorf("Route53Zone did not check the status of the change")
+	}
+}
+
+func TestRoute53Zone_LookupRecords(t *testing.T) {
+	api := &mockRoute53API{
+		getZoneResp:  testRoute53ZoneGetZoneOK,
+		changeRRResp: testRoute53ZoneChangeRROK,
+		listRRPages: []*route53.ListResourceRecordSetsOutput{
+			{ResourceRecordSets: []*route53.ResourceRecordSet{
+				{Name: aws.String("example.com."), Type: aws.String(route53.RRTypeNs), TTL: aws.Int64(172800), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("0.ns.example.com.")}}},
+				{Name: aws.String("a.example.com."), Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(60), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("cname.example.com")}}},
+			}},
+			{ResourceRecordSets: []*route53.ResourceRecordSet{
+				{Name: aws.String("b.example.com."), Type: aws.String(route53.RRTypeA), AliasTarget: &route53.AliasTarget{DNSName: aws.String("elb.amazonaws.com.")}},
+				{Name: aws.String("g.example.com."), Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(60), SetIdentifier: aws.String("eu"), GeoLocation: &route53.GeoLocation{ContinentCode: aws.String("EU")}, ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("eu.example.com")}}},
+				{Name: aws.String("l.example.com."), Type: aws.String(route53.RRTypeCname), TTL: aws.Int64(60), SetIdentifier: aws.String("eu"), Region: aws.String("eu-west-1"), ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("eu.example.com")}}},
+			}},
+		},
+	}
+	p, err := newRoute53Zone(context.Background(), "example.com.", api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+
+	if _, err := p.LookupRecords("a.example.com"); err != errRecordCacheNotLoaded {
+		t.Errorf("Route53Zone.LookupRecords returned unexpected error: %+v", err)
+	}
+
+	api.listRRErr = errTestRoute53ZoneMock
+	if err := p.refreshRecords(context.Background()); err != errTestRoute53ZoneMock {
+		t.Errorf("Route53Zone.refreshRecords returned unexpected error: %+v", err)
+	}
+	api.listRRErr = nil
+	if err := p.refreshRecords(context.Background()); err != nil {
+		t.Fatalf("Route53Zone.refreshRecords returned unexpected error: %+v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		expected []zoneRecord
+	}{
+		{"a.example.com", []zoneRecord{{Name: "a.example.com", Type: route53.RRTypeCname, TTL: 60, Values: []string{"cname.example.com"}}}},
+		{"b.example.com", []zoneRecord{{Name: "b.example.com", Type: route53.RRTypeA, Values: []string{"elb.amazonaws.com"}}}},
+		{"c.example.com", nil},
+		{"g.example.com", []zoneRecord{{Name: "g.example.com", Type: route53.RRTypeCname, TTL: 60, Values: []string{"eu.example.com"}, Routing: routingPolicy{SetIdentifier: "eu", GeoContinent: "EU"}}}},
+		{"l.example.com", []zoneRecord{{Name: "l.example.com", Type: route53.RRTypeCname, TTL: 60, Values: []string{"eu.example.com"}, Routing: routingPolicy{SetIdentifier: "eu", Region: "eu-west-1"}}}},
+	}
+	for i, tc := range testCases {
+		records, err := p.LookupRecords(tc.name)
+		if err != nil {
+			t.Errorf("Route53Zone.LookupRecords returned unexpected error for test case #%02d: %+v", i, err)
+		}
+		if !reflect.DeepEqual(records, tc.expected) {
+			t.Errorf("Route53Zone.LookupRecords returned unexpected records for test case #%02d: %+v", i, records)
+		}
+	}
+
+	// changes are reflected straight away
+	if err := p.UpsertRecords(context.Background(), []dnsRecord{newCnameRecord("c.example.com", "cname.example.com")}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if err := p.DeleteRecords(context.Background(), []dnsRecord{newCnameRecord("a.example.com", "cname.example.com")}); err != nil {
+		t.Fatalf("Route53Zone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if records, _ := p.LookupRecords("c.example.com"); len(records) != 1 {
+		t.Errorf("Route53Zone.LookupRecords did not return the upserted record: %+v", records)
+	}
+	if records, _ := p.LookupRecords("a.example.com"); len(records) != 0 {
+		t.Errorf("Route53Zone.LookupRecords returned the deleted record: %+v", records)
+	}
+}
+
+func TestRoute53Zone_healthChecks(t *testing.T) {
+	api := &mockRoute53API{
+		getZoneResp:  testRoute53ZoneGetZoneOK,
+		changeRRResp: testRoute53ZoneChangeRROK,
+		healthChecks: &mockHealthChecks{},
+	}
+	p, err := newRoute53Zone(context.Background(), "example.com.", api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+	p.records.Replace(nil)
+	ctx := context.Background()
+
+	record := dnsRecord{
+		Hostname: "a.example.com",
+		Type:     route53.RRTypeCname,
+		Values:   []string{"cname.example.com"},
+		Routing:  routingPolicy{SetIdentifier: "one", Failover: route53.ResourceRecordSetFailoverPrimary, HealthCheckPath: "/healthz"},
+	}
+	for i := 0; i < 2; i++ {
+		if err := p.UpsertRecords(ctx, []dnsRecord{record}); err != nil {
+			t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+		}
+	}
+	if len(api.healthChecks.checks) != 1 || api.healthChecks.created != 1 {
+		t.Fatalf("Route53Zone.UpsertRecords did not create a single health check: %+v", api.healthChecks.checks)
+	}
+	hc := api.healthChecks.checks[0]
+	if *hc.HealthCheckConfig.FullyQualifiedDomainName != "cname.example.com" || *hc.HealthCheckConfig.ResourcePath != "/healthz" || *hc.HealthCheckConfig.Type != route53.HealthCheckTypeHttp {
+		t.Errorf("Route53Zone.UpsertRecords created an unexpected health check: %+v", hc)
+	}
+	if records, _ := p.LookupRecords("a.example.com"); len(records) != 1 || records[0].Routing.HealthCheckID != "hc-1" || !record.Routing.matches(records[0].Routing) {
+		t.Errorf("Route53Zone.LookupRecords returned unexpected records: %+v", records)
+	}
+
+	// a new configuration replaces the health check
+	record.Routing.HealthCheckPath = "/ready"
+	if err := p.UpsertRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if len(api.healthChecks.checks) != 1 || *api.healthChecks.checks[0].Id != "hc-2" || !reflect.DeepEqual(api.healthChecks.deleted, []string{"hc-1"}) {
+		t.Errorf("Route53Zone.UpsertRecords did not replace the health check: %+v, deleted: %+v", api.healthChecks.checks, api.healthChecks.deleted)
+	}
+
+	// checks that are not managed are left alone
+	external := record
+	external.Hostname = "b.example.com"
+	external.Routing = routingPolicy{SetIdentifier: "two", Failover: route53.ResourceRecordSetFailoverSecondary, HealthCheckID: "external"}
+	if err := p.UpsertRecords(ctx, []dnsRecord{external}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if err := p.DeleteRecords(ctx, []dnsRecord{record, external}); err != nil {
+		t.Fatalf("Route53Zone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if len(api.healthChecks.checks) != 0 || api.healthChecks.created != 2 || !reflect.DeepEqual(api.healthChecks.deleted, []string{"hc-1", "hc-2"}) {
+		t.Errorf("Route53Zone.DeleteRecords did not delete the health check: %+v, deleted: %+v", api.healthChecks.checks, api.healthChecks.deleted)
+	}
+}
+
+func TestRoute53Zone_healthChecksFailures(t *testing.T) {
+	api := newFakeRoute53()
+	id := api.addZone("example.com", false)
+	p, err := newRoute53Zone(context.Background(), id, api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+	p.records.Replace(nil)
+	ctx := context.Background()
+
+	// health checks created for a change that fails are deleted
+	record := dnsRecord{
+		Hostname: "a.example.com",
+		Type:     route53.RRTypeCname,
+		Values:   []string{"cname.example.com"},
+		Routing:  routingPolicy{SetIdentifier: "one", Failover: route53.ResourceRecordSetFailoverPrimary, HealthCheckPath: "/healthz"},
+	}
+	if err := p.UpsertRecords(ctx, []dnsRecord{record, newCnameRecord("a.example.org", "cname.example.com")}); fakeErrorCode(err) != route53.ErrCodeInvalidChangeBatch {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	if len(api.healthChecks) != 0 {
+		t.Errorf("Route53Zone.UpsertRecords left the health checks of a failed change: %+v", api.healthChecks)
+	}
+
+	// a record is deleted with its current health check, even if it has a
+	// different configuration
+	if err := p.UpsertRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	record.Routing.HealthCheckPath = "/ready"
+	if err := p.DeleteRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Fatalf("Route53Zone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if rrs := api.recordSet(id, "a.example.com", route53.RRTypeCname, "one"); rrs != nil {
+		t.Errorf("Route53Zone.DeleteRecords did not delete the record: %+v", rrs)
+	}
+	if len(api.healthChecks) != 0 {
+		t.Errorf("Route53Zone.DeleteRecords did not delete the health check: %+v", api.healthChecks)
+	}
+
+	// and so it is when the records of the zone are not listed, as with the
+	// dns prune source
+	record.Routing.HealthCheckPath = "/healthz"
+	if err := p.UpsertRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Fatalf("Route53Zone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	unlisted, err := newRoute53Zone(ctx, id, api)
+	if err != nil {
+		t.Fatalf("newRoute53Zone returned unexpected error: %+v", err)
+	}
+	record.Routing.HealthCheckPath = "/ready"
+	if err := unlisted.DeleteRecords(ctx, []dnsRecord{record}); err != nil {
+		t.Fatalf("Route53Zone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if rrs := api.recordSet(id, "a.example.com", route53.RRTypeCname, "one"); rrs != nil {
+		t.Errorf("Route53Zone.DeleteRecords did not delete the record: %+v", rrs)
+	}
+	if len(api.healthChecks) != 0 {
+		t.Errorf("Route53Zone.DeleteRecords did not delete the health check: %+v", api.healthChecks)
 	}
 }
 
@@ -243,3 +626,45 @@ func TestRoute53Zone_Domain(t *testing.T) {
 		t.Errorf("Route53Zone.Domain return unexpected value")
 	}
 }
+
+func TestRoute53Zone_private(t *testing.T) {
+	api := mockRoute53API{getZoneResp: &route53.GetHostedZoneOutput{
+		HostedZone: &route53.HostedZone{
+			Config: &route53.HostedZoneConfig{PrivateZone: aws.Bool(true)},
+			Id:     aws.String("/hostedzone/YYYYYYYYYYYYYY"),
+			Name:   aws.String("example.com."),
+		},
+		VPCs: []*route53.VPC{{VPCId: aws.String("vpc-1"), VPCRegion: aws.String("eu-west-1")}},
+	}}
+	p := &route53Provider{api: api}
+	z, err := p.NewZone(context.Background(), "YYYYYYYYYYYYYY", registratorOptions{PruneSource: pruneSourceDNS, RecordsRefresh: defaultRoute53RecordsRefreshInterval})
+	if err != nil {
+		t.Fatalf("route53Provider.NewZone returned unexpected error: %+v", err)
+	}
+	rz := z.(*route53Zone)
+	if !rz.IsPrivate() || len(rz.ListNameservers()) != 0 {
+		t.Errorf("route53Zone is not private: %+v", rz)
+	}
+	if rz.RecordsRefreshInterval != defaultRoute53RecordsRefreshInterval {
+		t.Errorf("route53Provider.NewZone did not list the records of a private zone without private resolvers")
+	}
+	z, _ = p.NewZone(context.Background(), "YYYYYYYYYYYYYY", registratorOptions{PruneSource: pruneSourceDNS, PrivateResolvers: []string{"10.0.0.2:53"}})
+	if z.(*route53Zone).RecordsRefreshInterval != 0 {
+		t.Errorf("route53Provider.NewZone listed the records of a private zone with private resolvers")
+	}
+}
+
+func TestNewRoute53Provider_endpoint(t *testing.T) {
+	options := registratorOptions{
+		AWSSessionOptions: &session.Options{},
+		AWS:               awsOptions{Region: "eu-west-2", Route53Endpoint: "http://localhost:5000"},
+	}
+	p, err := newRoute53Provider(options)
+	if err != nil {
+		t.Fatalf("newRoute53Provider returned unexpected error: %+v", err)
+	}
+	api := p.(*route53Provider).api.(*route53.Route53)
+	if api.Endpoint != "http://localhost:5000" || aws.StringValue(api.Config.Region) != "eu-west-2" {
+		t.Errorf("newRoute53Provider did not use the endpoint and region: %s, %s", api.Endpoint, aws.StringValue(api.Config.Region))
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"k8s.io/api/extensions/v1beta1"
+)
+
+const (
+	setIdentifierAnnotation   = "ingress53.set-identifier"
+	weightAnnotation          = "ingress53.weight"
+	failoverAnnotation        = "ingress53.failover"
+	healthCheckIDAnnotation   = "ingress53.health-check-id"
+	healthCheckPathAnnotation = "ingress53.health-check-path"
+	regionAnnotation          = "ingress53.region"
+	geoContinentAnnotation    = "ingress53.geo-continent"
+	geoCountryAnnotation      = "ingress53.geo-country"
+
+	routingSimple      = "simple"
+	routingWeighted    = "weighted"
+	routingFailover    = "failover"
+	routingLatency     = "latency"
+	routingGeolocation = "geolocation"
+)
+
+var (
+	errRoutingMissingSetIdentifier = errors.New("routing annotations require the " + setIdentifierAnnotation + " annotation")
+	errRoutingMissingPolicy        = errors.New("the " + setIdentifierAnnotation + " annotation requires a weight, failover, region or geolocation annotation")
+	errRoutingMultiplePolicies     = errors.New("only one routing policy can be set")
+	errRoutingInvalidWeight        = errors.New("weight must be an integer between 0 and 255")
+	errRoutingInvalidFailover      = errors.New("failover must be primary or secondary")
+	errRoutingInvalidHealthCheck   = errors.New("health checks can only be set for failover records, with either an id or a path")
+	errRoutingInvalidGeolocation   = errors.New("geolocation must be either a continent code or a country code (or *)")
+
+	geoContinentCodes = []string{"AF", "AN", "AS", "EU", "NA", "OC", "SA"}
+)
+
+// routingPolicy is the route53 routing policy of a record. The zero value is
+// simple routing; records with a SetIdentifier use failover, latency or
+// geolocation routing if the respective fields are set, and weighted routing
+// otherwise.
+type routingPolicy struct {
+	SetIdentifier string
+	Weight        int64
+	Failover      string // PRIMARY or SECONDARY
+	Region        string
+	GeoContinent  string
+	GeoCountry    string
+	// HealthCheckID is the id of an existing health check to use. If it's
+	// not set, failover records get a health check managed by ingress53,
+	// against HealthCheckPath on the target or the target's port if empty.
+	HealthCheckID   string
+	HealthCheckPath string
+}
+
+// routingForIngress returns the routing policy requested by the annotations
+// of the ingress.
+func routingForIngress(ingress *v1beta1.Ingress) (routingPolicy, error) {
+	a := ingress.Annotations
+	rp := routingPolicy{
+		SetIdentifier:   a[setIdentifierAnnotation],
+		Region:          a[regionAnnotation],
+		GeoContinent:    strings.ToUpper(a[geoContinentAnnotation]),
+		GeoCountry:      strings.ToUpper(a[geoCountryAnnotation]),
+		HealthCheckID:   a[healthCheckIDAnnotation],
+		HealthCheckPath: a[healthCheckPathAnnotation],
+	}
+	w, weighted := a[weightAnnotation]
+	f, failover := a[failoverAnnotation]
+	policies := 0
+	for _, set := range []bool{weighted, failover, rp.Region != "", rp.GeoContinent != "" || rp.GeoCountry != ""} {
+		if set {
+			policies++
+		}
+	}
+	if policies > 1 {
+		return routingPolicy{}, errRoutingMultiplePolicies
+	}
+	if policies == 0 && rp.SetIdentifier != "" {
+		return routingPolicy{}, errRoutingMissingPolicy
+	}
+	if policies == 1 && rp.SetIdentifier == "" {
+		return routingPolicy{}, errRoutingMissingSetIdentifier
+	}
+	if (rp.HealthCheckID != "" || rp.HealthCheckPath != "") && (!failover || (rp.HealthCheckID != "" && rp.HealthCheckPath != "")) {
+		return routingPolicy{}, errRoutingInvalidHealthCheck
+	}
+	if weighted {
+		weight, err := strconv.ParseInt(w, 10, 64)
+		if err != nil || weight < 0 || weight > 255 {
+			return routingPolicy{}, errRoutingInvalidWeight
+		}
+		rp.Weight = weight
+	}
+	if failover {
+		switch strings.ToUpper(f) {
+		case route53.ResourceRecordSetFailoverPrimary, route53.ResourceRecordSetFailoverSecondary:
+			rp.Failover = strings.ToUpper(f)
+		default:
+			return routingPolicy{}, errRoutingInvalidFailover
+		}
+	}
+	if rp.GeoContinent != "" && (rp.GeoCountry != "" || !stringInSlice(rp.GeoContinent, geoContinentCodes)) {
+		return routingPolicy{}, errRoutingInvalidGeolocation
+	}
+	if rp.GeoCountry != "" && rp.GeoCountry != "*" && len(rp.GeoCountry) != 2 {
+		return routingPolicy{}, errRoutingInvalidGeolocation
+	}
+	return rp, nil
+}
+
+// kind returns the name of the routing policy.
+func (rp routingPolicy) kind() string {
+	switch {
+	case rp.SetIdentifier == "":
+		return routingSimple
+	case rp.Failover != "":
+		return routingFailover
+	case rp.Region != "":
+		return routingLatency
+	case rp.GeoContinent != "" || rp.GeoCountry != "":
+		return routingGeolocation
+	default:
+		return routingWeighted
+	}
+}
+
+// matches returns true if the current routing policy of a record is this one.
+// The ids of managed health checks are ignored, as they are only known once
+// the health checks are created.
+func (rp routingPolicy) matches(current routingPolicy) bool {
+	if rp.HealthCheckID == "" {
+		current.HealthCheckID = ""
+	}
+	return rp == current
+}
+
+// withRouting sets the routing policy of the records.
+func withRouting(records []dnsRecord, routing routingPolicy) []dnsRecord {
+	for i := range records {
+		records[i].Routing = routing
+	}
+	return records
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRoutingForIngress(t *testing.T) {
+	testCases := []struct {
+		annotations map[string]string
+		expected    routingPolicy
+		err         error
+	}{
+		{nil, routingPolicy{}, nil},
+		{map[string]string{setIdentifierAnnotation: "blue", weightAnnotation: "10"}, routingPolicy{SetIdentifier: "blue", Weight: 10}, nil},
+		{map[string]string{setIdentifierAnnotation: "blue", weightAnnotation: "0"}, routingPolicy{SetIdentifier: "blue"}, nil},
+		{map[string]string{weightAnnotation: "10"}, routingPolicy{}, errRoutingMissingSetIdentifier},
+		{map[string]string{setIdentifierAnnotation: "blue"}, routingPolicy{}, errRoutingMissingPolicy},
+		{map[string]string{setIdentifierAnnotation: "blue", weightAnnotation: "256"}, routingPolicy{}, errRoutingInvalidWeight},
+		{map[string]string{setIdentifierAnnotation: "blue", weightAnnotation: "ten"}, routingPolicy{}, errRoutingInvalidWeight},
+		{map[string]string{setIdentifierAnnotation: "blue", failoverAnnotation: "primary"}, routingPolicy{SetIdentifier: "blue", Failover: "PRIMARY"}, nil},
+		{map[string]string{setIdentifierAnnotation: "blue", failoverAnnotation: "Secondary", healthCheckPathAnnotation: "/healthz"}, routingPolicy{SetIdentifier: "blue", Failover: "SECONDARY", HealthCheckPath: "/healthz"}, nil},
+		{map[string]string{setIdentifierAnnotation: "blue", failoverAnnotation: "primary", healthCheckIDAnnotation: "abc"}, routingPolicy{SetIdentifier: "blue", Failover: "PRIMARY", HealthCheckID: "abc"}, nil},
+		{map[string]string{setIdentifierAnnotation: "blue", failoverAnnotation: "primary", healthCheckIDAnnotation: "abc", healthCheckPathAnnotation: "/healthz"}, routingPolicy{}, errRoutingInvalidHealthCheck},
+		{map[string]string{setIdentifierAnnotation: "blue", failoverAnnotation: "tertiary"}, routingPolicy{}, errRoutingInvalidFailover},
+		{map[string]string{setIdentifierAnnotation: "blue", failoverAnnotation: "primary", weightAnnotation: "10"}, routingPolicy{}, errRoutingMultiplePolicies},
+		{map[string]string{setIdentifierAnnotation: "blue", weightAnnotation: "10", healthCheckPathAnnotation: "/healthz"}, routingPolicy{}, errRoutingInvalidHealthCheck},
+		{map[string]string{failoverAnnotation: "primary"}, routingPolicy{}, errRoutingMissingSetIdentifier},
+		{map[string]string{setIdentifierAnnotation: "eu", regionAnnotation: "eu-west-1"}, routingPolicy{SetIdentifier: "eu", Region: "eu-west-1"}, nil},
+		{map[string]string{setIdentifierAnnotation: "eu", regionAnnotation: "eu-west-1", weightAnnotation: "10"}, routingPolicy{}, errRoutingMultiplePolicies},
+		{map[string]string{setIdentifierAnnotation: "eu", geoContinentAnnotation: "eu"}, routingPolicy{SetIdentifier: "eu", GeoContinent: "EU"}, nil},
+		{map[string]string{setIdentifierAnnotation: "gb", geoCountryAnnotation: "GB"}, routingPolicy{SetIdentifier: "gb", GeoCountry: "GB"}, nil},
+		{map[string]string{setIdentifierAnnotation: "default", geoCountryAnnotation: "*"}, routingPolicy{SetIdentifier: "default", GeoCountry: "*"}, nil},
+		{map[string]string{setIdentifierAnnotation: "eu", geoContinentAnnotation: "XX"}, routingPolicy{}, errRoutingInvalidGeolocation},
+		{map[string]string{setIdentifierAnnotation: "eu", geoContinentAnnotation: "EU", geoCountryAnnotation: "GB"}, routingPolicy{}, errRoutingInvalidGeolocation},
+		{map[string]string{setIdentifierAnnotation: "eu", geoCountryAnnotation: "GBR"}, routingPolicy{}, errRoutingInvalidGeolocation},
+		{map[string]string{setIdentifierAnnotation: "eu", geoCountryAnnotation: "GB", failoverAnnotation: "primary"}, routingPolicy{}, errRoutingMultiplePolicies},
+		{map[string]string{geoCountryAnnotation: "GB"}, routingPolicy{}, errRoutingMissingSetIdentifier},
+		{map[string]string{setIdentifierAnnotation: "eu", regionAnnotation: "eu-west-1", healthCheckPathAnnotation: "/healthz"}, routingPolicy{}, errRoutingInvalidHealthCheck},
+	}
+
+	for i, tc := range testCases {
+		ingress := &v1beta1.Ingress{ObjectMeta: v1.ObjectMeta{Name: "test", Annotations: tc.annotations}}
+		rp, err := routingForIngress(ingress)
+		if rp != tc.expected || err != tc.err {
+			t.Errorf("routingForIngress returned unexpected result for test case #%02d: %+v, %+v", i, rp, err)
+		}
+	}
+}
+
+func TestRoutingPolicy_matches(t *testing.T) {
+	testCases := []struct {
+		requested routingPolicy
+		current   routingPolicy
+		expected  bool
+	}{
+		{routingPolicy{}, routingPolicy{}, true},
+		{routingPolicy{SetIdentifier: "a", Weight: 1}, routingPolicy{SetIdentifier: "a", Weight: 2}, false},
+		{routingPolicy{SetIdentifier: "a", Failover: "PRIMARY"}, routingPolicy{SetIdentifier: "a", Failover: "PRIMARY", HealthCheckID: "managed"}, true},
+		{routingPolicy{SetIdentifier: "a", Failover: "PRIMARY", HealthCheckPath: "/healthz"}, routingPolicy{SetIdentifier: "a", Failover: "PRIMARY", HealthCheckID: "managed"}, false},
+		{routingPolicy{SetIdentifier: "a", Failover: "PRIMARY", HealthCheckID: "abc"}, routingPolicy{SetIdentifier: "a", Failover: "PRIMARY", HealthCheckID: "def"}, false},
+	}
+	for i, tc := range testCases {
+		if m := tc.requested.matches(tc.current); m != tc.expected {
+			t.Errorf("routingPolicy.matches returned unexpected result for test case #%02d: %v", i, m)
+		}
+	}
+}
+
+func TestRoutingPolicy_kind(t *testing.T) {
+	testCases := []struct {
+		routing  routingPolicy
+		expected string
+	}{
+		{routingPolicy{}, routingSimple},
+		{routingPolicy{SetIdentifier: "a", Weight: 0}, routingWeighted},
+		{routingPolicy{SetIdentifier: "a", Failover: "PRIMARY"}, routingFailover},
+		{routingPolicy{SetIdentifier: "a", Region: "eu-west-1"}, routingLatency},
+		{routingPolicy{SetIdentifier: "a", GeoCountry: "*"}, routingGeolocation},
+		{routingPolicy{SetIdentifier: "a", GeoContinent: "EU"}, routingGeolocation},
+	}
+	for i, tc := range testCases {
+		if k := tc.routing.kind(); k != tc.expected {
+			t.Errorf("routingPolicy.kind returned unexpected result for test case #%02d: %v", i, k)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	corev1 "k8s.io/api/core/v1"
+)
+
+var (
+	defaultVerifyInterval = 5 * time.Second
+	defaultVerifyTimeout  = 2 * time.Minute
+)
+
+// syncNotifier is implemented by zones that can report when a change they
+// applied asynchronously is in sync.
+type syncNotifier interface {
+	OnSync(f func(action string, records []dnsRecord))
+}
+
+// verifyRecord queries every nameserver and checks that they all serve the
+// expected values for the record.
+func verifyRecord(record dnsRecord, nameservers []string) error {
+	name := fmt.Sprintf("%s.", strings.Trim(record.Hostname, "."))
+	for _, ns := range nameservers {
+		values, err := resolveRecord(name, record.Type, []string{ns})
+		if err != nil {
+			return fmt.Errorf("nameserver %s: %v", ns, err)
+		}
+		if !valuesMatch(values, record.Values) {
+			return fmt.Errorf("nameserver %s returned %s", ns, strings.Join(values, ", "))
+		}
+	}
+	return nil
+}
+
+// verifyRecords checks that all the nameservers of the zone serve the
+// records, until they all do or the verification timeout passes, in which
+// case the records that have not converged are queued to be upserted again.
+func (r *registrator) verifyRecords(ctx context.Context, z dnsZone, records []dnsRecord) {
+	if listsPrivateZone(z, r.getOptions()) {
+		log.Printf("[DEBUG] will not verify the records of private zone %s: there are no private resolvers", z.Domain())
+		return
+	}
+	deadline := time.NewTimer(r.getOptions().VerifyTimeout)
+	tick := time.NewTicker(defaultVerifyInterval)
+	defer func() {
+		deadline.Stop()
+		tick.Stop()
+	}()
+	pending := []dnsRecord{}
+	for _, rec := range records {
+		if rec.Routing.SetIdentifier != "" {
+			log.Printf("[DEBUG] will not verify the %s record of %s: %v", rec.Type, rec.Hostname, errDNSRoutingPolicy)
+			continue
+		}
+		pending = append(pending, rec)
+	}
+	errs := map[string]error{}
+	for {
+		remaining := []dnsRecord{}
+		for _, p := range pending {
+			if err := verifyRecord(p, zoneNameservers(z, r.getOptions())); err != nil {
+				errs[p.Hostname] = err
+				remaining = append(remaining, p)
+				continue
+			}
+			log.Printf("[DEBUG] verified that all nameservers serve %s", p.Hostname)
+			metricRecordVerifications.WithLabelValues(p.Hostname, "verified").Inc()
+			r.recordEvent(p.Hostname, corev1.EventTypeNormal, "DNSVerified", fmt.Sprintf("all nameservers serve the %s record of %s pointing to %s", p.Type, p.Hostname, strings.Join(p.Values, ", ")))
+		}
+		pending = remaining
+		if len(pending) == 0 {
+			return
+		}
+		select {
+		case <-tick.C:
+		case <-deadline.C:
+			for _, p := range pending {
+				log.Printf("[ERROR] %s did not converge: %+v, will upsert it again", p.Hostname, errs[p.Hostname])
+				metricRecordVerifications.WithLabelValues(p.Hostname, "not_converged").Inc()
+				r.recordEvent(p.Hostname, corev1.EventTypeWarning, "DNSNotConverged", fmt.Sprintf("%s did not converge: %v", p.Hostname, errs[p.Hostname]))
+				select {
+				case r.updateQueue <- recordChange{route53.ChangeActionUpsert, p}:
+				case <-ctx.Done():
+					return
+				}
+			}
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// recordEvent records a kubernetes event on every ingress that claims the
+// hostname.
+func (r *registrator) recordEvent(hostname string, eventType string, reason string, message string) {
+	if r.recorder == nil || r.ingressWatcher == nil || r.ingressWatcher.store == nil {
+		return
+	}
+	for _, i := range r.ingressWatcher.HostnameIngresses(hostname) {
+		r.recorder.Event(i, eventType, reason, message)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestVerifyRecord(t *testing.T) {
+	servers, serverAddresses, err := startMockDNSServerFleet(map[string]string{"test.example.com.": "target.example.com."})
+	defer stopMockDNSServerFleet(servers)
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	if err := verifyRecord(newCnameRecord("test.example.com", "target.example.com"), serverAddresses); err != nil {
+		t.Errorf("verifyRecord returned unexpected error: %+v", err)
+	}
+	if err := verifyRecord(newCnameRecord("test.example.com", "other.example.com"), serverAddresses); err == nil {
+		t.Errorf("verifyRecord did not return expected error for a different target")
+	}
+
+	brokenServers, brokenServerAddresses, err := startMockSemiBrokenDNSServerFleet(map[string]string{"test.example.com.": "target.example.com."})
+	defer stopMockDNSServerFleet(brokenServers)
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	if err := verifyRecord(newCnameRecord("test.example.com", "target.example.com"), brokenServerAddresses); err == nil {
+		t.Errorf("verifyRecord did not return expected error for a semi-broken fleet")
+	}
+}
+
+func TestRegistrator_verifyRecords(t *testing.T) {
+	dvi := defaultVerifyInterval
+	defaultVerifyInterval = 10 * time.Millisecond
+	defer func() { defaultVerifyInterval = dvi }()
+
+	mdz := &mockDNSZone{domain: "example.com.", zoneData: map[string]string{
+		"a.example.com": testPrivateTarget,
+		"b.example.com": testPublicTarget,
+	}}
+	server, err := mdz.startMockDNSServer()
+	defer server.Shutdown()
+	if err != nil {
+		t.Fatalf("dnstest: unable to run test server: %v", err)
+	}
+
+	recorder := record.NewFakeRecorder(10)
+	r := &registrator{
+		zones:          []dnsZone{mdz},
+		updateQueue:    make(chan recordChange, 16),
+		ingressWatcher: &ingressWatcher{store: &mockStore{items: []interface{}{privateIngressHostsAB}}},
+		options:        registratorOptions{VerifyTimeout: 100 * time.Millisecond},
+		recorder:       recorder,
+	}
+
+	r.verifyRecords(context.Background(), mdz, []dnsRecord{
+		newCnameRecord("a.example.com", testPrivateTarget),
+		newCnameRecord("b.example.com", testPrivateTarget),
+	})
+
+	expected := []recordChange{{route53.ChangeActionUpsert, newCnameRecord("b.example.com", testPrivateTarget)}}
+	queued := []recordChange{}
+	for len(r.updateQueue) > 0 {
+		queued = append(queued, <-r.updateQueue)
+	}
+	if !reflect.DeepEqual(queued, expected) {
+		t.Errorf("verifyRecords queued unexpected changes: %+v", queued)
+	}
+
+	events := []string{}
+	for len(recorder.Events) > 0 {
+		events = append(events, strings.SplitN(<-recorder.Events, " ", 3)[1])
+	}
+	if !reflect.DeepEqual(events, []string{"DNSVerified", "DNSNotConverged"}) {
+		t.Errorf("verifyRecords recorded unexpected events: %+v", events)
+	}
+}
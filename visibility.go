@@ -0,0 +1,59 @@
+package main
+
+// privateZone is implemented by zones that can only be resolved from inside
+// a network, eg. route53 private hosted zones. Private zones may have the
+// same name as a public zone (split horizon), and their nameservers are not
+// reachable, so their records are checked through the private resolvers, or
+// listed from the provider if there are none.
+type privateZone interface {
+	IsPrivate() bool
+}
+
+func isPrivateZone(z dnsZone) bool {
+	pz, ok := z.(privateZone)
+	return ok && pz.IsPrivate()
+}
+
+// listsPrivateZone returns true if the records of the zone are listed
+// instead of queried, as it's a private zone and there are no resolvers that
+// can reach it.
+func listsPrivateZone(z dnsZone, options registratorOptions) bool {
+	return isPrivateZone(z) && len(options.PrivateResolvers) == 0
+}
+
+// zoneAcceptsRecord returns true if the record belongs in the zone: the zone
+// can handle its hostname and, for records of private targets, is private.
+// Records of public targets are written to both public and private zones.
+func zoneAcceptsRecord(z dnsZone, record dnsRecord) bool {
+	return zoneCanHandleRecord(z, record.Hostname) && (!record.Private || isPrivateZone(z))
+}
+
+// visibleRecords returns the records that belong in the zone.
+func visibleRecords(z dnsZone, records []dnsRecord) []dnsRecord {
+	ret := []dnsRecord{}
+	for _, rec := range records {
+		if zoneAcceptsRecord(z, rec) {
+			ret = append(ret, rec)
+		}
+	}
+	return ret
+}
+
+func (r *registrator) targetIsPrivate(target string) bool {
+	return stringInSlice(target, r.getOptions().PrivateTargets)
+}
+
+// withVisibility marks the records as private if the target is.
+func (r *registrator) withVisibility(records []dnsRecord, target string) []dnsRecord {
+	private := r.targetIsPrivate(target)
+	for i := range records {
+		records[i].Private = private
+	}
+	return records
+}
+
+// recordsForIngress returns the records an ingress with the target and
+// routing needs for the hostname.
+func (r *registrator) recordsForIngress(hostname string, target string, routing routingPolicy) []dnsRecord {
+	return r.withVisibility(withRouting(recordsForTarget(hostname, target), routing), target)
+}
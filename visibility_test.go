@@ -0,0 +1,111 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+	"k8s.io/api/extensions/v1beta1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type mockPrivateDNSZone struct {
+	*mockListingDNSZone
+}
+
+func (m *mockPrivateDNSZone) IsPrivate() bool { return true }
+
+func newMockSplitHorizonZones() (*mockListingDNSZone, *mockPrivateDNSZone) {
+	public := &mockListingDNSZone{mockDNSZone: &mockDNSZone{domain: "example.com.", zoneData: map[string]string{}}, records: newRecordCache()}
+	private := &mockPrivateDNSZone{&mockListingDNSZone{mockDNSZone: &mockDNSZone{domain: "example.com.", zoneData: map[string]string{}}, records: newRecordCache()}}
+	public.records.Replace([]zoneRecord{})
+	private.records.Replace([]zoneRecord{})
+	return public, private
+}
+
+func TestZoneAcceptsRecord(t *testing.T) {
+	public, private := newMockSplitHorizonZones()
+	testCases := []struct {
+		zone     dnsZone
+		record   dnsRecord
+		expected bool
+	}{
+		{public, newCnameRecord("a.example.com", testPublicTarget), true},
+		{private, newCnameRecord("a.example.com", testPublicTarget), true},
+		{public, dnsRecord{Hostname: "a.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Private: true}, false},
+		{private, dnsRecord{Hostname: "a.example.com", Type: "CNAME", Values: []string{testPrivateTarget}, Private: true}, true},
+		{private, dnsRecord{Hostname: "a.example.org", Type: "CNAME", Values: []string{testPrivateTarget}, Private: true}, false},
+	}
+	for i, tc := range testCases {
+		if a := zoneAcceptsRecord(tc.zone, tc.record); a != tc.expected {
+			t.Errorf("zoneAcceptsRecord returned unexpected result for test case #%02d: %v", i, a)
+		}
+	}
+}
+
+func TestZoneNameservers_private(t *testing.T) {
+	public, private := newMockSplitHorizonZones()
+	public.nameservers = []string{"10.0.0.1:53"}
+	options := registratorOptions{Resolvers: []string{"10.0.0.2:53"}, PrivateResolvers: []string{"10.0.0.3:53"}}
+	if ns := zoneNameservers(public, options); !reflect.DeepEqual(ns, options.Resolvers) {
+		t.Errorf("zoneNameservers returned unexpected nameservers for a public zone: %+v", ns)
+	}
+	if ns := zoneNameservers(private, options); !reflect.DeepEqual(ns, options.PrivateResolvers) {
+		t.Errorf("zoneNameservers returned unexpected nameservers for a private zone: %+v", ns)
+	}
+
+	// without private resolvers, the records of private zones are listed
+	private.records.Upsert(zoneRecord{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}})
+	options = registratorOptions{PruneSource: pruneSourceDNS, RecordTTL: 60}
+	values, err := currentValues(private, newCnameRecord("a.example.com", testPrivateTarget), options)
+	if err != nil || !reflect.DeepEqual(values, []string{testPrivateTarget}) {
+		t.Errorf("currentValues returned unexpected result for a private zone: %+v, %+v", values, err)
+	}
+}
+
+func TestRegistrator_applyBatch_splitHorizon(t *testing.T) {
+	public, private := newMockSplitHorizonZones()
+	sats, _ := buildSelectors(testTargetLabelName, []string{testPrivateTarget, testPublicTarget}, nil)
+	r := &registrator{
+		zones:          []dnsZone{public, private},
+		sats:           sats,
+		ingressWatcher: &ingressWatcher{store: &mockStore{}},
+		options:        registratorOptions{PruneSource: pruneSourceProvider, RecordTTL: 60, PrivateTargets: []string{testPrivateTarget}},
+	}
+
+	// records of private targets are only written to the private zone
+	changes := []recordChange{}
+	for _, rec := range append(r.recordsForIngress("a.example.com", testPrivateTarget, routingPolicy{}), r.recordsForIngress("b.example.com", testPublicTarget, routingPolicy{})...) {
+		changes = append(changes, recordChange{route53.ChangeActionUpsert, rec})
+	}
+	if applied, err := r.applyBatch(changes); applied != 3 || err != nil {
+		t.Errorf("applyBatch returned unexpected result: %d, %+v", applied, err)
+	}
+	if !reflect.DeepEqual(public.zoneData, map[string]string{"b.example.com": testPublicTarget}) {
+		t.Errorf("applyBatch wrote unexpected records to the public zone: %+v", public.zoneData)
+	}
+	if !reflect.DeepEqual(private.zoneData, map[string]string{"a.example.com": testPrivateTarget, "b.example.com": testPublicTarget}) {
+		t.Errorf("applyBatch wrote unexpected records to the private zone: %+v", private.zoneData)
+	}
+
+	// an ingress that moves to a private target keeps its record in the
+	// private zone, and is removed from the public one
+	for _, z := range []*mockListingDNSZone{public, private.mockListingDNSZone} {
+		z.records.Upsert(zoneRecord{Name: "b.example.com", Type: "CNAME", TTL: 60, Values: []string{testPublicTarget}})
+	}
+	moved := &v1beta1.Ingress{
+		ObjectMeta: v1.ObjectMeta{Name: "moved", Labels: map[string]string{testTargetLabelName: testPrivateTarget}},
+		Spec:       v1beta1.IngressSpec{Rules: []v1beta1.IngressRule{{Host: "b.example.com"}}},
+	}
+	r.ingressWatcher.store = &mockStore{items: []interface{}{moved}}
+	stale := r.recordsForIngress("b.example.com", testPublicTarget, routingPolicy{})
+	if applied, err := r.applyBatch([]recordChange{{route53.ChangeActionDelete, stale[0]}}); applied != 1 || err != nil {
+		t.Errorf("applyBatch returned unexpected result: %d, %+v", applied, err)
+	}
+	if len(public.zoneData) != 0 {
+		t.Errorf("applyBatch did not delete the record from the public zone: %+v", public.zoneData)
+	}
+	if _, ok := private.zoneData["b.example.com"]; !ok {
+		t.Errorf("applyBatch deleted the record from the private zone: %+v", private.zoneData)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+const providerWebhook = "webhook"
+
+var (
+	errWebhookMissingURL     = errors.New("the webhook provider requires a url")
+	errWebhookMissingListURL = errors.New("the webhook provider requires a list url to be the prune source")
+	errWebhookInvalidHeader  = errors.New("webhook headers must be in the name: value format")
+
+	defaultWebhookRetries = 3
+	defaultWebhookTimeout = 10 * time.Second
+	webhookRetryBackoff   = time.Second
+	webhookMaxResponse    = int64(10 << 20)
+)
+
+func init() {
+	registerProvider(providerWebhook, newWebhookProvider)
+}
+
+// webhookOptions are the settings of the webhook provider.
+type webhookOptions struct {
+	URL     string            `json:"url"`     // endpoint the change sets are posted to
+	ListURL string            `json:"listURL"` // endpoint the records of the zones are listed from, optional
+	Headers map[string]string `json:"headers"` // sent with every request, eg. Authorization
+	Retries *int              `json:"retries"` // defaults to 3, 0 disables retries
+}
+
+// webhookChangeSet is the body of the requests to the url: the changes of a
+// batch, which should be applied atomically.
+type webhookChangeSet struct {
+	Zone    string          `json:"zone"`
+	Changes []webhookChange `json:"changes"`
+}
+
+// webhookChange replaces all the values of the record on UPSERT, and removes
+// the record on DELETE.
+type webhookChange struct {
+	Action string   `json:"action"` // UPSERT or DELETE
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	TTL    int64    `json:"ttl"`
+	Values []string `json:"values"`
+}
+
+// webhookRecordList is the body of the responses of the list url.
+type webhookRecordList struct {
+	Records []webhookRecord `json:"records"`
+}
+
+type webhookRecord struct {
+	Name   string   `json:"name"`
+	Type   string   `json:"type"`
+	TTL    int64    `json:"ttl"`
+	Values []string `json:"values"`
+}
+
+// webhookError is returned for responses with an unexpected status.
+type webhookError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *webhookError) Error() string {
+	return fmt.Sprintf("webhook returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// retryable returns true if the request may succeed if sent again: server
+// errors and throttling are retried, other client errors are not.
+func (e *webhookError) retryable() bool {
+	return e.StatusCode >= 500 || e.StatusCode == http.StatusTooManyRequests
+}
+
+// webhookProvider manages zones through an http api, so that any dns service
+// can be plugged in without changes to ingress53. The changes of each batch
+// are posted as a json change set, and the records are listed from a
+// separate endpoint.
+type webhookProvider struct {
+	url     string
+	listURL string
+	headers map[string]string
+	retries int
+	client  *http.Client
+}
+
+func newWebhookProvider(options registratorOptions) (dnsProvider, error) {
+	o := options.Webhook
+	if o.URL == "" {
+		return nil, errWebhookMissingURL
+	}
+	if options.PruneSource == pruneSourceProvider && o.ListURL == "" {
+		return nil, errWebhookMissingListURL
+	}
+	for _, u := range []string{o.URL, o.ListURL} {
+		if u == "" {
+			continue
+		}
+		if _, err := url.Parse(u); err != nil {
+			return nil, err
+		}
+	}
+	p := &webhookProvider{
+		url:     o.URL,
+		listURL: o.ListURL,
+		headers: o.Headers,
+		retries: defaultWebhookRetries,
+		client:  &http.Client{Timeout: defaultWebhookTimeout},
+	}
+	if o.Retries != nil {
+		p.retries = *o.Retries
+	}
+	log.Printf("[INFO] setup webhook provider for %s", p.url)
+	return p, nil
+}
+
+func (p *webhookProvider) NewZone(ctx context.Context, id string, options registratorOptions) (dnsZone, error) {
+	// the prune source can be changed on reload, the list url cannot
+	if options.PruneSource == pruneSourceProvider && p.listURL == "" {
+		return nil, errWebhookMissingListURL
+	}
+	z := &webhookZone{
+		provider: p,
+		Name:     strings.Trim(id, "."),
+		TTL:      options.RecordTTL,
+		records:  newRecordCache(),
+	}
+	if options.PruneSource == pruneSourceProvider {
+		z.RecordsRefreshInterval = options.RecordsRefresh
+	}
+	return z, nil
+}
+
+func (p *webhookProvider) Capabilities() providerCapabilities {
+	return providerCapabilities{TXT: true}
+}
+
+// do sends the request, retrying with an exponential backoff on network
+// errors and retryable responses, and decodes the json response into out,
+// if set.
+func (p *webhookProvider) do(ctx context.Context, method string, u string, body []byte, out interface{}) error {
+	backoff := webhookRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := p.send(ctx, method, u, body, out)
+		if err == nil {
+			return nil
+		}
+		if we, ok := err.(*webhookError); (ok && !we.retryable()) || attempt >= p.retries {
+			return err
+		}
+		log.Printf("[DEBUG] webhook %s %s failed, will retry in %s: %+v", method, u, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func (p *webhookProvider) send(ctx context.Context, method string, u string, body []byte, out interface{}) error {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range p.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, webhookMaxResponse))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return &webhookError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(data))}
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// webhookZone is a zone managed through the webhook provider.
+type webhookZone struct {
+	provider *webhookProvider
+	Name     string
+	TTL      int64
+	// RecordsRefreshInterval is how often the records of the zone are listed
+	// while running; if zero they are never listed.
+	RecordsRefreshInterval time.Duration
+	records                *recordCache
+}
+
+func (z *webhookZone) UpsertRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionUpsert, records)
+}
+
+func (z *webhookZone) DeleteRecords(ctx context.Context, records []dnsRecord) error {
+	return z.changeRecords(ctx, route53.ChangeActionDelete, records)
+}
+
+// changeRecords posts all the records as a single change set.
+func (z *webhookZone) changeRecords(ctx context.Context, action string, records []dnsRecord) error {
+	cs := webhookChangeSet{Zone: z.Name, Changes: []webhookChange{}}
+	for _, r := range records {
+		cs.Changes = append(cs.Changes, webhookChange{
+			Action: action,
+			Name:   strings.Trim(r.Hostname, "."),
+			Type:   r.Type,
+			TTL:    z.TTL,
+			Values: r.Values,
+		})
+	}
+	body, err := json.Marshal(cs)
+	if err != nil {
+		return err
+	}
+	if err := z.provider.do(ctx, http.MethodPost, z.provider.url, body, nil); err != nil {
+		return err
+	}
+	for _, r := range records {
+		if action == route53.ChangeActionDelete {
+			z.records.Delete(r.Hostname, r.Type, "")
+		} else {
+			z.records.Upsert(zoneRecord{Name: r.Hostname, Type: r.Type, TTL: z.TTL, Values: r.Values})
+		}
+	}
+	return nil
+}
+
+// Run lists the records of the zone periodically, if required.
+func (z *webhookZone) Run(ctx context.Context) {
+	if z.RecordsRefreshInterval == 0 {
+		return
+	}
+	tick := time.NewTicker(z.RecordsRefreshInterval)
+	defer tick.Stop()
+	for {
+		if err := z.refreshRecords(ctx); err != nil {
+			log.Printf("[ERROR] could not list the records of zone %s: %+v", z.Name, err)
+		}
+		select {
+		case <-tick.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// WaitForChanges returns straight away, as the changes are applied by the
+// time the webhook responds.
+func (z *webhookZone) WaitForChanges(ctx context.Context) error {
+	return nil
+}
+
+// refreshRecords lists all the records of the zone and replaces the cached
+// ones.
+func (z *webhookZone) refreshRecords(ctx context.Context) error {
+	u, err := url.Parse(z.provider.listURL)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("zone", z.Name)
+	u.RawQuery = q.Encode()
+	list := webhookRecordList{}
+	if err := z.provider.do(ctx, http.MethodGet, u.String(), nil, &list); err != nil {
+		return err
+	}
+	records := make([]zoneRecord, len(list.Records))
+	for i, r := range list.Records {
+		records[i] = zoneRecord{Name: r.Name, Type: r.Type, TTL: r.TTL, Values: r.Values}
+	}
+	z.records.Replace(records)
+	log.Printf("[DEBUG] listed %d record(s) of zone %s", len(records), z.Name)
+	return nil
+}
+
+func (z *webhookZone) LookupRecords(name string) ([]zoneRecord, error) {
+	return z.records.Lookup(name)
+}
+
+func (z *webhookZone) Domain() string {
+	return z.Name
+}
+
+// ListNameservers returns no nameservers, as the ones serving the zone are
+// not known: the records are checked by listing them or by querying the
+// resolvers instead.
+func (z *webhookZone) ListNameservers() []string {
+	return nil
+}
+
+// parseWebhookHeaders parses headers in the name: value format.
+func parseWebhookHeaders(headers []string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, errWebhookInvalidHeader
+		}
+		ret[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	return ret, nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestNewWebhookProvider(t *testing.T) {
+	testCases := []struct {
+		options registratorOptions
+		retries int
+		err     error
+	}{
+		{registratorOptions{}, 0, errWebhookMissingURL},
+		{registratorOptions{Webhook: webhookOptions{URL: "http://dns.example.com/changes"}}, defaultWebhookRetries, nil},
+		{registratorOptions{Webhook: webhookOptions{URL: "http://dns.example.com/changes", Retries: aws.Int(5)}}, 5, nil},
+		{registratorOptions{Webhook: webhookOptions{URL: "http://dns.example.com/changes", Retries: aws.Int(0)}}, 0, nil},
+		{registratorOptions{PruneSource: pruneSourceProvider, Webhook: webhookOptions{URL: "http://dns.example.com/changes"}}, 0, errWebhookMissingListURL},
+		{registratorOptions{PruneSource: pruneSourceProvider, Webhook: webhookOptions{URL: "http://dns.example.com/changes", ListURL: "http://dns.example.com/records"}}, defaultWebhookRetries, nil},
+	}
+	for i, tc := range testCases {
+		p, err := newWebhookProvider(tc.options)
+		if err != tc.err {
+			t.Errorf("newWebhookProvider returned unexpected error for test case #%02d: %+v", i, err)
+			continue
+		}
+		if err == nil && p.(*webhookProvider).retries != tc.retries {
+			t.Errorf("newWebhookProvider returned unexpected retries for test case #%02d: %d", i, p.(*webhookProvider).retries)
+		}
+	}
+
+	// the prune source may be changed on reload
+	p, err := newWebhookProvider(registratorOptions{Webhook: webhookOptions{URL: "http://dns.example.com/changes"}})
+	if err != nil {
+		t.Fatalf("newWebhookProvider returned unexpected error: %+v", err)
+	}
+	if _, err := p.NewZone(context.Background(), "example.com", registratorOptions{PruneSource: pruneSourceProvider}); err != errWebhookMissingListURL {
+		t.Errorf("webhookProvider.NewZone returned unexpected error without a list url: %+v", err)
+	}
+}
+
+func TestParseWebhookHeaders(t *testing.T) {
+	testCases := []struct {
+		headers  []string
+		expected map[string]string
+		err      error
+	}{
+		{nil, map[string]string{}, nil},
+		{[]string{"Authorization: Bearer abc", "X-Team:dns"}, map[string]string{"Authorization": "Bearer abc", "X-Team": "dns"}, nil},
+		{[]string{"Accept: application/json, text/plain"}, map[string]string{"Accept": "application/json, text/plain"}, nil},
+		{[]string{"Authorization"}, nil, errWebhookInvalidHeader},
+		{[]string{": value"}, nil, errWebhookInvalidHeader},
+	}
+	for i, tc := range testCases {
+		// the values of the -webhook-header flag are taken whole
+		var flagValue strlist
+		for _, h := range tc.headers {
+			flagValue.Set(h)
+		}
+		headers, err := parseWebhookHeaders(flagValue)
+		if err != tc.err || !reflect.DeepEqual(headers, tc.expected) {
+			t.Errorf("parseWebhookHeaders returned unexpected result for test case #%02d: %+v, %+v", i, headers, err)
+		}
+	}
+}
+
+// mockWebhook is a webhook endpoint that stores the change sets it receives,
+// failing the first requests with the statuses.
+type mockWebhook struct {
+	mu         sync.Mutex
+	statuses   []int
+	changeSets []webhookChangeSet
+	auth       []string
+	records    []webhookRecord
+}
+
+func (m *mockWebhook) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auth = append(m.auth, req.Header.Get("Authorization"))
+	if len(m.statuses) > 0 {
+		status := m.statuses[0]
+		m.statuses = m.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	if req.Method == http.MethodGet {
+		if req.URL.Query().Get("zone") != "example.com" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(webhookRecordList{Records: m.records})
+		return
+	}
+	cs := webhookChangeSet{}
+	if err := json.NewDecoder(req.Body).Decode(&cs); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	m.changeSets = append(m.changeSets, cs)
+}
+
+func TestWebhookZone(t *testing.T) {
+	backoff := webhookRetryBackoff
+	webhookRetryBackoff = time.Millisecond
+	defer func() { webhookRetryBackoff = backoff }()
+
+	m := &mockWebhook{records: []webhookRecord{{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}}}}
+	server := httptest.NewServer(m)
+	defer server.Close()
+
+	options := registratorOptions{
+		RecordTTL:      60,
+		PruneSource:    pruneSourceProvider,
+		RecordsRefresh: time.Minute,
+		Webhook: webhookOptions{
+			URL:     server.URL + "/changes",
+			ListURL: server.URL + "/records",
+			Headers: map[string]string{"Authorization": "Bearer abc"},
+			Retries: aws.Int(2),
+		},
+	}
+	p, err := newWebhookProvider(options)
+	if err != nil {
+		t.Fatalf("newWebhookProvider returned unexpected error: %+v", err)
+	}
+	dz, err := p.NewZone(context.Background(), "example.com.", options)
+	if err != nil {
+		t.Fatalf("webhookProvider.NewZone returned unexpected error: %+v", err)
+	}
+	z := dz.(*webhookZone)
+	if z.RecordsRefreshInterval != time.Minute {
+		t.Errorf("webhookProvider.NewZone did not set the refresh interval: %s", z.RecordsRefreshInterval)
+	}
+
+	// server errors are retried
+	m.statuses = []int{http.StatusInternalServerError, http.StatusTooManyRequests}
+	ips := dnsRecord{Hostname: "b.example.com.", Type: "A", Values: []string{"10.0.0.1", "10.0.0.2"}}
+	if err := z.UpsertRecords(context.Background(), []dnsRecord{ips}); err != nil {
+		t.Fatalf("webhookZone.UpsertRecords returned unexpected error: %+v", err)
+	}
+	expected := []webhookChangeSet{{Zone: "example.com", Changes: []webhookChange{{Action: "UPSERT", Name: "b.example.com", Type: "A", TTL: 60, Values: ips.Values}}}}
+	if !reflect.DeepEqual(m.changeSets, expected) {
+		t.Errorf("webhookZone.UpsertRecords posted unexpected change sets: %+v", m.changeSets)
+	}
+	if !reflect.DeepEqual(m.auth, []string{"Bearer abc", "Bearer abc", "Bearer abc"}) {
+		t.Errorf("webhook provider sent unexpected authorization headers: %+v", m.auth)
+	}
+
+	// client errors are not
+	m.statuses = []int{http.StatusBadRequest, http.StatusBadRequest}
+	err = z.DeleteRecords(context.Background(), []dnsRecord{ips})
+	if we, ok := err.(*webhookError); !ok || we.StatusCode != http.StatusBadRequest || len(m.statuses) != 1 {
+		t.Errorf("webhookZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+
+	// the retries are limited
+	m.statuses = []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	err = z.DeleteRecords(context.Background(), []dnsRecord{ips})
+	if we, ok := err.(*webhookError); !ok || we.StatusCode != http.StatusBadGateway || len(m.statuses) != 0 {
+		t.Errorf("webhookZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+
+	if err := z.refreshRecords(context.Background()); err != nil {
+		t.Fatalf("webhookZone.refreshRecords returned unexpected error: %+v", err)
+	}
+	records, err := z.LookupRecords("a.example.com.")
+	if err != nil || !reflect.DeepEqual(records, []zoneRecord{{Name: "a.example.com", Type: "CNAME", TTL: 60, Values: []string{testPrivateTarget}}}) {
+		t.Errorf("webhookZone.LookupRecords returned unexpected result: %+v, %+v", records, err)
+	}
+	if err := z.DeleteRecords(context.Background(), []dnsRecord{newCnameRecord("a.example.com", testPrivateTarget)}); err != nil {
+		t.Fatalf("webhookZone.DeleteRecords returned unexpected error: %+v", err)
+	}
+	if records, _ := z.LookupRecords("a.example.com"); len(records) != 0 {
+		t.Errorf("webhookZone.LookupRecords returned a deleted record: %+v", records)
+	}
+}